@@ -0,0 +1,205 @@
+package bstudio
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix      = "apikey/"
+	apiKeyTokenPrefix = "bsk_"
+
+	ScopeUploadAudio   = "upload:audio"
+	ScopeUploadVideo   = "upload:video"
+	ScopeUploadImage   = "upload:image"
+	ScopeManifestWrite = "manifest:write"
+	ScopeJobsRead      = "jobs:read"
+	ScopeAdmin         = "admin" // grants every scope
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []string{ScopeUploadAudio, ScopeUploadVideo, ScopeUploadImage, ScopeManifestWrite, ScopeJobsRead, ScopeAdmin}
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+)
+
+// APIKey is the stored part of an API key: only the hash of its secret is kept,
+// the token itself is shown once at creation.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// HasScope reports whether the key grants scope, admin grants them all.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseScopes splits a comma separated list of scopes, rejecting the unknown ones.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+
+		var known bool
+		for _, k := range Scopes {
+			if k == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope %s, must be one of %s", scope, strings.Join(Scopes, ", "))
+		}
+		scopes = append(scopes, scope)
+	}
+
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	sort.Strings(scopes)
+
+	return scopes, nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey stores a new key and returns the token to hand over, bsk_<id>.<secret>.
+func (bs *BStudio) CreateAPIKey(name string, scopes []string) (string, *APIKey, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	k := &APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	secretStr := base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = hashAPIKeySecret(secretStr)
+
+	if err := bs.saveAPIKey(k); err != nil {
+		return "", nil, err
+	}
+
+	return apiKeyTokenPrefix + k.ID + "." + secretStr, k, nil
+}
+
+func (bs *BStudio) saveAPIKey(k *APIKey) error {
+	bz, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	return bs.Ds.SetAndCommit([]byte(apiKeyPrefix+k.ID), bz)
+}
+
+func (bs *BStudio) GetAPIKey(id string) (*APIKey, error) {
+	bz, err := bs.Ds.Get([]byte(apiKeyPrefix + id))
+	if err != nil {
+		return nil, err
+	}
+	if len(bz) == 0 {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	var k APIKey
+	if err := json.Unmarshal(bz, &k); err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// ListAPIKeys returns every key, revoked ones included.
+func (bs *BStudio) ListAPIKeys() ([]*APIKey, error) {
+	var keys []*APIKey
+	err := bs.Ds.Iterate([]byte(apiKeyPrefix), func(key, val []byte) error {
+		var k APIKey
+		if err := json.Unmarshal(val, &k); err != nil {
+			return err
+		}
+		keys = append(keys, &k)
+		return nil
+	})
+
+	return keys, err
+}
+
+// RevokeAPIKey disables the key, it is kept so that the jobs it created stay attributed.
+func (bs *BStudio) RevokeAPIKey(id string) error {
+	k, err := bs.GetAPIKey(id)
+	if err != nil {
+		return err
+	}
+	if k.Revoked() {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	k.RevokedAt = &now
+
+	return bs.saveAPIKey(k)
+}
+
+// AuthenticateAPIKey returns the active key matching token.
+func (bs *BStudio) AuthenticateAPIKey(token string) (*APIKey, error) {
+	if !strings.HasPrefix(token, apiKeyTokenPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, apiKeyTokenPrefix), ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	k, err := bs.GetAPIKey(parts[0])
+	if err == ErrAPIKeyNotFound {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashAPIKeySecret(parts[1]))) != 1 || k.Revoked() {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return k, nil
+}
@@ -0,0 +1,56 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeys_Authenticate(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	token, k, err := bs.CreateAPIKey("label", []string{ScopeUploadAudio})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, "bsk_"+k.ID+"."))
+	require.NotContains(t, k.Hash, strings.Split(token, ".")[1])
+
+	got, err := bs.AuthenticateAPIKey(token)
+	require.NoError(t, err)
+	require.Equal(t, k.ID, got.ID)
+	require.True(t, got.HasScope(ScopeUploadAudio))
+	require.False(t, got.HasScope(ScopeUploadImage))
+
+	for _, bad := range []string{"", "bsk_", "bsk_" + k.ID, token + "x", "bsk_unknown.secret"} {
+		_, err = bs.AuthenticateAPIKey(bad)
+		require.Equal(t, ErrInvalidAPIKey, err, bad)
+	}
+
+	require.NoError(t, bs.RevokeAPIKey(k.ID))
+	_, err = bs.AuthenticateAPIKey(token)
+	require.Equal(t, ErrInvalidAPIKey, err)
+
+	keys, err := bs.ListAPIKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.True(t, keys[0].Revoked())
+
+	require.Equal(t, ErrAPIKeyNotFound, bs.RevokeAPIKey("unknown"))
+}
+
+func TestAPIKeys_Scopes(t *testing.T) {
+	scopes, err := ParseScopes("upload:image, jobs:read,")
+	require.NoError(t, err)
+	require.Equal(t, []string{ScopeJobsRead, ScopeUploadImage}, scopes)
+
+	_, err = ParseScopes("upload:everything")
+	require.Error(t, err)
+	_, err = ParseScopes("")
+	require.Error(t, err)
+
+	admin := &APIKey{Scopes: []string{ScopeAdmin}}
+	for _, s := range Scopes {
+		require.True(t, admin.HasScope(s))
+	}
+}
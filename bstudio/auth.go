@@ -0,0 +1,190 @@
+package bstudio
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	authChallengePrefix = "authchallenge/"
+	authChallengeTTL    = 5 * time.Minute
+
+	DefaultSessionTTL = time.Hour
+)
+
+// WalletScopes are granted to the artists signing in with their wallet.
+var WalletScopes = []string{ScopeUploadAudio, ScopeUploadVideo, ScopeUploadImage, ScopeManifestWrite, ScopeJobsRead}
+
+var (
+	ErrInvalidAddress   = errors.New("invalid address")
+	ErrInvalidChallenge = errors.New("unknown or expired challenge")
+	ErrLoginDisabled    = errors.New("wallet login is not configured")
+)
+
+// Principal is the authenticated caller of a request, by API key or by wallet session.
+type Principal struct {
+	APIKeyID string   `json:"api_key_id,omitempty"`
+	Address  string   `json:"address,omitempty"`
+	Scopes   []string `json:"scopes"`
+}
+
+// HasScope reports whether the principal was granted scope, admin grants them all.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Owns reports whether the principal may see or change what owner created:
+// API keys are trusted services, wallet sessions are limited to their address.
+func (p *Principal) Owns(owner string) bool {
+	return p.Address == "" || p.Address == owner || p.HasScope(ScopeAdmin)
+}
+
+// Created reports whether owner, with the API key apiKeyID, created something on behalf of the principal:
+// unlike Owns, an API key only gets what was uploaded with it.
+func (p *Principal) Created(owner, apiKeyID string) bool {
+	switch {
+	case p.HasScope(ScopeAdmin):
+		return true
+	case p.Address != "":
+		return p.Address == owner
+	default:
+		return p.APIKeyID == apiKeyID
+	}
+}
+
+// ClientID identifies the principal in rate limits and quotas.
+func (p *Principal) ClientID() string {
+	return ClientID(p.APIKeyID, p.Address)
+}
+
+// ClientID is key:<id> for API keys and address:<address> for wallet sessions, empty for neither.
+func ClientID(apiKeyID, address string) string {
+	switch {
+	case address != "":
+		return "address:" + address
+	case apiKeyID != "":
+		return "key:" + apiKeyID
+	}
+
+	return ""
+}
+
+// AuthChallenge is the message a wallet signs (ADR-036) to prove it controls address.
+type AuthChallenge struct {
+	Address   string    `json:"address"`
+	Nonce     string    `json:"nonce"`
+	Message   string    `json:"message"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Authenticate resolves an API key or a session token to its principal.
+func (bs *BStudio) Authenticate(token string) (*Principal, error) {
+	if strings.HasPrefix(token, apiKeyTokenPrefix) {
+		k, err := bs.AuthenticateAPIKey(token)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{APIKeyID: k.ID, Scopes: k.Scopes}, nil
+	}
+
+	if bs.SessionSecret == nil {
+		return nil, ErrInvalidToken
+	}
+	claims, err := ParseJWT(bs.SessionSecret, token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{Address: claims.Subject, Scopes: claims.Scopes()}, nil
+}
+
+// CreateAuthChallenge stores a single use nonce for address.
+func (bs *BStudio) CreateAuthChallenge(address string) (*AuthChallenge, error) {
+	if bs.SessionSecret == nil {
+		return nil, ErrLoginDisabled
+	}
+	if hrp, _, err := Bech32Decode(address); err != nil || hrp != AddressPrefix {
+		return nil, ErrInvalidAddress
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	c := &AuthChallenge{
+		Address:   address,
+		Nonce:     hex.EncodeToString(nonce),
+		ExpiresAt: time.Now().UTC().Add(authChallengeTTL),
+	}
+	c.Message = fmt.Sprintf("Sign in to BStudio\n\nAddress: %s\nNonce: %s", c.Address, c.Nonce)
+
+	bz, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, bs.Ds.SetWithTTL([]byte(authChallengePrefix+c.Nonce), bz, authChallengeTTL)
+}
+
+// Login consumes the challenge nonce and returns a session token for its address
+// when sig is the signature of the challenge message by that address.
+func (bs *BStudio) Login(nonce string, sig *SignatureEnvelope) (string, *JWTClaims, error) {
+	if bs.SessionSecret == nil {
+		return "", nil, ErrLoginDisabled
+	}
+
+	bz, err := bs.Ds.Get([]byte(authChallengePrefix + nonce))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(bz) == 0 {
+		return "", nil, ErrInvalidChallenge
+	}
+	// a challenge is only good for one attempt
+	if err := bs.Ds.Delete([]byte(authChallengePrefix + nonce)); err != nil {
+		return "", nil, err
+	}
+
+	var c AuthChallenge
+	if err := json.Unmarshal(bz, &c); err != nil {
+		return "", nil, err
+	}
+	if time.Now().After(c.ExpiresAt) {
+		return "", nil, ErrInvalidChallenge
+	}
+
+	if sig.Signer != c.Address {
+		return "", nil, fmt.Errorf("%w: the challenge was issued to %s", ErrInvalidSignature, c.Address)
+	}
+	if err := sig.Verify([]byte(c.Message)); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &JWTClaims{
+		Issuer:    jwtIssuer,
+		Subject:   c.Address,
+		Scope:     strings.Join(WalletScopes, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(bs.SessionTTL).Unix(),
+	}
+
+	token, err := SignJWT(bs.SessionSecret, claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, claims, nil
+}
@@ -0,0 +1,90 @@
+package bstudio
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestAuth_WalletLogin(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds, SessionSecret: []byte("secret"), SessionTTL: time.Hour}
+
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	addr, err := PubKeyAddress(key.PubKey().SerializeCompressed())
+	require.NoError(t, err)
+
+	c, err := bs.CreateAuthChallenge(addr)
+	require.NoError(t, err)
+	require.Contains(t, c.Message, c.Nonce)
+
+	token, claims, err := bs.Login(c.Nonce, signADR036(t, key, addr, []byte(c.Message)))
+	require.NoError(t, err)
+	require.Equal(t, addr, claims.Subject)
+
+	p, err := bs.Authenticate(token)
+	require.NoError(t, err)
+	require.Equal(t, addr, p.Address)
+	require.True(t, p.HasScope(ScopeUploadAudio))
+	require.False(t, p.HasScope(ScopeAdmin))
+	require.True(t, p.Owns(addr))
+	require.False(t, p.Owns("bitsong1other"))
+
+	// the nonce is single use
+	_, _, err = bs.Login(c.Nonce, signADR036(t, key, addr, []byte(c.Message)))
+	require.Equal(t, ErrInvalidChallenge, err)
+}
+
+func TestAuth_WalletLoginRejected(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds, SessionSecret: []byte("secret"), SessionTTL: time.Hour}
+
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	addr, err := PubKeyAddress(key.PubKey().SerializeCompressed())
+	require.NoError(t, err)
+	other, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	otherAddr, err := PubKeyAddress(other.PubKey().SerializeCompressed())
+	require.NoError(t, err)
+
+	_, err = bs.CreateAuthChallenge("cosmos1invalid")
+	require.Equal(t, ErrInvalidAddress, err)
+
+	// signed by another wallet than the one the challenge was issued to
+	c, err := bs.CreateAuthChallenge(addr)
+	require.NoError(t, err)
+	_, _, err = bs.Login(c.Nonce, signADR036(t, other, otherAddr, []byte(c.Message)))
+	require.Error(t, err)
+
+	// signed another message
+	c, err = bs.CreateAuthChallenge(addr)
+	require.NoError(t, err)
+	_, _, err = bs.Login(c.Nonce, signADR036(t, key, addr, []byte("something else")))
+	require.Error(t, err)
+
+	_, err = (&BStudio{Ds: ds}).CreateAuthChallenge(addr)
+	require.Equal(t, ErrLoginDisabled, err)
+}
+
+func TestAuth_APIKeyPrincipal(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	token, k, err := bs.CreateAPIKey("label", []string{ScopeJobsRead})
+	require.NoError(t, err)
+
+	p, err := bs.Authenticate(token)
+	require.NoError(t, err)
+	require.Equal(t, k.ID, p.APIKeyID)
+	require.Empty(t, p.Address)
+	require.True(t, p.Owns("bitsong1anyone"))
+
+	_, err = bs.Authenticate("not.a.jwt")
+	require.Equal(t, ErrInvalidToken, err)
+}
@@ -0,0 +1,124 @@
+package bstudio
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	res := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]>>5)
+	}
+	res = append(res, 0)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]&31)
+	}
+
+	return res
+}
+
+// convertBits regroups data from groups of fromBits to groups of toBits.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	var res []byte
+	maxv := uint(1)<<toBits - 1
+
+	for _, v := range data {
+		if uint(v)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			res = append(res, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			res = append(res, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+
+	return res, nil
+}
+
+// Bech32Encode encodes data, e.g. an account address, with the human readable part hrp.
+func Bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	polymod := bech32Polymod(append(append(bech32HrpExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i))&31))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+
+	return sb.String(), nil
+}
+
+// Bech32Decode returns the human readable part and the data of a bech32 string.
+func Bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for _, c := range s[pos+1:] {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", c)
+		}
+		values = append(values, byte(i))
+	}
+
+	if bech32Polymod(append(bech32HrpExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return hrp, data, nil
+}
@@ -1,18 +1,105 @@
 package bstudio
 
 import (
+	"context"
+	"encoding/json"
 	shell "github.com/ipfs/go-ipfs-api"
+	"image/color"
 	"io"
+	"os"
+	"sync"
+	"time"
 )
 
-const (
-	maxTranscoderQueue = 1
-)
+// TmpDir holds the files being processed, the system temp directory by default.
+var TmpDir = os.TempDir()
 
 type BStudio struct {
-	sh     *shell.Shell
-	TQueue chan *Transcoder
-	Ds     *Ds
+	sh              *shell.Shell
+	Ds              *Ds
+	HlsProfiles     []HlsProfile
+	VideoProfiles   []VideoProfile
+	ImagePresets    []ImagePreset
+	ImageBackground color.RGBA
+	DuplicatePolicy DuplicatePolicy
+	ImageSizes      []uint
+	ImageMaxPixels  int
+	// KeepImageOriginal publishes the full resolution image next to the renditions
+	KeepImageOriginal bool
+	ManifestCodec     string
+	PublishIPNS       bool
+
+	// Auth requires an API key or a session with the right scope on the protected routes
+	Auth bool
+
+	// SessionSecret signs the wallet session tokens, wallet login is disabled when nil
+	SessionSecret []byte
+	SessionTTL    time.Duration
+
+	// RequireSignatures rejects the manifests not signed by one of their artists
+	RequireSignatures bool
+
+	// Executor runs ffmpeg and ffprobe
+	Executor *Executor
+
+	// UploadMemory and ImageUploadMemory are the bytes of a multipart upload kept in memory
+	UploadMemory      int64
+	ImageUploadMemory int64
+	// ImageMaxSize is the largest image upload in bytes
+	ImageMaxSize int64
+
+	// UploadTimeout replaces the server read and write timeouts on the audio and video uploads
+	UploadTimeout time.Duration
+
+	// MinFreeDisk is the space left in the temp directory under which the instance is not ready
+	MinFreeDisk uint64
+	// MaxPendingJobs is the backlog of transcoding jobs over which the instance is not ready, 0 disables it
+	MaxPendingJobs int
+
+	// Metrics is nil when the metrics are disabled
+	Metrics *Metrics
+
+	// RateLimiter and Quotas are nil when disabled
+	RateLimiter *RateLimiter
+	Quotas      *Quotas
+
+	// ImageCache is nil when on the fly resizing is disabled
+	ImageCache *DiskCache
+
+	// Keys is nil when HLS encryption is not configured
+	Keys        *KeyStore
+	KeyURL      string
+	Entitlement Entitlement
+
+	// encoders of ffmpeg, listed at the first hls job
+	encodersOnce sync.Once
+	encoders     map[string]bool
+
+	// readiness is the last report of the readiness checks
+	readiness struct {
+		mu     sync.Mutex
+		report *HealthReport
+		at     time.Time
+		// refresh is closed when the running refresh is done, nil when none runs
+		refresh chan struct{}
+	}
+
+	// manifestMu serializes the manifest updates, so that two versions never share a predecessor
+	manifestMu sync.Mutex
+	ipns       ipnsPublisher
+
+	// queued wakes the worker up when a job is stored, the jobs themselves are read from the store
+	queued chan struct{}
+	// runningJobs is 1 while the worker runs a job, workerStarted once StartTranscoding was called
+	runningJobs   int32
+	workerStarted int32
+
+	// stopping is closed by Shutdown, the running job is canceled through jobs
+	stopping   chan struct{}
+	stopOnce   sync.Once
+	workerDone chan struct{}
+	jobs       context.Context
+	cancelJobs context.CancelFunc
 }
 
 func NewBStudio(sh *shell.Shell) *BStudio {
@@ -20,29 +107,150 @@ func NewBStudio(sh *shell.Shell) *BStudio {
 	ds := NewDs()
 	//defer ds.Db.Close()
 
+	return NewBStudioWithDs(sh, ds)
+}
+
+// NewBStudioWithDs returns a studio with the default settings storing into ds.
+func NewBStudioWithDs(sh *shell.Shell, ds *Ds) *BStudio {
+	jobs, cancelJobs := context.WithCancel(context.Background())
+
 	return &BStudio{
-		sh:     sh,
-		Ds:     ds,
-		TQueue: make(chan *Transcoder, maxTranscoderQueue),
+		sh:                sh,
+		Ds:                ds,
+		HlsProfiles:       DefaultHlsProfiles,
+		VideoProfiles:     DefaultVideoProfiles,
+		ImagePresets:      DefaultImagePresets,
+		ImageBackground:   DefaultImageBackground,
+		DuplicatePolicy:   DefaultDuplicatePolicy,
+		ImageSizes:        DefaultImageSizes,
+		ImageMaxPixels:    DefaultMaxImagePixels,
+		ManifestCodec:     CodecDagCbor,
+		Entitlement:       DenyAllEntitlement,
+		Auth:              true,
+		SessionTTL:        DefaultSessionTTL,
+		Executor:          NewExecutor(),
+		MinFreeDisk:       DefaultMinFreeDisk,
+		MaxPendingJobs:    DefaultMaxPendingJobs,
+		UploadMemory:      DefaultUploadMemory,
+		ImageUploadMemory: DefaultImageMemory,
+		ImageMaxSize:      DefaultImageMaxSize,
+		UploadTimeout:     DefaultUploadTimeout,
+		queued:            make(chan struct{}, 1),
+		stopping:          make(chan struct{}),
+		workerDone:        make(chan struct{}),
+		jobs:              jobs,
+		cancelJobs:        cancelJobs,
 	}
 }
 
 func (bs *BStudio) Add(r io.Reader) (string, error) {
+	defer bs.Metrics.observeIPFS("add", time.Now())
 	return bs.sh.Add(r)
 }
 func (bs *BStudio) AddDir(dir string) (string, error) {
+	defer bs.Metrics.observeIPFS("add_dir", time.Now())
 	return bs.sh.AddDir(dir)
 }
+func (bs *BStudio) Cat(cid string) (io.ReadCloser, error) {
+	return bs.CatContext(context.Background(), cid)
+}
+
+// CatContext reads cid until ctx is done.
+func (bs *BStudio) CatContext(ctx context.Context, cid string) (io.ReadCloser, error) {
+	defer bs.Metrics.observeIPFS("cat", time.Now())
+	res, err := bs.sh.Request("cat", cid).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		res.Close()
+		return nil, res.Error
+	}
+
+	return res.Output, nil
+}
+func (bs *BStudio) List(cid string) ([]*shell.LsLink, error) {
+	defer bs.Metrics.observeIPFS("ls", time.Now())
+	return bs.sh.List(cid)
+}
+
+// PinnedSize returns the size of cid with all its blocks.
+func (bs *BStudio) PinnedSize(cid string) (int64, error) {
+	defer bs.Metrics.observeIPFS("object_stat", time.Now())
+	stat, err := bs.sh.ObjectStat(cid)
+	if err != nil {
+		return 0, err
+	}
+	return int64(stat.CumulativeSize), nil
+}
 func (bs *BStudio) Get(cid, output string) error {
+	defer bs.Metrics.observeIPFS("get", time.Now())
 	return bs.sh.Get(cid, output)
 }
-func (bs *BStudio) StartTranscoding() {
-	for q := range bs.TQueue {
-		q.Transcode()
+func (bs *BStudio) executor() *Executor {
+	if bs.Executor == nil {
+		return defaultExecutor
 	}
+	return bs.Executor
 }
+
+// GetTranscodingStatus returns the status of the latest job transcoding cid, empty when there is none.
 func (bs *BStudio) GetTranscodingStatus(cid string) ([]byte, error) {
-	return bs.Ds.Get([]byte(cid))
+	id, err := bs.Ds.Get(jobCidKey(cid))
+	if err != nil {
+		return nil, err
+	}
+	if len(id) == 0 {
+		// the status of the older versions is keyed by the cid
+		return bs.Ds.Get([]byte(cid))
+	}
+
+	return bs.Ds.Get(jobStatusKey(string(id)))
+}
+
+// GetUploadedStatus returns the status of the latest job of cid uploaded by p, nil when there is none.
+// Without a principal, when the authentication is disabled, it is the latest job of anyone.
+func (bs *BStudio) GetUploadedStatus(cid string, p *Principal) (*TranscodeStatus, error) {
+	if p != nil && !p.HasScope(ScopeAdmin) {
+		id, err := bs.Ds.Get(jobOwnerCidKey(p.Address, p.APIKeyID, cid))
+		if err != nil {
+			return nil, err
+		}
+		if len(id) > 0 {
+			return bs.GetJobStatus(string(id))
+		}
+	}
+
+	bz, err := bs.GetTranscodingStatus(cid)
+	if err != nil || len(bz) == 0 {
+		return nil, err
+	}
+
+	var status TranscodeStatus
+	if err := json.Unmarshal(bz, &status); err != nil {
+		return nil, err
+	}
+	// the jobs queued before the uploader index
+	if p != nil && !p.Created(status.Owner, status.APIKeyID) {
+		return nil, nil
+	}
+
+	return &status, nil
+}
+
+// GetJobStatus returns the status of the job id, nil when it does not exist.
+func (bs *BStudio) GetJobStatus(id string) (*TranscodeStatus, error) {
+	bz, err := bs.Ds.Get(jobStatusKey(id))
+	if err != nil || len(bz) == 0 {
+		return nil, err
+	}
+
+	var status TranscodeStatus
+	if err := json.Unmarshal(bz, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
 }
 
 func (bs *BStudio) Subscribe() (*shell.PubSubSubscription, error) {
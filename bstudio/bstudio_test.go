@@ -92,12 +92,12 @@ func TestBStudio_StartTranscodingQueue(t *testing.T) {
 
 	var wg sync.WaitGroup
 	wg.Add(1)
-	bs.StartTranscoding(&wg)
+	go bs.StartTranscoding()
 
 	ts := NewTranscoder(bs, "QmZWCE29y6omGw8vuiQQpMKehfrhggxytjCd9McxRomsLt")
-	bs.TQueue <- ts
+	require.NoError(t, bs.Enqueue(ts))
 
-	if len(bs.TQueue) > 0 {
+	if n, _ := bs.waitingJobs(); n > 0 {
 		wg.Wait()
 	}
 
@@ -0,0 +1,135 @@
+package bstudio
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DiskCache is a size bounded LRU cache of files, keyed by a file name safe string.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// NewDiskCache opens the cache in dir, indexing the files left by a previous run
+// from the oldest to the most recently modified.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) == ".tmp" {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		c.entries[f.Name()] = c.lru.PushFront(&cacheEntry{key: f.Name(), size: f.Size()})
+		c.size += f.Size()
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// Get returns the path of the cached file and marks it as recently used.
+func (c *DiskCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.lru.MoveToFront(el)
+
+	return filepath.Join(c.dir, key), true
+}
+
+// Put lets write produce the file at the given tmp path, then adds it to the cache
+// and returns its final path.
+func (c *DiskCache) Put(key string, write func(path string) error) (string, error) {
+	tmp, err := ioutil.TempFile(c.dir, key+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp.Name()); err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(c.dir, key)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: info.Size()})
+	c.size += info.Size()
+	c.evict()
+
+	return path, nil
+}
+
+// Size returns the bytes currently held by the cache.
+func (c *DiskCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// evict removes the least recently used files until the cache fits, must hold mu.
+// The most recent entry is always kept, even if it alone is larger than the limit.
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 1 {
+		el := c.lru.Back()
+		e := el.Value.(*cacheEntry)
+
+		os.Remove(filepath.Join(c.dir, e.key))
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+		c.size -= e.size
+	}
+}
@@ -0,0 +1,90 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestDiskCache_LRU(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bstudio-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := NewDiskCache(dir, 25)
+	require.NoError(t, err)
+
+	put := func(key string) {
+		_, err := c.Put(key, func(path string) error {
+			return ioutil.WriteFile(path, make([]byte, 10), 0644)
+		})
+		require.NoError(t, err)
+	}
+
+	put("a")
+	put("b")
+	_, ok := c.Get("a")
+	require.True(t, ok)
+
+	// b is the least recently used
+	put("c")
+	_, ok = c.Get("b")
+	require.False(t, ok)
+	_, err = os.Stat(dir + "/b")
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, int64(20), c.Size())
+
+	// reopening keeps the files
+	c, err = NewDiskCache(dir, 25)
+	require.NoError(t, err)
+	path, ok := c.Get("c")
+	require.True(t, ok)
+	require.FileExists(t, path)
+}
+
+func TestImageVariant_Parse(t *testing.T) {
+	v, err := ParseImageVariant(url.Values{"w": {"300"}, "format": {"webp"}, "q": {"70"}}, DefaultImageSizes)
+	require.NoError(t, err)
+	require.Equal(t, &ImageVariant{Width: 300, Fit: FitContain, Format: FormatWebP, Quality: 70}, v)
+	require.NotEqual(t, v.Key("QmA"), v.Key("QmB"))
+	require.Equal(t, "image/webp", v.ContentType())
+
+	_, err = ParseImageVariant(url.Values{"w": {"301"}, "fit": {"cover"}, "format": {"gif"}}, DefaultImageSizes)
+	errs, ok := err.(ValidationErrors)
+	require.True(t, ok)
+	require.Len(t, errs, 3)
+	require.Equal(t, "not_allowed", errs[0].Code)
+
+	require.False(t, IsImageVariantQuery(url.Values{}))
+	require.True(t, IsImageVariantQuery(url.Values{"h": {"64"}}))
+}
+
+func TestImageVariant_Render(t *testing.T) {
+	img := mockImage(t, 400, 200)
+
+	dir, err := ioutil.TempDir("", "bstudio-variant")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		variant       ImageVariant
+		width, height int
+	}{
+		{ImageVariant{Width: 100, Fit: FitContain, Format: FormatPNG}, 100, 50},
+		{ImageVariant{Width: 100, Height: 100, Fit: FitCover, Format: FormatJPEG, Quality: 80}, 100, 100},
+		{ImageVariant{Width: 100, Height: 100, Fit: FitFill, Format: FormatJPEG, Quality: 80}, 100, 100},
+	} {
+		path := dir + "/" + tc.variant.Key("QmA")
+		require.NoError(t, img.RenderVariant(&tc.variant, path))
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		out, err := NewImage(f, DefaultImageBackground, DefaultMaxImagePixels)
+		f.Close()
+		require.NoError(t, err)
+		require.Equal(t, tc.width, out.img.Bounds().Dx())
+		require.Equal(t, tc.height, out.img.Bounds().Dy())
+	}
+}
@@ -0,0 +1,552 @@
+package bstudio
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ConfigFileName = "config.yaml"
+
+	// EnvPrefix starts the environment variable of every config key, e.g. BSTUDIO_SERVER_LISTEN
+	EnvPrefix = "BSTUDIO_"
+
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+
+	DefaultListenAddr   = "127.0.0.1:1347"
+	DefaultUploadMemory = 32 << 20
+	DefaultImageMemory  = 5 << 20
+	DefaultImageMaxSize = 20 << 20
+
+	// a 2GB video at 5Mbit/s
+	DefaultUploadTimeout = time.Hour
+)
+
+// Config holds every setting of a studio, read from the config.yaml of its home directory.
+// Every key can be overridden by an environment variable, see ConfigEnv.
+// The sizes are in MB like the flags of the start command.
+type Config struct {
+	Log       LogConfig       `yaml:"log"`
+	IPFS      IPFSConfig      `yaml:"ipfs"`
+	Server    ServerConfig    `yaml:"server"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Upload    UploadConfig    `yaml:"upload"`
+	Images    ImagesConfig    `yaml:"images"`
+	Manifests ManifestsConfig `yaml:"manifests"`
+	Auth      AuthConfig      `yaml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Quota     QuotaConfig     `yaml:"quota"`
+	HLS       HLSConfig       `yaml:"hls"`
+	FFmpeg    FFmpegConfig    `yaml:"ffmpeg"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" doc:"logging level: trace, debug, info, warn, error"`
+	Format string `yaml:"format" doc:"logging format: json or text"`
+}
+
+type IPFSConfig struct {
+	Addr string `yaml:"addr" doc:"ipfs api address"`
+}
+
+type ServerConfig struct {
+	Listen           []string      `yaml:"listen" doc:"tcp addresses to listen on"`
+	UnixSocket       string        `yaml:"unix_socket" doc:"also listen on this unix socket, without tls; it serves the internal routes /readyz and /metrics without credentials"`
+	InternalListen   []string      `yaml:"internal_listen" doc:"tcp addresses serving the internal routes too, without tls nor credentials; keep them off the public network"`
+	ReadTimeout      time.Duration `yaml:"read_timeout" doc:"maximum duration to read a request, except the audio and video uploads"`
+	WriteTimeout     time.Duration `yaml:"write_timeout" doc:"maximum duration to write a response, except to the audio and video uploads"`
+	ShutdownTimeout  time.Duration `yaml:"shutdown_timeout" doc:"on SIGTERM, time given to each phase of the shutdown: the requests in flight, the running job, then the ipns publications"`
+	Metrics          bool          `yaml:"metrics" doc:"serve the prometheus metrics on /metrics, to the admin api keys or on the internal connections"`
+	ReadyMinFreeDisk uint64        `yaml:"ready_min_free_disk" doc:"free space in MB of the temp directory under which /readyz fails"`
+	ReadyMaxJobs     int           `yaml:"ready_max_jobs" doc:"pending transcoding jobs over which /readyz fails, 0 disables the check"`
+	TLS              TLSConfig     `yaml:"tls"`
+	CORS             CORSConfig    `yaml:"cors"`
+}
+
+type TLSConfig struct {
+	Cert       string `yaml:"cert" doc:"PEM certificate file, serve https when set; reloaded on SIGHUP"`
+	Key        string `yaml:"key" doc:"PEM private key file of the certificate"`
+	ClientCA   string `yaml:"client_ca" doc:"PEM CA file verifying the client certificates of the tcp clients (mTLS)"`
+	ClientAuth string `yaml:"client_auth" doc:"with a client CA, require a client certificate or only verify it when given: require or optional"`
+}
+
+type CORSConfig struct {
+	Origins []string `yaml:"origins" doc:"origins allowed by CORS, * allows any"`
+	Methods []string `yaml:"methods" doc:"methods allowed by CORS"`
+	Headers []string `yaml:"headers" doc:"request headers allowed by CORS"`
+}
+
+type StorageConfig struct {
+	DbDir  string `yaml:"db_dir" doc:"badger database directory, relative to the home directory"`
+	TmpDir string `yaml:"tmp_dir" doc:"directory of the files being transcoded, empty is the system temp directory"`
+}
+
+type UploadConfig struct {
+	MaxMemory      int64         `yaml:"max_memory" doc:"MB of an audio or video upload kept in memory, the rest is buffered on disk"`
+	ImageMaxMemory int64         `yaml:"image_max_memory" doc:"MB of an image upload kept in memory, the rest is buffered on disk"`
+	ImageMaxSize   int64         `yaml:"image_max_size" doc:"MB of an image upload, larger uploads are rejected before they are read"`
+	Timeout        time.Duration `yaml:"timeout" doc:"maximum duration to receive an audio or video upload and answer it, instead of the server timeouts"`
+}
+
+type ImagesConfig struct {
+	Background        string `yaml:"background" doc:"background color used to flatten transparent images"`
+	Sizes             []uint `yaml:"sizes" doc:"widths in px the images can be resized to on the fly"`
+	Duplicates        string `yaml:"duplicates" doc:"what to do with near duplicate images: off, flag or dedupe"`
+	DuplicateDistance int    `yaml:"duplicate_distance" doc:"maximum perceptual hash hamming distance of near duplicate images, 0 to 7"`
+	CacheSize         int64  `yaml:"cache_size" doc:"size in MB of the on the fly image renditions cache, 0 disables resizing"`
+	MaxPixels         int    `yaml:"max_pixels" doc:"images of more pixels are rejected before being decoded"`
+	KeepOriginal      bool   `yaml:"keep_original" doc:"publish the full resolution image next to the renditions, on the fly sizes are rendered from it"`
+}
+
+type ManifestsConfig struct {
+	Codec             string `yaml:"codec" doc:"codec of the manifest DAG objects: dag-cbor or dag-json"`
+	IPNS              bool   `yaml:"ipns" doc:"publish the latest version of every manifest to its own ipns name"`
+	RequireSignatures bool   `yaml:"require_signatures" doc:"reject the manifests not signed by one of their artists"`
+}
+
+type AuthConfig struct {
+	Enabled    bool          `yaml:"enabled" doc:"require an api key on the upload, manifest and job routes; create keys with bstudio keys create"`
+	SessionTTL time.Duration `yaml:"session_ttl" doc:"lifetime of the session tokens issued by the wallet login"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool   `yaml:"enabled" doc:"limit the request rate of every api key, address or ip per route group"`
+	Limits  string `yaml:"limits" doc:"comma separated group=rate:burst token buckets, rate per minute; groups: auth, upload, manifest, read, and ip for every request of an ip"`
+}
+
+type QuotaConfig struct {
+	Daily   string `yaml:"daily" doc:"daily quota of every client, e.g. uploads=100,bytes=10737418240,minutes=600; empty is unlimited"`
+	Monthly string `yaml:"monthly" doc:"calendar month quota of every client, same format as the daily one"`
+}
+
+type HLSConfig struct {
+	KeyURL         string `yaml:"key_url" doc:"public base url of the content key endpoint written into EXT-X-KEY; derived from the first listen address without tls, required with it"`
+	EntitlementURL string `yaml:"entitlement_url" doc:"url of the service checking content key entitlements; keys are denied when empty"`
+}
+
+type FFmpegConfig struct {
+	Timeouts      string        `yaml:"timeouts" doc:"comma separated stage=duration timeouts of ffmpeg and ffprobe, 0 disables one"`
+	CPULimit      time.Duration `yaml:"cpu_limit" doc:"cpu time limit of an ffmpeg process, 0 is unlimited"`
+	MemoryLimit   uint64        `yaml:"memory_limit" doc:"address space limit in MB of an ffmpeg process, 0 is unlimited"`
+	FileSizeLimit uint64        `yaml:"file_size_limit" doc:"largest file in MB an ffmpeg process may write, 0 is unlimited"`
+	Isolate       bool          `yaml:"isolate" doc:"run ffmpeg in its own user and network namespaces without network (linux, needs unprivileged user namespaces); the filesystem stays visible, restrict it with a wrapper"`
+	Wrapper       string        `yaml:"wrapper" doc:"command prefixed to ffmpeg and ffprobe, e.g. to apply a seccomp profile with nsjail"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Log:  LogConfig{Level: zerolog.InfoLevel.String(), Format: LogFormatJSON},
+		IPFS: IPFSConfig{Addr: "localhost:5001"},
+		Server: ServerConfig{
+			Listen:           []string{DefaultListenAddr},
+			InternalListen:   []string{},
+			ReadTimeout:      15 * time.Second,
+			WriteTimeout:     15 * time.Second,
+			ShutdownTimeout:  30 * time.Second,
+			Metrics:          true,
+			ReadyMinFreeDisk: DefaultMinFreeDisk >> 20,
+			ReadyMaxJobs:     DefaultMaxPendingJobs,
+			TLS:              TLSConfig{ClientAuth: "require"},
+			CORS: CORSConfig{
+				Origins: []string{"*"},
+				Methods: []string{"GET", "POST", "PUT"},
+				Headers: []string{"Origin", "Accept", "Content-Type", "Authorization", "X-API-Key", "X-Entitlement-Token", "X-Request-ID"},
+			},
+		},
+		Storage: StorageConfig{DbDir: "db"},
+		Upload:  UploadConfig{MaxMemory: DefaultUploadMemory >> 20, ImageMaxMemory: DefaultImageMemory >> 20, ImageMaxSize: DefaultImageMaxSize >> 20, Timeout: DefaultUploadTimeout},
+		Images: ImagesConfig{
+			Background:        "#ffffff",
+			Sizes:             append([]uint{}, DefaultImageSizes...),
+			Duplicates:        DefaultDuplicatePolicy.Mode,
+			DuplicateDistance: DefaultDuplicatePolicy.Threshold,
+			CacheSize:         1024,
+			MaxPixels:         DefaultMaxImagePixels,
+		},
+		Manifests: ManifestsConfig{Codec: CodecDagCbor},
+		Auth:      AuthConfig{Enabled: true, SessionTTL: DefaultSessionTTL},
+		RateLimit: RateLimitConfig{Enabled: true, Limits: FormatRateLimits(DefaultRateLimits)},
+		FFmpeg: FFmpegConfig{
+			Timeouts:      FormatStageTimeouts(DefaultStageTimeouts),
+			CPULimit:      DefaultExecLimits.CPU,
+			MemoryLimit:   DefaultExecLimits.Memory >> 20,
+			FileSizeLimit: DefaultExecLimits.FileSize >> 20,
+		},
+	}
+}
+
+// LoadConfig reads the config file over the defaults, a missing file keeps them,
+// then applies the environment variables of environ. Every invalid key is reported at once.
+func LoadConfig(path string, environ []string) (*Config, error) {
+	c := DefaultConfig()
+	var errs ValidationErrors
+
+	bz, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := yaml.UnmarshalStrict(bz, c); err != nil {
+			if terr, ok := err.(*yaml.TypeError); ok {
+				for _, msg := range terr.Errors {
+					errs.Add(path, "invalid_value", "%s", msg)
+				}
+			} else {
+				errs.Add(path, "invalid_yaml", "%s", err)
+			}
+		}
+	}
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	for _, key := range ConfigKeys() {
+		if v, ok := env[ConfigEnv(key)]; ok {
+			if err := c.Set(key, v); err != nil {
+				errs.Add(ConfigEnv(key), "invalid_value", "%s", err)
+			}
+		}
+	}
+
+	return c, errs.Err()
+}
+
+// ConfigEnv returns the environment variable overriding key, e.g. BSTUDIO_SERVER_TLS_CERT for server.tls.cert.
+func ConfigEnv(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// ConfigKeys returns the dotted keys of every setting, in the order of the config file.
+func ConfigKeys() []string {
+	var keys []string
+	walkConfig(reflect.ValueOf(DefaultConfig()).Elem(), "", func(key string, _ reflect.StructField, _ reflect.Value) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// walkConfig calls fn on every leaf of the config struct v.
+func walkConfig(v reflect.Value, prefix string, fn func(key string, f reflect.StructField, v reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		key := prefix + f.Tag.Get("yaml")
+		if f.Type.Kind() == reflect.Struct {
+			walkConfig(v.Field(i), key+".", fn)
+			continue
+		}
+		fn(key, f, v.Field(i))
+	}
+}
+
+// Set parses s into the setting key, lists are comma separated.
+func (c *Config) Set(key, s string) error {
+	var field reflect.Value
+	walkConfig(reflect.ValueOf(c).Elem(), "", func(k string, _ reflect.StructField, v reflect.Value) {
+		if k == key {
+			field = v
+		}
+	})
+	if !field.IsValid() {
+		return fmt.Errorf("unknown config key %s", key)
+	}
+
+	return setConfigValue(field, strings.TrimSpace(s))
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Uint || v.Kind() == reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		v.SetUint(n)
+	case v.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+
+	return nil
+}
+
+// Validate checks every setting and reports all the invalid ones at once.
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+	invalid := func(key, format string, args ...interface{}) {
+		errs.Add(key, "invalid_value", format, args...)
+	}
+
+	if _, err := zerolog.ParseLevel(c.Log.Level); err != nil || c.Log.Level == "" {
+		invalid("log.level", "unknown level %q", c.Log.Level)
+	}
+	if c.Log.Format != LogFormatJSON && c.Log.Format != LogFormatText {
+		invalid("log.format", "unknown format %q, expected json or text", c.Log.Format)
+	}
+	if c.IPFS.Addr == "" {
+		invalid("ipfs.addr", "must not be empty")
+	}
+
+	if len(c.Server.Listen) == 0 && c.Server.UnixSocket == "" {
+		invalid("server.listen", "no listen address nor unix socket")
+	}
+	for _, addr := range c.Server.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			invalid("server.listen", "invalid address %q", addr)
+		}
+	}
+	for _, addr := range c.Server.InternalListen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			invalid("server.internal_listen", "invalid address %q", addr)
+		}
+	}
+	if c.Server.ReadyMaxJobs < 0 {
+		invalid("server.ready_max_jobs", "must not be negative")
+	}
+	if c.Server.ReadTimeout <= 0 {
+		invalid("server.read_timeout", "must be positive")
+	}
+	if c.Server.WriteTimeout <= 0 {
+		invalid("server.write_timeout", "must be positive")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout", "must be positive")
+	}
+	if (c.Server.TLS.Cert == "") != (c.Server.TLS.Key == "") {
+		invalid("server.tls", "cert and key must be set together")
+	}
+	if c.Server.TLS.ClientCA != "" && c.Server.TLS.Cert == "" {
+		invalid("server.tls.client_ca", "requires a cert and a key")
+	}
+	if c.Server.TLS.ClientAuth != "require" && c.Server.TLS.ClientAuth != "optional" {
+		invalid("server.tls.client_auth", "unknown client auth %q, expected require or optional", c.Server.TLS.ClientAuth)
+	}
+
+	if c.Storage.DbDir == "" {
+		invalid("storage.db_dir", "must not be empty")
+	}
+	if c.Storage.TmpDir != "" {
+		if fi, err := os.Stat(c.Storage.TmpDir); err != nil || !fi.IsDir() {
+			invalid("storage.tmp_dir", "%s is not a directory", c.Storage.TmpDir)
+		}
+	}
+	if c.Upload.MaxMemory <= 0 {
+		invalid("upload.max_memory", "must be positive")
+	}
+	if c.Upload.ImageMaxMemory <= 0 {
+		invalid("upload.image_max_memory", "must be positive")
+	}
+	if c.Upload.ImageMaxSize <= 0 {
+		invalid("upload.image_max_size", "must be positive")
+	}
+	if c.Upload.Timeout <= 0 {
+		invalid("upload.timeout", "must be positive")
+	}
+
+	if _, err := ParseHexColor(c.Images.Background); err != nil {
+		invalid("images.background", "%s", err)
+	}
+	if len(c.Images.Sizes) == 0 {
+		invalid("images.sizes", "must not be empty")
+	}
+	for _, size := range c.Images.Sizes {
+		if size == 0 {
+			invalid("images.sizes", "sizes must be positive")
+			break
+		}
+	}
+	switch c.Images.Duplicates {
+	case DuplicateOff, DuplicateFlag, DuplicateDedupe:
+	default:
+		invalid("images.duplicates", "unknown mode %q, expected off, flag or dedupe", c.Images.Duplicates)
+	}
+	if c.Images.MaxPixels <= 0 {
+		invalid("images.max_pixels", "must be positive")
+	}
+	if c.Images.DuplicateDistance < 0 || c.Images.DuplicateDistance >= phashBands {
+		invalid("images.duplicate_distance", "must be between 0 and %d", phashBands-1)
+	}
+	if c.Images.CacheSize < 0 {
+		invalid("images.cache_size", "must not be negative")
+	}
+
+	if c.Manifests.Codec != CodecDagCbor && c.Manifests.Codec != CodecDagJSON {
+		invalid("manifests.codec", "unknown codec %q, expected dag-cbor or dag-json", c.Manifests.Codec)
+	}
+	if c.Auth.SessionTTL <= 0 {
+		invalid("auth.session_ttl", "must be positive")
+	}
+	if c.RateLimit.Enabled {
+		if _, err := ParseRateLimits(c.RateLimit.Limits); err != nil {
+			invalid("rate_limit.limits", "%s", err)
+		}
+	}
+	if _, err := ParseUsage(c.Quota.Daily); err != nil {
+		invalid("quota.daily", "%s", err)
+	}
+	if _, err := ParseUsage(c.Quota.Monthly); err != nil {
+		invalid("quota.monthly", "%s", err)
+	}
+
+	switch {
+	case c.HLS.KeyURL != "":
+		if u, err := url.Parse(c.HLS.KeyURL); err != nil || !u.IsAbs() {
+			invalid("hls.key_url", "%q is not an absolute url", c.HLS.KeyURL)
+		} else if c.Server.TLS.Cert != "" && u.Scheme != "https" {
+			invalid("hls.key_url", "%q must be an https url with server.tls.cert", c.HLS.KeyURL)
+		}
+	case c.Server.TLS.Cert != "":
+		invalid("hls.key_url", "required with server.tls.cert, the public https url cannot be derived")
+	case c.HLSKeyURL() == "":
+		invalid("hls.key_url", "required, it cannot be derived from a wildcard listen address or a unix socket")
+	}
+	if c.HLS.EntitlementURL != "" {
+		if u, err := url.Parse(c.HLS.EntitlementURL); err != nil || !u.IsAbs() {
+			invalid("hls.entitlement_url", "%q is not an absolute url", c.HLS.EntitlementURL)
+		}
+	}
+
+	if _, err := ParseStageTimeouts(c.FFmpeg.Timeouts); err != nil {
+		invalid("ffmpeg.timeouts", "%s", err)
+	}
+	if c.FFmpeg.CPULimit < 0 {
+		invalid("ffmpeg.cpu_limit", "must not be negative")
+	}
+
+	return errs.Err()
+}
+
+// HLSKeyURL returns the key url, derived from the first listen address when not set and without tls.
+// It is empty when that address does not tell how to reach the server, e.g. 0.0.0.0.
+func (c *Config) HLSKeyURL() string {
+	if c.HLS.KeyURL != "" || c.Server.TLS.Cert != "" || len(c.Server.Listen) == 0 {
+		return c.HLS.KeyURL
+	}
+
+	host, _, err := net.SplitHostPort(c.Server.Listen[0])
+	if err != nil || host == "" || net.ParseIP(host).IsUnspecified() {
+		return ""
+	}
+
+	return "http://" + c.Server.Listen[0] + "/api/v1/keys/"
+}
+
+// Path resolves path relative to the home directory.
+func (c *Config) Path(home, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(home, path)
+}
+
+// WriteConfig writes c as yaml with the documentation of every key and its environment variable.
+func WriteConfig(w io.Writer, c *Config) error {
+	var buf bytes.Buffer
+	buf.WriteString("# bstudio configuration, every key can be overridden by its environment variable\n" +
+		"# and by the flags of the start command.\n")
+
+	var err error
+	writeConfigStruct(&buf, reflect.ValueOf(c).Elem(), "", "", &err)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func writeConfigStruct(buf *bytes.Buffer, v reflect.Value, prefix, indent string, err *error) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := f.Tag.Get("yaml")
+
+		if f.Type.Kind() == reflect.Struct {
+			if indent == "" {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(buf, "%s%s:\n", indent, name)
+			writeConfigStruct(buf, v.Field(i), prefix+name+".", indent+"  ", err)
+			continue
+		}
+
+		val, merr := configYAMLValue(v.Field(i))
+		if merr != nil && *err == nil {
+			*err = merr
+		}
+		fmt.Fprintf(buf, "%s# %s (%s)\n", indent, f.Tag.Get("doc"), ConfigEnv(prefix+name))
+		fmt.Fprintf(buf, "%s%s: %s\n", indent, name, val)
+	}
+}
+
+// configYAMLValue formats a leaf on a single line, lists in the flow style.
+func configYAMLValue(v reflect.Value) (string, error) {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String(), nil
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			item, err := configYAMLValue(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+
+	bz, err := yaml.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bz)), nil
+}
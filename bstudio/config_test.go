@@ -0,0 +1,123 @@
+package bstudio
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig_WriteAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bstudio-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ConfigFileName)
+
+	// a missing file keeps the defaults
+	cfg, err := LoadConfig(path, nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultConfig(), cfg)
+	require.NoError(t, cfg.Validate())
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteConfig(&buf, DefaultConfig()))
+	require.Contains(t, buf.String(), "# tcp addresses to listen on (BSTUDIO_SERVER_LISTEN)\n  listen: [127.0.0.1:1347]\n")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+
+	cfg, err = LoadConfig(path, nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultConfig(), cfg)
+}
+
+func TestConfig_Env(t *testing.T) {
+	require.Equal(t, "BSTUDIO_SERVER_TLS_CLIENT_CA", ConfigEnv("server.tls.client_ca"))
+	require.Contains(t, ConfigKeys(), "ffmpeg.memory_limit")
+
+	cfg, err := LoadConfig(filepath.Join(os.TempDir(), "missing", ConfigFileName), []string{
+		"BSTUDIO_SERVER_LISTEN=0.0.0.0:1347, [::]:1347",
+		"BSTUDIO_SERVER_SHUTDOWN_TIMEOUT=1m",
+		"BSTUDIO_AUTH_ENABLED=false",
+		"BSTUDIO_IMAGES_SIZES=100,200",
+		"BSTUDIO_FFMPEG_MEMORY_LIMIT=2048",
+		"BSTUDIO_UNKNOWN=1",
+		"HOME=/root",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"0.0.0.0:1347", "[::]:1347"}, cfg.Server.Listen)
+	require.Equal(t, time.Minute, cfg.Server.ShutdownTimeout)
+	require.False(t, cfg.Auth.Enabled)
+	require.Equal(t, []uint{100, 200}, cfg.Images.Sizes)
+	require.Equal(t, uint64(2048), cfg.FFmpeg.MemoryLimit)
+
+	_, err = LoadConfig(filepath.Join(os.TempDir(), "missing", ConfigFileName), []string{
+		"BSTUDIO_AUTH_ENABLED=maybe",
+		"BSTUDIO_IMAGES_SIZES=100,big",
+	})
+	require.Len(t, err, 2)
+	// in the order of the config file
+	require.Equal(t, "BSTUDIO_IMAGES_SIZES", err.(ValidationErrors)[0].Field)
+	require.Equal(t, "BSTUDIO_AUTH_ENABLED", err.(ValidationErrors)[1].Field)
+
+	require.Error(t, cfg.Set("server.nope", "1"))
+}
+
+func TestConfig_ValidateReportsEverything(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Log.Format = "xml"
+	cfg.Server.Listen = []string{"nope"}
+	cfg.Server.TLS.Cert = "cert.pem"
+	cfg.Images.Background = "red"
+	cfg.Manifests.Codec = "json"
+	cfg.FFmpeg.Timeouts = "encode=1s"
+
+	err := cfg.Validate()
+	require.Error(t, err)
+
+	var fields []string
+	for _, e := range err.(ValidationErrors) {
+		fields = append(fields, e.Field)
+	}
+	require.Equal(t, []string{
+		"log.format",
+		"server.listen",
+		"server.tls",
+		"images.background",
+		"manifests.codec",
+		"hls.key_url",
+		"ffmpeg.timeouts",
+	}, fields)
+}
+
+func TestConfig_HLSKeyURL(t *testing.T) {
+	cfg := DefaultConfig()
+	require.Equal(t, "http://127.0.0.1:1347/api/v1/keys/", cfg.HLSKeyURL())
+	require.NoError(t, cfg.Validate())
+
+	// the address does not tell how to reach the server
+	cfg.Server.Listen = []string{"0.0.0.0:1347"}
+	require.Empty(t, cfg.HLSKeyURL())
+	require.Error(t, cfg.Validate())
+
+	// nor with tls, the url must be set to the public https one
+	cfg.Server.Listen = []string{"127.0.0.1:1347"}
+	cfg.Server.TLS = TLSConfig{Cert: "cert.pem", Key: "key.pem", ClientAuth: "require"}
+	require.Empty(t, cfg.HLSKeyURL())
+	err := cfg.Validate()
+	require.Len(t, err, 1)
+	require.Equal(t, "hls.key_url", err.(ValidationErrors)[0].Field)
+
+	cfg.HLS.KeyURL = "http://studio.example.com/api/v1/keys/"
+	require.Len(t, cfg.Validate(), 1)
+	cfg.HLS.KeyURL = "https://studio.example.com/api/v1/keys/"
+	require.Equal(t, cfg.HLS.KeyURL, cfg.HLSKeyURL())
+	require.NoError(t, cfg.Validate())
+}
+
+func TestConfig_Path(t *testing.T) {
+	cfg := DefaultConfig()
+	require.Equal(t, filepath.Join("/home/bstudio", "db"), cfg.Path("/home/bstudio", cfg.Storage.DbDir))
+	require.Equal(t, "/var/lib/bstudio", cfg.Path("/home/bstudio", "/var/lib/bstudio"))
+}
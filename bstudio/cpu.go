@@ -0,0 +1,25 @@
+package bstudio
+
+import (
+	"runtime"
+	"time"
+)
+
+// CPUMeter measures the cpu time spent by the calling goroutine, e.g. rendering an image.
+// The goroutine is locked to its thread until Stop, the time of the garbage collector is not counted.
+type CPUMeter struct {
+	start time.Duration
+}
+
+func StartCPUMeter() *CPUMeter {
+	runtime.LockOSThread()
+	return &CPUMeter{start: threadCPU()}
+}
+
+// Stop returns the cpu time since the start and unlocks the thread.
+func (m *CPUMeter) Stop() time.Duration {
+	d := threadCPU() - m.start
+	runtime.UnlockOSThread()
+
+	return d
+}
@@ -0,0 +1,19 @@
+package bstudio
+
+import (
+	"syscall"
+	"time"
+)
+
+// RUSAGE_THREAD is missing from the syscall package
+const rusageThread = 1
+
+// threadCPU returns the user and system time of the calling thread.
+func threadCPU() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(rusageThread, &ru); err != nil {
+		return 0
+	}
+
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package bstudio
+
+import "time"
+
+// threadCPU is not measured outside of linux, the image jobs then report the cpu of ffmpeg only.
+func threadCPU() time.Duration {
+	return 0
+}
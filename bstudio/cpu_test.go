@@ -0,0 +1,24 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCPUMeter(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the thread cpu is measured on linux only")
+	}
+
+	m := StartCPUMeter()
+	var n int
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		n++
+	}
+	cpu := m.Stop()
+
+	require.True(t, cpu > 10*time.Millisecond, "measured %s", cpu)
+	require.True(t, cpu < time.Second, "measured %s", cpu)
+}
@@ -0,0 +1,156 @@
+package bstudio
+
+import (
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"math"
+)
+
+const (
+	CropNone     = ""
+	CropCenter   = "center"
+	CropSaliency = "saliency"
+
+	// saliency is computed on a downscaled copy, it only needs the big picture
+	saliencySize = 256
+)
+
+// ImagePolicy are the requirements an upload must meet to be rendered by a preset.
+type ImagePolicy struct {
+	MinWidth        uint    `json:"min_width" yaml:"min_width"`
+	MinHeight       uint    `json:"min_height" yaml:"min_height"`
+	Aspect          float64 `json:"aspect" yaml:"aspect"`                     // width / height, 0 accepts any ratio
+	AspectTolerance float64 `json:"aspect_tolerance" yaml:"aspect_tolerance"` // relative deviation allowed from Aspect
+	MaxFileSize     int64   `json:"max_file_size" yaml:"max_file_size"`       // bytes, 0 disables
+}
+
+// DefaultCoverPolicy follows the DSP requirements for release artworks.
+var DefaultCoverPolicy = &ImagePolicy{
+	MinWidth:        1400,
+	MinHeight:       1400,
+	Aspect:          1,
+	AspectTolerance: 0.01,
+	MaxFileSize:     5 << 20,
+}
+
+// Validate checks an image of width x height pixels and size bytes.
+// When cropped is set the aspect ratio is not checked, the crop enforces it.
+func (p *ImagePolicy) Validate(width, height int, size int64, cropped bool) error {
+	var errs ValidationErrors
+
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		errs.Add("file", "file_too_large", "file is %d bytes, maximum is %d", size, p.MaxFileSize)
+	}
+	if uint(width) < p.MinWidth {
+		errs.Add("width", "resolution_too_low", "width is %dpx, minimum is %dpx", width, p.MinWidth)
+	}
+	if uint(height) < p.MinHeight {
+		errs.Add("height", "resolution_too_low", "height is %dpx, minimum is %dpx", height, p.MinHeight)
+	}
+	if !cropped && p.Aspect > 0 && height > 0 {
+		aspect := float64(width) / float64(height)
+		if math.Abs(aspect-p.Aspect)/p.Aspect > p.AspectTolerance {
+			errs.Add("aspect", "aspect_ratio_mismatch", "aspect ratio is %.3f, expected %.3f", aspect, p.Aspect)
+		}
+	}
+
+	return errs.Err()
+}
+
+// cropRect returns the largest rectangle of the given aspect ratio within bounds,
+// positioned in the center or on the most salient area.
+func cropRect(img image.Image, aspect float64, mode string) (image.Rectangle, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	cw, ch := w, int(math.Round(float64(w)/aspect))
+	if ch > h {
+		cw, ch = int(math.Round(float64(h)*aspect)), h
+	}
+
+	switch mode {
+	case CropCenter:
+		x, y := (w-cw)/2, (h-ch)/2
+		return image.Rect(x, y, x+cw, y+ch).Add(bounds.Min), nil
+	case CropSaliency:
+		x, y := salientOffset(img, cw, ch)
+		return image.Rect(x, y, x+cw, y+ch).Add(bounds.Min), nil
+	default:
+		return image.Rectangle{}, fmt.Errorf("unknown crop mode %s", mode)
+	}
+}
+
+// salientOffset slides the crop window along the long axis and keeps the position
+// holding the most edge energy, a cheap proxy of where the subject is.
+func salientOffset(img image.Image, cw, ch int) (int, int) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if cw == w && ch == h {
+		return 0, 0
+	}
+
+	small := resize.Thumbnail(saliencySize, saliencySize, img, resize.Bilinear)
+	sw, sh := small.Bounds().Dx(), small.Bounds().Dy()
+	scale := float64(sw) / float64(w)
+
+	luma := make([]float64, sw*sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			r, g, b, _ := small.At(small.Bounds().Min.X+x, small.Bounds().Min.Y+y).RGBA()
+			luma[y*sw+x] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+
+	horizontal := cw < w
+	lines := sh
+	if horizontal {
+		lines = sw
+	}
+	energy := make([]float64, lines)
+	for y := 1; y < sh; y++ {
+		for x := 1; x < sw; x++ {
+			e := math.Abs(luma[y*sw+x]-luma[y*sw+x-1]) + math.Abs(luma[y*sw+x]-luma[(y-1)*sw+x])
+			if horizontal {
+				energy[x] += e
+			} else {
+				energy[y] += e
+			}
+		}
+	}
+
+	window := int(math.Round(float64(ch) * scale))
+	if horizontal {
+		window = int(math.Round(float64(cw) * scale))
+	}
+	if window > lines {
+		window = lines
+	}
+
+	var sum float64
+	for i := 0; i < window; i++ {
+		sum += energy[i]
+	}
+	// ties are resolved towards the center
+	best, bestSum := 0, sum
+	center := float64(lines-window) / 2
+	for i := 1; i+window <= lines; i++ {
+		sum += energy[i+window-1] - energy[i-1]
+		if sum > bestSum || (sum == bestSum && math.Abs(float64(i)-center) < math.Abs(float64(best)-center)) {
+			best, bestSum = i, sum
+		}
+	}
+
+	offset := int(math.Round(float64(best) / scale))
+	if horizontal {
+		if offset > w-cw {
+			offset = w - cw
+		}
+		return offset, 0
+	}
+	if offset > h-ch {
+		offset = h - ch
+	}
+
+	return 0, offset
+}
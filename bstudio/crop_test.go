@@ -0,0 +1,79 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCrop_PolicyValidate(t *testing.T) {
+	require.NoError(t, DefaultCoverPolicy.Validate(3000, 3000, 1<<20, false))
+	require.NoError(t, DefaultCoverPolicy.Validate(1400, 1410, 1<<20, false))
+
+	err := DefaultCoverPolicy.Validate(50, 100, 6<<20, false)
+	require.Error(t, err)
+
+	errs, ok := err.(ValidationErrors)
+	require.True(t, ok)
+	require.Len(t, errs, 4)
+	require.Equal(t, "file_too_large", errs[0].Code)
+	require.Equal(t, "width", errs[1].Field)
+	require.Equal(t, "aspect_ratio_mismatch", errs[3].Code)
+
+	// the crop takes care of the aspect ratio
+	require.NoError(t, DefaultCoverPolicy.Validate(1400, 1400, 1<<20, true))
+}
+
+func TestCrop_Center(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+
+	rect, err := cropRect(img, 1, CropCenter)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(50, 0, 250, 200), rect)
+
+	rect, err = cropRect(img, 3, CropCenter)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 50, 300, 150), rect)
+
+	_, err = cropRect(img, 1, "smart")
+	require.Error(t, err)
+}
+
+func TestCrop_Saliency(t *testing.T) {
+	// flat image with a checkerboard subject on the right side
+	img := image.NewRGBA(image.Rect(0, 0, 600, 200))
+	for x := 0; x < 600; x++ {
+		for y := 0; y < 200; y++ {
+			c := color.RGBA{R: 128, G: 128, B: 128, A: 255}
+			if x >= 420 && x < 580 && (x/10+y/10)%2 == 0 {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	rect, err := cropRect(img, 1, CropSaliency)
+	require.NoError(t, err)
+	require.Equal(t, 200, rect.Dx())
+	require.Equal(t, 200, rect.Dy())
+	require.True(t, rect.Min.X >= 370 && rect.Max.X <= 600, "crop %v misses the subject", rect)
+}
+
+func TestImage_ValidateCropped(t *testing.T) {
+	img := mockImage(t, 200, 100)
+	defer img.Delete()
+
+	preset := ImagePreset{Name: "cover", Policy: &ImagePolicy{MinWidth: 100, MinHeight: 100, Aspect: 1}}
+	require.Error(t, img.Validate(preset, 1000))
+
+	preset.Crop = CropCenter
+	require.NoError(t, img.Validate(preset, 1000))
+
+	preset.Sizes = []ImageSize{{Name: "50", Width: 50, Height: 50}}
+	preset.Formats = []string{FormatJPEG}
+	renditions, err := img.Render([]ImagePreset{preset})
+	require.NoError(t, err)
+	require.Equal(t, 50, renditions[0].Width)
+	require.Equal(t, 50, renditions[0].Height)
+}
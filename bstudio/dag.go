@@ -0,0 +1,206 @@
+package bstudio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ipfs/go-ipfs-api/options"
+	"io"
+	"time"
+)
+
+const (
+	CodecDagCbor = "dag-cbor"
+	CodecDagJSON = "dag-json"
+)
+
+// dagFormats maps a codec to the format option of the ipfs dag/put api.
+var dagFormats = map[string]string{
+	CodecDagCbor: "cbor",
+	CodecDagJSON: "dag-json",
+}
+
+// Link is an IPLD link, in its dag-json representation.
+type Link struct {
+	Cid string `json:"/"`
+}
+
+func NewLink(cid string) *Link {
+	if cid == "" {
+		return nil
+	}
+
+	return &Link{Cid: cid}
+}
+
+// ManifestHeader chains the versions of a manifest and carries the artist signature,
+// only the root object of a version has it.
+type ManifestHeader struct {
+	Version   int            `json:"version,omitempty"`
+	Previous  *Link          `json:"previous,omitempty"`
+	CreatedAt string         `json:"created_at,omitempty"`
+	Signature *SignatureNode `json:"signature,omitempty"`
+}
+
+// TrackNode is the IPLD object of a track, linking every artifact so that a
+// recursive pin of the node pins the media too.
+type TrackNode struct {
+	ManifestHeader
+	Schema  string           `json:"schema"`
+	Title   string           `json:"title"`
+	Artists []ManifestArtist `json:"artists"`
+	Audio   *Link            `json:"audio"`
+	Hls     *Link            `json:"hls"`
+	Cover   *Link            `json:"cover"`
+	Artwork *Link            `json:"artwork,omitempty"` // directory of every cover rendition
+	Genre   string           `json:"genre"`
+	Isrc    string           `json:"isrc,omitempty"`
+	Splits  []ManifestSplit  `json:"splits"`
+	License string           `json:"license"`
+}
+
+// ReleaseNode is the IPLD object of a release, the root of the whole release graph.
+type ReleaseNode struct {
+	ManifestHeader
+	Schema      string           `json:"schema"`
+	Title       string           `json:"title"`
+	Artists     []ManifestArtist `json:"artists"`
+	Cover       *Link            `json:"cover"`
+	Artwork     *Link            `json:"artwork,omitempty"`
+	Genre       string           `json:"genre"`
+	Upc         string           `json:"upc,omitempty"`
+	ReleaseDate string           `json:"release_date,omitempty"`
+	License     string           `json:"license"`
+	Tracks      []*Link          `json:"tracks"`
+}
+
+// ManifestDag is the result of storing a manifest as a DAG.
+type ManifestDag struct {
+	Cid    string   `json:"cid"`
+	Codec  string   `json:"codec"`
+	Tracks []string `json:"tracks,omitempty"`
+}
+
+func newTrackNode(m *Manifest, artwork string) *TrackNode {
+	return &TrackNode{
+		Schema:  m.Schema,
+		Title:   m.Title,
+		Artists: m.Artists,
+		Audio:   NewLink(m.Audio),
+		Hls:     NewLink(m.Hls),
+		Cover:   NewLink(m.Cover),
+		Artwork: NewLink(artwork),
+		Genre:   m.Genre,
+		Isrc:    m.Isrc,
+		Splits:  m.Splits,
+		License: m.License,
+	}
+}
+
+func newReleaseNode(m *Manifest, artwork string, tracks []string) *ReleaseNode {
+	n := &ReleaseNode{
+		Schema:      m.Schema,
+		Title:       m.Title,
+		Artists:     m.Artists,
+		Cover:       NewLink(m.Cover),
+		Artwork:     NewLink(artwork),
+		Genre:       m.Genre,
+		Upc:         m.Upc,
+		ReleaseDate: m.ReleaseDate,
+		License:     m.License,
+	}
+	for _, cid := range tracks {
+		n.Tracks = append(n.Tracks, NewLink(cid))
+	}
+
+	return n
+}
+
+// DagPut stores node, encoded as json, with the given codec and returns its cid.
+func (bs *BStudio) DagPut(node interface{}, codec string) (string, error) {
+	format, ok := dagFormats[codec]
+	if !ok {
+		return "", fmt.Errorf("unsupported dag codec %s", codec)
+	}
+
+	bz, err := json.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+
+	defer bs.Metrics.observeIPFS("dag_put", time.Now())
+	return bs.sh.DagPutWithOpts(bz, options.Dag.InputEnc("json"), options.Dag.Kind(format))
+}
+
+// DagGet decodes the object at ref, a cid optionally followed by a path, into out.
+func (bs *BStudio) DagGet(ctx context.Context, ref string, out interface{}) error {
+	defer bs.Metrics.observeIPFS("dag_get", time.Now())
+	return bs.sh.Request("dag/get", ref).Exec(ctx, out)
+}
+
+// ExportCar streams the whole DAG under cid as a CAR file, until ctx is done.
+func (bs *BStudio) ExportCar(ctx context.Context, cid string) (io.ReadCloser, error) {
+	res, err := bs.sh.Request("dag/export", cid).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		res.Close()
+		return nil, res.Error
+	}
+
+	return res.Output, nil
+}
+
+// PutManifest stores the manifest as a linked DAG, tracks first so that the release
+// can link them, and recursively pins the root.
+func (bs *BStudio) PutManifest(m *Manifest, header ManifestHeader) (*ManifestDag, error) {
+	res := &ManifestDag{Codec: bs.ManifestCodec}
+
+	if m.Schema != SchemaReleaseV1 {
+		node := newTrackNode(m, bs.artworkCid(m.Cover))
+		node.ManifestHeader = header
+
+		cid, err := bs.DagPut(node, bs.ManifestCodec)
+		if err != nil {
+			return nil, err
+		}
+		res.Cid = cid
+	} else {
+		for _, t := range m.Tracks {
+			cid, err := bs.DagPut(newTrackNode(t, bs.artworkCid(t.Cover)), bs.ManifestCodec)
+			if err != nil {
+				return nil, err
+			}
+			res.Tracks = append(res.Tracks, cid)
+		}
+
+		node := newReleaseNode(m, bs.artworkCid(m.Cover), res.Tracks)
+		node.ManifestHeader = header
+
+		cid, err := bs.DagPut(node, bs.ManifestCodec)
+		if err != nil {
+			return nil, err
+		}
+		res.Cid = cid
+	}
+
+	start := time.Now()
+	err := bs.sh.Pin(res.Cid)
+	bs.Metrics.observeIPFS("pin", start)
+	if err != nil {
+		return nil, fmt.Errorf("cannot pin %s: %w", res.Cid, err)
+	}
+
+	return res, nil
+}
+
+// artworkCid returns the directory holding every rendition of the cover, when it differs from the cover itself.
+func (bs *BStudio) artworkCid(cover string) string {
+	info, err := bs.GetImageInfo(cover)
+	if err != nil || info == nil || info.Cid == cover {
+		return ""
+	}
+
+	return info.Cid
+}
@@ -0,0 +1,52 @@
+package bstudio
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDag_TrackNodeLinks(t *testing.T) {
+	m, err := ParseManifest(marshalManifest(t, testTrackManifest()))
+	require.NoError(t, err)
+
+	bz, err := json.Marshal(newTrackNode(m, ""))
+	require.NoError(t, err)
+
+	var node map[string]interface{}
+	require.NoError(t, json.Unmarshal(bz, &node))
+	require.Equal(t, map[string]interface{}{"/": testAudioCid}, node["audio"])
+	require.Equal(t, map[string]interface{}{"/": testHlsCid}, node["hls"])
+	require.Equal(t, map[string]interface{}{"/": testCoverCid}, node["cover"])
+	require.NotContains(t, node, "artwork")
+}
+
+func TestDag_ReleaseNodeLinks(t *testing.T) {
+	m := &Manifest{Schema: SchemaReleaseV1, Title: "Release", Cover: "QmCover"}
+
+	bz, err := json.Marshal(newReleaseNode(m, "QmArtwork", []string{"QmTrack1", "QmTrack2"}))
+	require.NoError(t, err)
+	require.Contains(t, string(bz), `"artwork":{"/":"QmArtwork"}`)
+	require.Contains(t, string(bz), `"tracks":[{"/":"QmTrack1"},{"/":"QmTrack2"}]`)
+}
+
+func TestDag_ArtworkCid(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	require.NoError(t, bs.SaveImageInfo(&ImageInfo{
+		Cid:        "QmDir",
+		Renditions: []*ImageRendition{{Cid: "QmCover1400"}},
+	}))
+
+	require.Equal(t, "QmDir", bs.artworkCid("QmCover1400"))
+	require.Equal(t, "", bs.artworkCid("QmDir"))
+	require.Equal(t, "", bs.artworkCid("QmUnknown"))
+}
+
+func TestDag_UnsupportedCodec(t *testing.T) {
+	bs := &BStudio{}
+	_, err := bs.DagPut(&TrackNode{}, "dag-pb")
+	require.Error(t, err)
+}
@@ -1,9 +1,11 @@
 package bstudio
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/dgraph-io/badger"
 	"os"
+	"time"
 )
 
 type Ds struct {
@@ -11,14 +13,23 @@ type Ds struct {
 }
 
 func NewDs() *Ds {
-	// Open Badger, it will be created if it doesn't exist.
-	db, err := badger.Open(badger.DefaultOptions(os.ExpandEnv("$HOME/.bstudio/db")))
+	ds, err := OpenDs(os.ExpandEnv("$HOME/.bstudio/db"))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open badger db: %v", err)
 		os.Exit(1)
 	}
 
-	return &Ds{Db: db}
+	return ds
+}
+
+// OpenDs opens the badger database of dir, it is created if it doesn't exist.
+func OpenDs(dir string) (*Ds, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ds{Db: db}, nil
 }
 
 func (ds *Ds) SetAndCommit(key, val []byte) error {
@@ -55,3 +66,58 @@ func (ds *Ds) Get(key []byte) ([]byte, error) {
 
 	return valCopy, nil
 }
+
+// Iterate calls fn with a copy of every key and value starting with prefix, in key order.
+func (ds *Ds) Iterate(prefix []byte, fn func(key, val []byte) error) error {
+	return ds.Db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(item.KeyCopy(nil), val); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (ds *Ds) Delete(key []byte) error {
+	return ds.Db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// SetWithTTL stores a value that badger drops once ttl has elapsed.
+func (ds *Ds) SetWithTTL(key, val []byte, ttl time.Duration) error {
+	return ds.Db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, val).WithTTL(ttl))
+	})
+}
+
+// IterateRange calls fn with a copy of every key and value from start included to end excluded, in key order.
+func (ds *Ds) IterateRange(start, end []byte, fn func(key, val []byte) error) error {
+	return ds.Db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(start); it.Valid() && bytes.Compare(it.Item().Key(), end) < 0; it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(item.KeyCopy(nil), val); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
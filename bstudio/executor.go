@@ -0,0 +1,271 @@
+package bstudio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stages of a job, each with its own timeout.
+const (
+	StageProbe  = "probe"
+	StageMp3    = "mp3"
+	StageHls    = "hls"
+	StagePoster = "poster"
+	StageSprite = "sprite"
+	StageImage  = "image"
+)
+
+// Reason codes of a failed ffmpeg or ffprobe run.
+const (
+	ReasonTimeout       = "timeout"
+	ReasonCanceled      = "canceled"
+	ReasonCPULimit      = "cpu_limit"
+	ReasonMemoryLimit   = "memory_limit"
+	ReasonFileSizeLimit = "file_size_limit"
+	ReasonExitStatus    = "exit_status"
+	ReasonExecFailed    = "exec_failed"
+
+	// the end of stderr kept for the error
+	maxExecStderr = 64 << 10
+
+	// the end of the stderr is logged, it has the error of ffmpeg
+	maxLoggedStderr = 4 << 10
+)
+
+var DefaultStageTimeouts = map[string]time.Duration{
+	StageProbe:  30 * time.Second,
+	StageMp3:    10 * time.Minute,
+	StageHls:    30 * time.Minute,
+	StagePoster: 2 * time.Minute,
+	StageSprite: 10 * time.Minute,
+	StageImage:  time.Minute,
+}
+
+// ParseStageTimeouts reads stage=duration pairs separated by commas, e.g. probe=30s,hls=1h.
+// The stages left out keep their default timeout, 0 disables it.
+func ParseStageTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(DefaultStageTimeouts))
+	for stage, d := range DefaultStageTimeouts {
+		timeouts[stage] = d
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if _, ok := DefaultStageTimeouts[kv[0]]; !ok {
+			return nil, fmt.Errorf("unknown stage %s", kv[0])
+		}
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("stage timeout %s must be stage=duration", pair)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid timeout %s of stage %s", kv[1], kv[0])
+		}
+
+		timeouts[kv[0]] = d
+	}
+
+	return timeouts, nil
+}
+
+// FormatStageTimeouts is the inverse of ParseStageTimeouts.
+func FormatStageTimeouts(timeouts map[string]time.Duration) string {
+	stages := make([]string, 0, len(timeouts))
+	for stage := range timeouts {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	pairs := make([]string, len(stages))
+	for i, stage := range stages {
+		pairs[i] = fmt.Sprintf("%s=%s", stage, timeouts[stage])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// ExecLimitsCommand is the hidden command of the bstudio executable applying the rlimits before running
+// ffmpeg or ffprobe: the processes are started as `bstudio exec-limits <cpu:memory:file size> <path> <args>`.
+// A program running the executor with limits must call ExecWithLimits when started with it.
+const ExecLimitsCommand = "exec-limits"
+
+// ExecLimits are the rlimits of every process, zero is unlimited.
+type ExecLimits struct {
+	CPU      time.Duration `json:"cpu" yaml:"cpu"`
+	Memory   uint64        `json:"memory" yaml:"memory"`       // address space in bytes
+	FileSize uint64        `json:"file_size" yaml:"file_size"` // largest file written in bytes
+}
+
+var DefaultExecLimits = ExecLimits{
+	CPU:      time.Hour,
+	Memory:   4 << 30,
+	FileSize: 16 << 30,
+}
+
+// Executor runs ffmpeg and ffprobe on untrusted files, with a timeout per stage and rlimits.
+type Executor struct {
+	Limits   ExecLimits
+	Timeouts map[string]time.Duration
+
+	// Isolate runs the processes in new user, network, IPC and UTS namespaces (linux only),
+	// they still see the filesystem of bstudio
+	Isolate bool
+
+	// Wrapper prefixes every command, e.g. to apply a seccomp profile with nsjail or bwrap
+	Wrapper []string
+}
+
+// defaultExecutor runs the commands of a BStudio without executor.
+var defaultExecutor = NewExecutor()
+
+func NewExecutor() *Executor {
+	timeouts := make(map[string]time.Duration, len(DefaultStageTimeouts))
+	for s, d := range DefaultStageTimeouts {
+		timeouts[s] = d
+	}
+
+	return &Executor{Limits: DefaultExecLimits, Timeouts: timeouts}
+}
+
+// ExecResult is the output of a successful run.
+type ExecResult struct {
+	Stdout []byte
+	Stderr []byte
+	CPU    time.Duration
+}
+
+// ExecError is a failed run, Reason tells a limit hit from a broken input.
+type ExecError struct {
+	Stage  string
+	Reason string
+	Err    error
+	Stderr string
+	CPU    time.Duration
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%s failed (%s): %s", e.Stage, e.Reason, e.Err)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// ExecReason returns the reason code of err if it comes from an executor run.
+func ExecReason(err error) string {
+	var eerr *ExecError
+	if errors.As(err, &eerr) {
+		return eerr.Reason
+	}
+
+	return ""
+}
+
+// stderrTail returns the last bytes of the stderr of a run, to be logged.
+func stderrTail(stderr string) string {
+	if len(stderr) > maxLoggedStderr {
+		return stderr[len(stderr)-maxLoggedStderr:]
+	}
+
+	return stderr
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+
+	return len(p), nil
+}
+
+// Run runs name with args for stage, the process is killed when ctx is done or the stage times out.
+func (e *Executor) Run(ctx context.Context, stage, name string, args ...string) (*ExecResult, error) {
+	if d := e.Timeouts[stage]; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	// the rlimits are set before exec, they bind the wrapper too
+	argv := append(append(append([]string{}, e.Wrapper...), name), args...)
+	cmd, err := command(ctx, argv, e.Limits)
+	if err != nil {
+		return nil, &ExecError{Stage: stage, Reason: ReasonExecFailed, Err: err}
+	}
+
+	var stdout bytes.Buffer
+	stderr := &tailBuffer{max: maxExecStderr}
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+
+	attr, err := sysProcAttr(e.Isolate)
+	if err != nil {
+		return nil, &ExecError{Stage: stage, Reason: ReasonExecFailed, Err: err}
+	}
+	cmd.SysProcAttr = attr
+
+	if err := cmd.Start(); err != nil {
+		return nil, &ExecError{Stage: stage, Reason: ReasonExecFailed, Err: err}
+	}
+
+	err = cmd.Wait()
+
+	var cpu time.Duration
+	if cmd.ProcessState != nil {
+		cpu = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	}
+	if err == nil {
+		return &ExecResult{Stdout: stdout.Bytes(), Stderr: stderr.buf, CPU: cpu}, nil
+	}
+
+	return nil, &ExecError{
+		Stage:  stage,
+		Reason: e.reason(ctx, cmd.ProcessState, cpu, string(stderr.buf)),
+		Err:    err,
+		Stderr: string(stderr.buf),
+		CPU:    cpu,
+	}
+}
+
+// reason classifies a failed run from the context, the signal that ended it and its stderr.
+func (e *Executor) reason(ctx context.Context, state *os.ProcessState, cpu time.Duration, stderr string) string {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return ReasonTimeout
+	case context.Canceled:
+		return ReasonCanceled
+	}
+
+	if state != nil {
+		if reason := limitReason(state, cpu, e.Limits); reason != "" {
+			return reason
+		}
+	}
+
+	if e.Limits.FileSize > 0 && strings.Contains(stderr, "File too large") {
+		return ReasonFileSizeLimit
+	}
+	if e.Limits.Memory > 0 && (strings.Contains(stderr, "Cannot allocate memory") || strings.Contains(stderr, "out of memory")) {
+		return ReasonMemoryLimit
+	}
+
+	return ReasonExitStatus
+}
@@ -0,0 +1,119 @@
+package bstudio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// ExecWithLimits applies the limits, as formatted by the executor, to the calling process then replaces
+// it with argv. It only returns on failure.
+func ExecWithLimits(limits string, argv []string) error {
+	var l ExecLimits
+	var cpu int64
+	if _, err := fmt.Sscanf(limits, "%d:%d:%d", &cpu, &l.Memory, &l.FileSize); err != nil {
+		return fmt.Errorf("invalid limits %q: %w", limits, err)
+	}
+	l.CPU = time.Duration(cpu) * time.Second
+	if len(argv) == 0 {
+		return fmt.Errorf("no command")
+	}
+
+	if err := setRlimits(l); err != nil {
+		return fmt.Errorf("cannot set rlimits: %w", err)
+	}
+
+	return syscall.Exec(argv[0], argv, os.Environ())
+}
+
+// command returns the command running argv with limits, through the bstudio helper when there are any.
+func command(ctx context.Context, argv []string, limits ExecLimits) (*exec.Cmd, error) {
+	if limits == (ExecLimits{}) {
+		return exec.CommandContext(ctx, argv[0], argv[1:]...), nil
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the bstudio executable: %w", err)
+	}
+
+	args := []string{
+		ExecLimitsCommand,
+		fmt.Sprintf("%d:%d:%d", int64(limits.CPU.Seconds()), limits.Memory, limits.FileSize),
+		path,
+	}
+	return exec.CommandContext(ctx, self, append(args, argv[1:]...)...), nil
+}
+
+// sysProcAttr kills the process with bstudio and, isolated, gives it its own namespaces:
+// no network, and root only inside its user namespace. The filesystem stays the one of bstudio,
+// a Wrapper like nsjail or bwrap restricts it.
+func sysProcAttr(isolate bool) (*syscall.SysProcAttr, error) {
+	attr := &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if !isolate {
+		return attr, nil
+	}
+
+	attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+
+	return attr, nil
+}
+
+// setRlimits applies limits to the calling process. The hard cpu limit leaves a few
+// seconds after SIGXCPU before the kernel kills it.
+func setRlimits(limits ExecLimits) error {
+	if limits.CPU > 0 {
+		secs := uint64(limits.CPU.Seconds())
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: secs, Max: secs + 5}); err != nil {
+			return err
+		}
+	}
+	if limits.Memory > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: limits.Memory, Max: limits.Memory}); err != nil {
+			return err
+		}
+	}
+	if limits.FileSize > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: limits.FileSize, Max: limits.FileSize}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// limitReason tells from how the process ended whether it hit an rlimit.
+func limitReason(state *os.ProcessState, cpu time.Duration, limits ExecLimits) string {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		switch ws.Signal() {
+		case syscall.SIGXCPU:
+			return ReasonCPULimit
+		case syscall.SIGXFSZ:
+			return ReasonFileSizeLimit
+		case syscall.SIGKILL:
+			// the hard cpu limit kills without SIGXCPU
+			if limits.CPU > 0 && cpu >= limits.CPU {
+				return ReasonCPULimit
+			}
+		}
+	}
+
+	// a wrapper or a shell exits with the signal of its child
+	switch state.ExitCode() {
+	case 128 + int(syscall.SIGXCPU):
+		return ReasonCPULimit
+	case 128 + int(syscall.SIGXFSZ):
+		return ReasonFileSizeLimit
+	}
+
+	return ""
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package bstudio
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// ExecWithLimits fails, the executor never starts the helper outside linux.
+func ExecWithLimits(limits string, argv []string) error {
+	return errors.New("rlimits are only supported on linux")
+}
+
+// command ignores the limits, the timeouts still apply.
+func command(ctx context.Context, argv []string, limits ExecLimits) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, argv[0], argv[1:]...), nil
+}
+
+func sysProcAttr(isolate bool) (*syscall.SysProcAttr, error) {
+	if isolate {
+		return nil, errors.New("process isolation is only supported on linux")
+	}
+
+	return nil, nil
+}
+
+func limitReason(state *os.ProcessState, cpu time.Duration, limits ExecLimits) string {
+	return ""
+}
@@ -0,0 +1,180 @@
+package bstudio
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain makes the test binary the helper applying the rlimits, like the bstudio command.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == ExecLimitsCommand {
+		err := ExecWithLimits(os.Args[2], os.Args[3:])
+		fmt.Fprintf(os.Stderr, "cannot run %v: %s\n", os.Args[3:], err)
+		os.Exit(127)
+	}
+
+	os.Exit(m.Run())
+}
+
+func testExecutor() *Executor {
+	e := NewExecutor()
+	e.Limits = ExecLimits{}
+	return e
+}
+
+func TestExecutor_Run(t *testing.T) {
+	res, err := testExecutor().Run(context.Background(), StageProbe, "sh", "-c", "echo out; echo err >&2")
+	require.NoError(t, err)
+	require.Equal(t, "out\n", string(res.Stdout))
+	require.Equal(t, "err\n", string(res.Stderr))
+}
+
+func TestExecutor_ExitStatus(t *testing.T) {
+	_, err := testExecutor().Run(context.Background(), StageHls, "sh", "-c", "echo broken >&2; exit 3")
+	require.Error(t, err)
+
+	eerr, ok := err.(*ExecError)
+	require.True(t, ok)
+	require.Equal(t, StageHls, eerr.Stage)
+	require.Equal(t, ReasonExitStatus, eerr.Reason)
+	require.Equal(t, "broken\n", eerr.Stderr)
+	require.Equal(t, ReasonExitStatus, ExecReason(err))
+}
+
+func TestExecutor_ExecFailed(t *testing.T) {
+	_, err := testExecutor().Run(context.Background(), StageProbe, "bstudio-no-such-binary")
+	require.Equal(t, ReasonExecFailed, ExecReason(err))
+}
+
+func TestExecutor_Timeout(t *testing.T) {
+	e := testExecutor()
+	e.Timeouts[StageProbe] = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := e.Run(context.Background(), StageProbe, "sleep", "5")
+	require.Equal(t, ReasonTimeout, ExecReason(err))
+	require.True(t, time.Since(start) < 2*time.Second)
+}
+
+func TestExecutor_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := testExecutor().Run(ctx, StageHls, "sleep", "5")
+	require.Equal(t, ReasonCanceled, ExecReason(err))
+}
+
+func TestExecutor_FileSizeLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are only applied on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "bstudio-exec")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := testExecutor()
+	e.Limits.FileSize = 1024
+
+	_, err = e.Run(context.Background(), StageHls, "sh", "-c", "exec head -c 4096 /dev/zero > "+filepath.Join(dir, "out"))
+	require.Equal(t, ReasonFileSizeLimit, ExecReason(err))
+}
+
+func TestExecutor_LimitsBeforeExec(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are only applied on linux")
+	}
+
+	e := testExecutor()
+	e.Limits = ExecLimits{CPU: time.Minute, Memory: 1 << 30, FileSize: 1 << 20}
+
+	// the command reads its own limits, they are set from its start
+	res, err := e.Run(context.Background(), StageProbe, "cat", "/proc/self/limits")
+	require.NoError(t, err)
+	require.Regexp(t, `Max cpu time\s+60\s+65\s+seconds`, string(res.Stdout))
+	require.Regexp(t, `Max file size\s+1048576\s+1048576\s+bytes`, string(res.Stdout))
+	require.Regexp(t, `Max address space\s+1073741824\s+1073741824\s+bytes`, string(res.Stdout))
+
+	// the command gets its own arguments, not the ones of the helper
+	res, err = e.Run(context.Background(), StageProbe, "sh", "-c", `echo "$0 $1"`, "first", "second")
+	require.NoError(t, err)
+	require.Equal(t, "first second\n", string(res.Stdout))
+
+	_, err = e.Run(context.Background(), StageProbe, "bstudio-no-such-binary")
+	require.Equal(t, ReasonExecFailed, ExecReason(err))
+}
+
+func TestExecutor_CPULimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are only applied on linux")
+	}
+
+	e := testExecutor()
+	e.Limits.CPU = time.Second
+
+	_, err := e.Run(context.Background(), StageHls, "sh", "-c", "while :; do :; done")
+	require.Equal(t, ReasonCPULimit, ExecReason(err))
+}
+
+func TestExecutor_Isolate(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("isolation is only supported on linux")
+	}
+
+	e := testExecutor()
+	e.Isolate = true
+	// the helper applying the limits runs in the namespaces too
+	e.Limits.FileSize = 1 << 20
+
+	res, err := e.Run(context.Background(), StageProbe, "sh", "-c", "id -u")
+	if ExecReason(err) == ReasonExecFailed {
+		t.Skipf("user namespaces are not available: %s", err)
+	}
+	require.NoError(t, err)
+	require.Equal(t, "0\n", string(res.Stdout))
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 4}
+	b.Write([]byte("abc"))
+	b.Write([]byte("def"))
+	require.Equal(t, "cdef", string(b.buf))
+}
+
+func TestStderrTail(t *testing.T) {
+	require.Equal(t, "short", stderrTail("short"))
+
+	long := strings.Repeat("a", maxLoggedStderr) + "Invalid data found"
+	tail := stderrTail(long)
+	require.Len(t, tail, maxLoggedStderr)
+	require.True(t, strings.HasSuffix(tail, "Invalid data found"))
+}
+
+func TestParseStageTimeouts(t *testing.T) {
+	timeouts, err := ParseStageTimeouts("hls=1h, probe=0")
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, timeouts[StageHls])
+	require.Equal(t, time.Duration(0), timeouts[StageProbe])
+	require.Equal(t, DefaultStageTimeouts[StageMp3], timeouts[StageMp3])
+
+	parsed, err := ParseStageTimeouts(FormatStageTimeouts(DefaultStageTimeouts))
+	require.NoError(t, err)
+	require.Equal(t, DefaultStageTimeouts, parsed)
+
+	for _, s := range []string{"encode=1m", "hls", "hls=soon", "hls=-1s"} {
+		_, err := ParseStageTimeouts(s)
+		require.Error(t, err, s)
+	}
+}
@@ -1,9 +1,10 @@
 package bstudio
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"os/exec"
+	"strconv"
+	"strings"
 )
 
 type ffProbeFormat struct {
@@ -14,15 +15,25 @@ type ffProbeFormat struct {
 	Size         int64   `json:"size,string"`
 }
 
-type ffProbe struct {
-	Format ffProbeFormat `json:"format"`
+type ffProbeStream struct {
+	Index        int    `json:"index"`
+	CodecType    string `json:"codec_type"`
+	CodecName    string `json:"codec_name"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+	SampleRate   string `json:"sample_rate"`
+	Channels     int    `json:"channels"`
 }
 
-func NewFFProbe(path string) (*ffProbe, error) {
-	// TODO: if path exist
+type ffProbe struct {
+	Format  ffProbeFormat   `json:"format"`
+	Streams []ffProbeStream `json:"streams"`
+}
 
-	cmd := exec.Command(
-		"ffprobe",
+// Probe reads the format and the streams of the file at path with ffprobe.
+func (e *Executor) Probe(ctx context.Context, path string) (*ffProbe, error) {
+	res, err := e.Run(ctx, StageProbe, "ffprobe",
 		"-v",
 		"error",
 		"-i",
@@ -30,32 +41,61 @@ func NewFFProbe(path string) (*ffProbe, error) {
 		"-print_format",
 		"json",
 		"-show_format",
+		"-show_streams",
 	)
-
-	var (
-		// There are some uneeded information inside StdOut, skip it
-		ffprobeStdOut bytes.Buffer
-		ffprobeStdErr bytes.Buffer
-	)
-
-	cmd.Stdout = &ffprobeStdOut
-	cmd.Stderr = &ffprobeStdErr
-
-	err := cmd.Run()
 	if err != nil {
 		return &ffProbe{}, err
 	}
 
-	ffprobeOutput := ffprobeStdOut.Bytes()
 	out := &ffProbe{}
-	err = json.Unmarshal(ffprobeOutput, &out)
-	if err != nil {
+	if err := json.Unmarshal(res.Stdout, &out); err != nil {
 		return &ffProbe{}, err
 	}
 
-	return out, err
+	return out, nil
 }
 
 func (f *ffProbe) GetDuration() float32 {
 	return f.Format.Duration
 }
+
+// VideoStream returns the first video stream, or nil for audio only files.
+// Attached pictures such as mp3 cover art are reported by ffprobe as mjpeg/png video streams.
+func (f *ffProbe) VideoStream() *ffProbeStream {
+	for i, s := range f.Streams {
+		if s.CodecType == "video" && s.CodecName != "mjpeg" && s.CodecName != "png" {
+			return &f.Streams[i]
+		}
+	}
+
+	return nil
+}
+
+func (f *ffProbe) HasAudio() bool {
+	for _, s := range f.Streams {
+		if s.CodecType == "audio" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FrameRate parses the ffprobe rational frame rate, e.g. "30000/1001".
+func (s *ffProbeStream) FrameRate() float64 {
+	parts := strings.SplitN(s.AvgFrameRate, "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+
+	return num / den
+}
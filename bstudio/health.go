@@ -0,0 +1,256 @@
+package bstudio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+	HealthSkip = "skip"
+
+	CheckIPFS     = "ipfs"
+	CheckBadger   = "badger"
+	CheckFFmpeg   = "ffmpeg"
+	CheckFFprobe  = "ffprobe"
+	CheckCodecs   = "codecs"
+	CheckTmpDisk  = "tmp_disk"
+	CheckQueue    = "queue"
+	CheckStopping = "stopping"
+	// CheckReadiness fails when the caller gave up waiting for the checks
+	CheckReadiness = "readiness"
+
+	healthCheckTimeout = 5 * time.Second
+	healthKeyPrefix    = "health/"
+
+	// the report is reused for that long, the probes and the clients polling it do not run the checks each
+	readinessCacheTTL = 5 * time.Second
+
+	DefaultMinFreeDisk    = 1 << 30
+	DefaultMaxPendingJobs = 100
+)
+
+// RequiredEncoders are the ffmpeg encoders without which no job can succeed: the mp3, the AAC
+// renditions and the video ladder. The other HLS encoders are optional, their renditions are skipped.
+var RequiredEncoders = []string{"libmp3lame", "aac", "libx264"}
+
+// errUnsupported is returned by the checks not available on the platform, they are skipped.
+var errUnsupported = errors.New("not supported on this platform")
+
+// HealthCheck is the result of a readiness check.
+type HealthCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport is ok when none of its checks failed.
+type HealthReport struct {
+	Status string         `json:"status"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+// CachedReadiness returns the report of the last Readiness run when it is recent enough, else refreshes it.
+// The refresh runs detached from the callers, who wait for it until their ctx is done: a caller going away
+// neither cancels the checks nor leaves a failed report in the cache. It fails without running the checks once stopping.
+func (bs *BStudio) CachedReadiness(ctx context.Context) *HealthReport {
+	if bs.Stopping() {
+		return failedReport(CheckStopping, ErrShuttingDown)
+	}
+
+	bs.readiness.mu.Lock()
+	if bs.readiness.report != nil && time.Since(bs.readiness.at) <= readinessCacheTTL {
+		report := bs.readiness.report
+		bs.readiness.mu.Unlock()
+		return report
+	}
+	if bs.readiness.refresh == nil {
+		bs.readiness.refresh = make(chan struct{})
+		go bs.refreshReadiness(bs.readiness.refresh)
+	}
+	refresh := bs.readiness.refresh
+	bs.readiness.mu.Unlock()
+
+	select {
+	case <-refresh:
+	case <-ctx.Done():
+		return failedReport(CheckReadiness, ctx.Err())
+	}
+
+	bs.readiness.mu.Lock()
+	defer bs.readiness.mu.Unlock()
+	return bs.readiness.report
+}
+
+// refreshReadiness runs the checks into the cache then closes done.
+func (bs *BStudio) refreshReadiness(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	report := bs.Readiness(ctx)
+
+	bs.readiness.mu.Lock()
+	bs.readiness.report = report
+	bs.readiness.at = time.Now()
+	bs.readiness.refresh = nil
+	bs.readiness.mu.Unlock()
+	close(done)
+}
+
+// failedReport is a report with the single failed check name.
+func failedReport(name string, err error) *HealthReport {
+	return &HealthReport{Status: HealthFail, Checks: []*HealthCheck{
+		{Name: name, Status: HealthFail, Error: err.Error(), Duration: "0s"},
+	}}
+}
+
+// Readiness runs every dependency check concurrently, each with its own timeout.
+func (bs *BStudio) Readiness(ctx context.Context) *HealthReport {
+	checks := map[string]func(context.Context) error{
+		CheckIPFS:    bs.checkIPFS,
+		CheckBadger:  bs.checkBadger,
+		CheckFFmpeg:  bs.checkCommand("ffmpeg"),
+		CheckFFprobe: bs.checkCommand("ffprobe"),
+		CheckCodecs:  bs.checkCodecs,
+		CheckTmpDisk: bs.checkTmpDisk,
+		CheckQueue:   bs.checkQueue,
+	}
+
+	report := &HealthReport{Status: HealthOK}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+
+			cctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(cctx)
+			res := &HealthCheck{Name: name, Status: HealthOK, Duration: time.Since(start).String()}
+			switch {
+			case errors.Is(err, errUnsupported):
+				res.Status = HealthSkip
+				res.Error = err.Error()
+			case err != nil:
+				res.Status = HealthFail
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks = append(report.Checks, res)
+			if res.Status == HealthFail {
+				report.Status = HealthFail
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Name < report.Checks[j].Name
+	})
+
+	return report
+}
+
+// checkIPFS calls the version endpoint of the IPFS API.
+func (bs *BStudio) checkIPFS(ctx context.Context) error {
+	if bs.sh == nil {
+		return fmt.Errorf("no ipfs api configured")
+	}
+
+	var version struct{ Version string }
+	if err := bs.sh.Request("version").Exec(ctx, &version); err != nil {
+		return fmt.Errorf("ipfs api: %w", err)
+	}
+
+	return nil
+}
+
+// checkBadger writes, reads back and deletes a short lived key.
+func (bs *BStudio) checkBadger(context.Context) error {
+	key := []byte(fmt.Sprintf("%s%d", healthKeyPrefix, time.Now().UnixNano()))
+	if err := bs.Ds.SetWithTTL(key, []byte(HealthOK), time.Minute); err != nil {
+		return err
+	}
+	val, err := bs.Ds.Get(key)
+	if err != nil {
+		return err
+	}
+	if string(val) != HealthOK {
+		return fmt.Errorf("read back %q instead of %q", val, HealthOK)
+	}
+
+	return bs.Ds.Delete(key)
+}
+
+func (bs *BStudio) checkCommand(name string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := bs.executor().Run(ctx, StageProbe, name, "-hide_banner", "-version")
+		return err
+	}
+}
+
+// checkCodecs lists the encoders of ffmpeg every time, unlike HasEncoder, so that an upgrade is seen.
+func (bs *BStudio) checkCodecs(ctx context.Context) error {
+	encoders, err := listEncoders(ctx, bs.executor())
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, name := range RequiredEncoders {
+		if !encoders[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing ffmpeg encoders: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// checkTmpDisk fails when TmpDir has less than MinFreeDisk bytes available.
+func (bs *BStudio) checkTmpDisk(context.Context) error {
+	free, err := freeDiskSpace(TmpDir)
+	if err != nil {
+		return err
+	}
+	if free < bs.MinFreeDisk {
+		return fmt.Errorf("%d MB free in %s, less than %d MB", free>>20, TmpDir, bs.MinFreeDisk>>20)
+	}
+
+	return nil
+}
+
+// checkQueue fails when more than MaxPendingJobs jobs are stored, queued or running, 0 disables it.
+// The stored jobs tell the backlog, unlike the channel which only holds the next ones.
+func (bs *BStudio) checkQueue(context.Context) error {
+	if bs.MaxPendingJobs <= 0 {
+		return nil
+	}
+
+	n := 0
+	err := bs.Ds.Iterate([]byte(jobPrefix), func(key, val []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if n > bs.MaxPendingJobs {
+		return fmt.Errorf("%d transcoding jobs pending, more than %d", n, bs.MaxPendingJobs)
+	}
+
+	return nil
+}
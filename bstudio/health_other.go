@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package bstudio
+
+func freeDiskSpace(string) (uint64, error) {
+	return 0, errUnsupported
+}
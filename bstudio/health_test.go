@@ -0,0 +1,169 @@
+package bstudio
+
+import (
+	"context"
+	"errors"
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeFFmpeg puts ffmpeg and ffprobe scripts first in the PATH, ffmpeg lists the given encoders.
+func fakeFFmpeg(t *testing.T, encoders ...string) func() {
+	dir, err := ioutil.TempDir("", "bstudio-ffmpeg")
+	require.NoError(t, err)
+
+	list := ""
+	for _, e := range encoders {
+		list += " A..... " + e + "  fake encoder\n"
+	}
+	ffmpeg := "#!/bin/sh\nif [ \"$2\" = -encoders ]; then printf '" + list + "'; fi\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(ffmpeg), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ffprobe"), []byte("#!/bin/sh\n"), 0755))
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func checkStatus(report *HealthReport) map[string]string {
+	status := make(map[string]string)
+	for _, c := range report.Checks {
+		status[c.Name] = c.Status
+	}
+	return status
+}
+
+func TestReadiness(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	defer fakeFFmpeg(t, RequiredEncoders...)()
+
+	bs := &BStudio{Ds: ds, Executor: NewExecutor()}
+
+	report := bs.Readiness(context.Background())
+	require.Equal(t, HealthFail, report.Status)
+	require.Equal(t, map[string]string{
+		CheckBadger:  HealthOK,
+		CheckCodecs:  HealthOK,
+		CheckFFmpeg:  HealthOK,
+		CheckFFprobe: HealthOK,
+		CheckIPFS:    HealthFail,
+		CheckQueue:   HealthOK,
+		CheckTmpDisk: HealthOK,
+	}, checkStatus(report))
+
+	// the health key is not left behind
+	keys := 0
+	require.NoError(t, ds.Iterate([]byte(healthKeyPrefix), func(key, val []byte) error {
+		keys++
+		return nil
+	}))
+	require.Zero(t, keys)
+}
+
+func TestReadiness_Degraded(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	defer fakeFFmpeg(t, "aac")()
+
+	bs := &BStudio{Ds: ds, Executor: NewExecutor(), MinFreeDisk: 1 << 62, MaxPendingJobs: 1}
+	require.NoError(t, ds.SetAndCommit(jobKey("1"), []byte("{}")))
+	require.NoError(t, ds.SetAndCommit(jobKey("2"), []byte("{}")))
+
+	report := bs.Readiness(context.Background())
+	status := checkStatus(report)
+	require.Equal(t, HealthFail, status[CheckCodecs])
+	require.Equal(t, HealthFail, status[CheckQueue])
+	require.Equal(t, HealthFail, status[CheckTmpDisk])
+
+	for _, c := range report.Checks {
+		switch c.Name {
+		case CheckCodecs:
+			require.Equal(t, "missing ffmpeg encoders: libmp3lame, libx264", c.Error)
+		case CheckQueue:
+			require.Equal(t, "2 transcoding jobs pending, more than 1", c.Error)
+		}
+	}
+}
+
+func TestReadiness_QueueBacklog(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds, MaxPendingJobs: 2}
+
+	// the job statuses and cid pointers are not pending jobs
+	require.NoError(t, ds.SetAndCommit(jobKey("1"), []byte("{}")))
+	require.NoError(t, ds.SetAndCommit(jobStatusKey("1"), []byte("{}")))
+	require.NoError(t, ds.SetAndCommit(jobCidKey("QmA"), []byte("1")))
+	require.NoError(t, ds.SetAndCommit(jobKey("2"), []byte("{}")))
+	require.NoError(t, bs.checkQueue(context.Background()))
+
+	require.NoError(t, ds.SetAndCommit(jobKey("3"), []byte("{}")))
+	require.Error(t, bs.checkQueue(context.Background()))
+
+	bs.MaxPendingJobs = 0
+	require.NoError(t, bs.checkQueue(context.Background()))
+}
+
+func TestReadiness_IPFSTimeout(t *testing.T) {
+	release := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer api.Close()
+	defer close(release)
+
+	bs := &BStudio{sh: shell.NewShell(api.Listener.Addr().String())}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := bs.checkIPFS(ctx)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), err.Error())
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestReadiness_Cached(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	defer fakeFFmpeg(t, RequiredEncoders...)()
+	bs := &BStudio{Ds: ds, Executor: NewExecutor()}
+
+	report := bs.CachedReadiness(context.Background())
+	require.True(t, report == bs.CachedReadiness(context.Background()))
+
+	bs.readiness.at = time.Now().Add(-readinessCacheTTL - time.Second)
+	require.False(t, report == bs.CachedReadiness(context.Background()))
+
+	// a caller going away does not cancel the refresh nor leave its failure in the cache
+	bs.readiness.at = time.Now().Add(-readinessCacheTTL - time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report = bs.CachedReadiness(ctx)
+	require.Equal(t, map[string]string{CheckReadiness: HealthFail}, checkStatus(report))
+	report = bs.CachedReadiness(context.Background())
+	require.Contains(t, checkStatus(report), CheckBadger)
+	require.Equal(t, HealthOK, checkStatus(report)[CheckBadger])
+
+	// once stopping the instance is not ready, whatever the cache
+	bs.stopping = make(chan struct{})
+	bs.StopAccepting()
+	report = bs.CachedReadiness(context.Background())
+	require.Equal(t, HealthFail, report.Status)
+	require.Equal(t, map[string]string{CheckStopping: HealthFail}, checkStatus(report))
+}
@@ -0,0 +1,207 @@
+package bstudio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the media playlists have their own name, a rendition written next to the master must not replace it
+	hlsMasterPlaylist  = "playlist.m3u8"
+	hlsMediaPlaylist   = "index.m3u8"
+	hlsInitSegment     = "init.mp4"
+	hlsSegmentPattern  = "segment%03d.m4s"
+	hlsSegmentDuration = 6
+)
+
+// HlsProfile describes a single audio rendition of the HLS ladder.
+// Every rendition is written as CMAF (fragmented MP4) segments with an init segment.
+type HlsProfile struct {
+	Name       string `json:"name" yaml:"name"`
+	Encoder    string `json:"encoder" yaml:"encoder"`         // ffmpeg encoder: aac, libfdk_aac, libopus
+	Profile    string `json:"profile" yaml:"profile"`         // ffmpeg -profile:a value, empty for opus
+	Codecs     string `json:"codecs" yaml:"codecs"`           // RFC 6381 codec string used in the master playlist
+	Bitrate    uint   `json:"bitrate" yaml:"bitrate"`         // kbit/s
+	SampleRate uint   `json:"sample_rate" yaml:"sample_rate"` // Hz
+	Channels   uint   `json:"channels" yaml:"channels"`
+}
+
+// DefaultHlsProfiles is the rendition ladder used when none is configured.
+// Profiles whose encoder is not available in the local ffmpeg build are skipped.
+var DefaultHlsProfiles = []HlsProfile{
+	{Name: "aac_lc_256", Encoder: "aac", Profile: "aac_low", Codecs: "mp4a.40.2", Bitrate: 256, SampleRate: 48000, Channels: 2},
+	{Name: "aac_lc_128", Encoder: "aac", Profile: "aac_low", Codecs: "mp4a.40.2", Bitrate: 128, SampleRate: 48000, Channels: 2},
+	{Name: "he_aac_64", Encoder: "libfdk_aac", Profile: "aac_he", Codecs: "mp4a.40.5", Bitrate: 64, SampleRate: 48000, Channels: 2},
+	{Name: "opus_128", Encoder: "libopus", Codecs: "Opus", Bitrate: 128, SampleRate: 48000, Channels: 2},
+}
+
+// args returns the ffmpeg arguments that encode src into a CMAF rendition inside dir.
+// Extra output options, such as the encryption key info, are passed through opts.
+func (p HlsProfile) args(src, dir string, opts ...string) []string {
+	args := []string{
+		"-i", src,
+		"-vn",
+		"-map", "0:a:0",
+		"-c:a", p.Encoder,
+	}
+	if p.Profile != "" {
+		args = append(args, "-profile:a", p.Profile)
+	}
+	if p.Encoder == "libopus" {
+		// opus in mp4 is still flagged experimental on older ffmpeg builds
+		args = append(args, "-strict", "experimental")
+	}
+	args = append(args,
+		"-b:a", fmt.Sprintf("%dk", p.Bitrate),
+		"-ar", strconv.Itoa(int(p.SampleRate)),
+		"-ac", strconv.Itoa(int(p.Channels)),
+	)
+	args = append(args, opts...)
+
+	return append(args, hlsOutputArgs(dir)...)
+}
+
+// hlsOutputArgs returns the ffmpeg muxer arguments writing a CMAF media playlist inside dir.
+func hlsOutputArgs(dir string) []string {
+	return []string{
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_list_size", "0", // If set to 0 the list file will contain all the segments
+		"-hls_segment_type", "fmp4",
+		"-hls_flags", "independent_segments",
+		"-hls_fmp4_init_filename", hlsInitSegment,
+		"-hls_segment_filename", filepath.Join(dir, hlsSegmentPattern),
+		"-y", filepath.Join(dir, hlsMediaPlaylist),
+	}
+}
+
+// HasEncoder reports whether the ffmpeg run by the executor of bs provides the given encoder.
+// The encoders are listed once, at the first call.
+func (bs *BStudio) HasEncoder(name string) bool {
+	bs.encodersOnce.Do(func() {
+		bs.encoders, _ = listEncoders(context.Background(), bs.executor())
+	})
+
+	return bs.encoders[name]
+}
+
+// listEncoders returns the encoders of the local ffmpeg build.
+func listEncoders(ctx context.Context, e *Executor) (map[string]bool, error) {
+	res, err := e.Run(ctx, StageProbe, "ffmpeg", "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, err
+	}
+
+	encoders := make(map[string]bool)
+	// lines look like: " A..... aac                  AAC (Advanced Audio Coding)"
+	scanner := bufio.NewScanner(strings.NewReader(string(res.Stdout)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || len(fields[0]) != 6 {
+			continue
+		}
+		encoders[fields[1]] = true
+	}
+
+	return encoders, scanner.Err()
+}
+
+// hlsVariant is a rendition already written to disk, ready to be listed into the master playlist.
+type hlsVariant struct {
+	name             string
+	codecs           string
+	resolution       string
+	frameRate        float64
+	uri              string
+	bandwidth        uint64
+	averageBandwidth uint64
+}
+
+// measureVariant computes the peak and average bandwidth of a rendition from its media playlist,
+// as required by EXT-X-STREAM-INF.
+func measureVariant(dir, name, codecs string) (*hlsVariant, error) {
+	playlist, err := os.Open(filepath.Join(dir, hlsMediaPlaylist))
+	if err != nil {
+		return nil, err
+	}
+	defer playlist.Close()
+
+	var (
+		peak, totalBits, totalDuration float64
+		duration                       float64
+	)
+
+	scanner := bufio.NewScanner(playlist)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimSuffix(strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0], ",")
+			duration, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EXTINF in %s: %s", name, line)
+			}
+		case line != "" && !strings.HasPrefix(line, "#"):
+			info, err := os.Stat(filepath.Join(dir, line))
+			if err != nil {
+				return nil, err
+			}
+			bits := float64(info.Size() * 8)
+			if duration > 0 {
+				peak = math.Max(peak, bits/duration)
+			}
+			totalBits += bits
+			totalDuration += duration
+			duration = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if totalDuration == 0 {
+		return nil, fmt.Errorf("rendition %s has no segments", name)
+	}
+
+	return &hlsVariant{
+		name:             name,
+		codecs:           codecs,
+		uri:              name + "/" + hlsMediaPlaylist,
+		bandwidth:        uint64(math.Ceil(peak)),
+		averageBandwidth: uint64(math.Ceil(totalBits / totalDuration)),
+	}, nil
+}
+
+// masterPlaylist renders the multivariant playlist that references every rendition.
+func masterPlaylist(variants []*hlsVariant) string {
+	var b strings.Builder
+
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, v := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\"", v.bandwidth, v.averageBandwidth, v.codecs)
+		if v.resolution != "" {
+			fmt.Fprintf(&b, ",RESOLUTION=%s", v.resolution)
+		}
+		if v.frameRate > 0 {
+			fmt.Fprintf(&b, ",FRAME-RATE=%.3f", v.frameRate)
+		}
+		b.WriteString("\n" + v.uri + "\n")
+	}
+
+	return b.String()
+}
+
+func writeMasterPlaylist(dir string, variants []*hlsVariant) error {
+	return ioutil.WriteFile(filepath.Join(dir, hlsMasterPlaylist), []byte(masterPlaylist(variants)), 0644)
+}
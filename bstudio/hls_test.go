@@ -0,0 +1,47 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHls_MasterPlaylist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	playlist := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:6\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXTINF:6.000000,\n" +
+		"segment000.m4s\n" +
+		"#EXTINF:2.000000,\n" +
+		"segment001.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, hlsMediaPlaylist), []byte(playlist), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "segment000.m4s"), make([]byte, 6000), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "segment001.m4s"), make([]byte, 3000), 0644))
+
+	v, err := measureVariant(dir, DefaultHlsProfiles[0].Name, DefaultHlsProfiles[0].Codecs)
+	require.NoError(t, err)
+	require.Equal(t, uint64(12000), v.bandwidth)
+	require.Equal(t, uint64(9000), v.averageBandwidth)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:7\n"+
+		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=12000,AVERAGE-BANDWIDTH=9000,CODECS=\"mp4a.40.2\"\n"+
+		"aac_lc_256/index.m3u8\n", masterPlaylist([]*hlsVariant{v}))
+}
+
+func TestHls_ProfileArgs(t *testing.T) {
+	args := DefaultHlsProfiles[3].args("/tmp/in", "/tmp/out")
+	require.Contains(t, args, "libopus")
+	require.Contains(t, args, "fmp4")
+	require.Contains(t, args, "experimental")
+	require.NotContains(t, args, "-profile:a")
+}
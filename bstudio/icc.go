@@ -0,0 +1,211 @@
+package bstudio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+var errUnsupportedICC = errors.New("unsupported icc profile")
+
+// srgbD50 is the sRGB to PCS matrix of the sRGB ICC profile, adapted to D50 like every ICC colorant.
+var srgbD50 = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// iccProfile is an RGB matrix/TRC profile, the kind of Display P3, Adobe RGB and sRGB.
+// Profiles made of lookup tables are not supported.
+type iccProfile struct {
+	toPCS [3][3]float64
+	trc   [3]func(float64) float64
+}
+
+// parseICC reads the colorants and tone curves of an RGB profile.
+func parseICC(bz []byte) (*iccProfile, error) {
+	if len(bz) < 132 || string(bz[36:40]) != "acsp" {
+		return nil, fmt.Errorf("%w: not an icc profile", errUnsupportedICC)
+	}
+	if string(bz[16:20]) != "RGB " || string(bz[20:24]) != "XYZ " {
+		return nil, fmt.Errorf("%w: %s to %s", errUnsupportedICC, bz[16:20], bz[20:24])
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(bz[128:]))
+	for n := 0; n < count; n++ {
+		entry := 132 + n*12
+		if entry+12 > len(bz) {
+			return nil, fmt.Errorf("%w: truncated tag table", errUnsupportedICC)
+		}
+		offset := int(binary.BigEndian.Uint32(bz[entry+4:]))
+		size := int(binary.BigEndian.Uint32(bz[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(bz) {
+			return nil, fmt.Errorf("%w: tag out of bounds", errUnsupportedICC)
+		}
+		tags[string(bz[entry:entry+4])] = bz[offset : offset+size]
+	}
+
+	p := &iccProfile{}
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := iccXYZ(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		for i := range xyz {
+			p.toPCS[i][c] = xyz[i]
+		}
+	}
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		trc, err := iccCurve(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		p.trc[c] = trc
+	}
+
+	return p, nil
+}
+
+func s15Fixed16(bz []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(bz))) / 65536
+}
+
+func iccXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, fmt.Errorf("%w: missing colorant", errUnsupportedICC)
+	}
+
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+// iccCurve returns the tone curve decoding a channel to linear light, both in [0, 1].
+func iccCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("%w: missing tone curve", errUnsupportedICC)
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, fmt.Errorf("%w: truncated tone curve", errUnsupportedICC)
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			g := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+
+	case "para":
+		kind := binary.BigEndian.Uint16(tag[8:])
+		params := []int{1, 3, 4, 5, 7}
+		if int(kind) >= len(params) || len(tag) < 12+4*params[kind] {
+			return nil, fmt.Errorf("%w: parametric curve %d", errUnsupportedICC, kind)
+		}
+		var v [7]float64
+		for i := 0; i < params[kind]; i++ {
+			v[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := v[0], v[1], v[2], v[3], v[4], v[5], v[6]
+		switch kind {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		if kind == 0 {
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		}
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(math.Max(a*x+b, 0), g) + e
+			}
+			return c*x + f
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: tone curve type %s", errUnsupportedICC, tag[:4])
+}
+
+// invert3 inverts a 3x3 matrix.
+func invert3(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	var inv [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// cofactor of m[j][i]
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			inv[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+
+	return inv
+}
+
+// srgbEncode is the sRGB transfer function, from linear light to [0, 1].
+func srgbEncode(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// toSRGB converts the pixels of img in place from the profile to sRGB.
+func (p *iccProfile) toSRGB(img *image.RGBA) {
+	fromPCS := invert3(srgbD50)
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] = fromPCS[i][0]*p.toPCS[0][j] + fromPCS[i][1]*p.toPCS[1][j] + fromPCS[i][2]*p.toPCS[2][j]
+		}
+	}
+
+	var decode [3][256]float64
+	for c := range decode {
+		for v := range decode[c] {
+			decode[c][v] = p.trc[c](float64(v) / 255)
+		}
+	}
+	const steps = 4096
+	var encode [steps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(srgbEncode(float64(i)/steps) * 255))
+	}
+	quantize := func(x float64) uint8 {
+		return encode[int(math.Round(math.Min(math.Max(x, 0), 1)*steps))]
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for i := 0; i+3 < len(row); i += 4 {
+			r, g, bl := decode[0][row[i]], decode[1][row[i+1]], decode[2][row[i+2]]
+			row[i] = quantize(m[0][0]*r + m[0][1]*g + m[0][2]*bl)
+			row[i+1] = quantize(m[1][0]*r + m[1][1]*g + m[1][2]*bl)
+			row[i+2] = quantize(m[2][0]*r + m[2][1]*g + m[2][2]*bl)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package bstudio
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// matrixICC builds an RGB matrix profile with D50 colorants (columns r, g, b) and a gamma tone curve.
+func matrixICC(colorants [3][3]float64, gamma float64) []byte {
+	fixed := func(v float64) []byte {
+		bz := make([]byte, 4)
+		binary.BigEndian.PutUint32(bz, uint32(int32(v*65536+0.5)))
+		return bz
+	}
+
+	var tags [][]byte
+	for c := 0; c < 3; c++ {
+		tag := append([]byte("XYZ \x00\x00\x00\x00"), fixed(colorants[0][c])...)
+		tag = append(tag, fixed(colorants[1][c])...)
+		tags = append(tags, append(tag, fixed(colorants[2][c])...))
+	}
+	curve := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00")
+	binary.BigEndian.PutUint16(curve[12:], uint16(gamma*256+0.5))
+	tags = append(tags, curve, curve, curve)
+
+	header := make([]byte, 128)
+	copy(header[16:], "RGB XYZ ")
+	copy(header[36:], "acsp")
+	table := make([]byte, 4+12*len(tags))
+	binary.BigEndian.PutUint32(table, uint32(len(tags)))
+
+	bz := append(header, table...)
+	for n, sig := range []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"} {
+		entry := table[4+12*n:]
+		copy(entry, sig)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(bz)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tags[n])))
+		bz = append(bz, tags[n]...)
+	}
+	copy(bz[128:], table)
+
+	return bz
+}
+
+var adobeRGBD50 = [3][3]float64{
+	{0.6097, 0.2053, 0.1492},
+	{0.3111, 0.6257, 0.0632},
+	{0.0195, 0.0609, 0.7446},
+}
+
+func TestICC_AdobeRGBToSRGB(t *testing.T) {
+	p, err := parseICC(matrixICC(adobeRGBD50, 2.2))
+	require.NoError(t, err)
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	img.SetRGBA(1, 0, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+	img.SetRGBA(2, 0, color.RGBA{G: 128, A: 255})
+	img.SetRGBA(3, 0, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+	p.toSRGB(img)
+
+	// expected values from the D65 Adobe RGB and sRGB matrices
+	for x, want := range []color.RGBA{{255, 255, 255, 255}, {129, 129, 129, 255}, {0, 129, 0, 255}, {227, 100, 42, 255}} {
+		got := img.RGBAAt(x, 0)
+		require.InDelta(t, want.R, got.R, 1, "pixel %d", x)
+		require.InDelta(t, want.G, got.G, 1, "pixel %d", x)
+		require.InDelta(t, want.B, got.B, 1, "pixel %d", x)
+		require.Equal(t, uint8(255), got.A)
+	}
+}
+
+func TestICC_Unsupported(t *testing.T) {
+	_, err := parseICC(nil)
+	require.Error(t, err)
+
+	cmyk := matrixICC(adobeRGBD50, 2.2)
+	copy(cmyk[16:], "CMYK")
+	_, err = parseICC(cmyk)
+	require.Error(t, err)
+}
+
+func TestICC_ParametricCurve(t *testing.T) {
+	// the sRGB curve, type 3
+	tag := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		bz := make([]byte, 4)
+		binary.BigEndian.PutUint32(bz, uint32(int32(v*65536+0.5)))
+		tag = append(tag, bz...)
+	}
+	curve, err := iccCurve(tag)
+	require.NoError(t, err)
+	for _, x := range []float64{0, 0.02, 0.5, 1} {
+		require.InDelta(t, x, srgbEncode(curve(x)), 0.001)
+	}
+}
@@ -1,22 +1,187 @@
 package bstudio
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	uuid2 "github.com/google/uuid"
 	"github.com/nfnt/resize"
+	"golang.org/x/image/webp"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
 	"image/jpeg"
+	"image/png"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+
+	defaultImageQuality = 85
+	originalFileName    = "original.png"
+
+	// maxImageHeaderSize bounds what is read to find the dimensions, metadata blocks included
+	maxImageHeaderSize = 1 << 20
+)
+
+var imageExtensions = map[string]string{
+	FormatJPEG: "jpg",
+	FormatPNG:  "png",
+	FormatWebP: "webp",
+}
+
+// ImageSize is a bounding box; images are scaled to fit in it keeping their aspect ratio.
+type ImageSize struct {
+	Name   string `json:"name" yaml:"name"`
+	Width  uint   `json:"width" yaml:"width"`
+	Height uint   `json:"height" yaml:"height"`
+}
+
+// ImagePreset is a named group of sizes rendered in every configured format.
+type ImagePreset struct {
+	Name    string       `json:"name" yaml:"name"`
+	Sizes   []ImageSize  `json:"sizes" yaml:"sizes"`
+	Formats []string     `json:"formats" yaml:"formats"`
+	Quality int          `json:"quality" yaml:"quality"` // jpeg and webp quality, 1-100
+	Policy  *ImagePolicy `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Crop    string       `json:"crop" yaml:"crop"` // crop to the policy aspect ratio: center, saliency or empty
+}
+
+// DefaultImagePresets are the renditions available to the image upload.
+var DefaultImagePresets = []ImagePreset{
+	{
+		Name: "cover",
+		Sizes: []ImageSize{
+			{Name: "3000", Width: 3000, Height: 3000},
+			{Name: "1400", Width: 1400, Height: 1400},
+			{Name: "640", Width: 640, Height: 640},
+			{Name: "300", Width: 300, Height: 300},
+		},
+		Formats: []string{FormatJPEG, FormatWebP},
+		Quality: 90,
+		Policy:  DefaultCoverPolicy,
+	},
+	{
+		Name: "avatar",
+		Sizes: []ImageSize{
+			{Name: "400", Width: 400, Height: 400},
+			{Name: "200", Width: 200, Height: 200},
+			{Name: "64", Width: 64, Height: 64},
+		},
+		Formats: []string{FormatJPEG, FormatWebP},
+		Quality: defaultImageQuality,
+	},
+	{
+		Name: "banner",
+		Sizes: []ImageSize{
+			{Name: "2560", Width: 2560, Height: 1440},
+			{Name: "1280", Width: 1280, Height: 720},
+		},
+		Formats: []string{FormatJPEG, FormatWebP},
+		Quality: defaultImageQuality,
+	},
+}
+
+// ImageRendition is a single file written by Img.Render.
+type ImageRendition struct {
+	Preset   string `json:"preset"`
+	Size     string `json:"size"`
+	Format   string `json:"format"`
+	FileName string `json:"filename"`
+	Cid      string `json:"cid"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Bytes    int64  `json:"bytes"`
+}
+
+var (
+	// ErrUnsupportedImage is returned when the uploaded bytes are not a supported image format.
+	ErrUnsupportedImage = fmt.Errorf("unsupported image format")
+
+	// ErrImageTooLarge is returned before decoding an image of more than the allowed pixels.
+	ErrImageTooLarge = fmt.Errorf("image too large")
+)
+
+// DefaultMaxImagePixels bounds the decoded images, 200MB in RGBA.
+const DefaultMaxImagePixels = 50000000
+
+// imageDecoders maps the sniffed content type to its decoder.
+var imageDecoders = map[string]func(io.Reader) (image.Image, error){
+	"image/jpeg": jpeg.Decode,
+	"image/png":  png.Decode,
+	"image/gif":  gif.Decode, // first frame only
+	"image/webp": webp.Decode,
+}
+
+// DefaultImageBackground is used to flatten transparent images.
+var DefaultImageBackground = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
 type Img struct {
-	img     image.Image
-	tmpPath string
+	img         *image.RGBA
+	tmpPath     string
+	contentType string
+	metadata    *ImageMetadata
+
+	// executor encodes webp, execCPU sums its cpu time
+	executor *Executor
+	execCPU  time.Duration
 }
 
-func NewImage(r io.Reader) (*Img, error) {
-	img, _, err := image.Decode(r)
+// NewImage decodes r with the image settings of bs, its webp renditions go through the executor of bs.
+func (bs *BStudio) NewImage(r io.Reader) (*Img, error) {
+	img, err := NewImage(r, bs.ImageBackground, bs.ImageMaxPixels)
+	if err != nil {
+		return nil, err
+	}
+	img.executor = bs.executor()
+
+	return img, nil
+}
+
+// NewImage decodes r according to the format sniffed from its bytes, whatever the declared content type.
+// The dimensions are read from the header first, the rest of an image of more than maxPixels is never read.
+// Transparent and palette images are flattened on background into an opaque RGBA image,
+// converted to sRGB from their ICC profile, then rotated upright according to the EXIF orientation.
+func NewImage(r io.Reader, background color.Color, maxPixels int) (*Img, error) {
+	// the bytes read for the header are kept to decode the whole image afterwards
+	var head bytes.Buffer
+	header := io.TeeReader(io.LimitReader(r, maxImageHeaderSize), &head)
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(header, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	contentType := http.DetectContentType(sniff[:n])
+	decode, ok := imageDecoders[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedImage, contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(sniff[:n]), header))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxPixels/cfg.Height {
+		return nil, fmt.Errorf("%w: %dx%d is above %d pixels", ErrImageTooLarge, cfg.Width, cfg.Height, maxPixels)
+	}
+
+	bz, err := ioutil.ReadAll(io.MultiReader(&head, r))
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decode(bytes.NewReader(bz))
 	if err != nil {
 		return nil, err
 	}
@@ -26,30 +191,263 @@ func NewImage(r io.Reader) (*Img, error) {
 		return nil, err
 	}
 
+	meta := readImageMetadata(contentType, bz)
+	rgba := flatten(img, background)
+	if profile, err := parseICC(meta.icc); err == nil {
+		profile.toSRGB(rgba)
+		meta.ColorConverted = true
+	}
+
 	return &Img{
-		img:     img,
-		tmpPath: fmt.Sprintf("/tmp/%s", uuid.String()),
+		img:         orient(rgba, meta.Orientation),
+		tmpPath:     filepath.Join(TmpDir, uuid.String()),
+		contentType: contentType,
+		metadata:    meta,
+		executor:    defaultExecutor,
 	}, nil
 }
 
-func (i *Img) Resize() error {
-	tmp, err := os.Create(i.tmpPath)
+// flatten composites img over an opaque background, converting palette and
+// gray images to RGBA so that every encoder and the resizer get the same input.
+func flatten(img image.Image, background color.Color) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Opaque() {
+		return rgba
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+
+	return dst
+}
+
+// ParseHexColor parses #rrggbb and #rgb colors.
+func ParseHexColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 255}
+	s = strings.TrimPrefix(s, "#")
+
+	var err error
+	switch len(s) {
+	case 6:
+		_, err = fmt.Sscanf(s, "%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 3:
+		_, err = fmt.Sscanf(s, "%1x%1x%1x", &c.R, &c.G, &c.B)
+		c.R *= 17
+		c.G *= 17
+		c.B *= 17
+	default:
+		err = fmt.Errorf("invalid color #%s", s)
+	}
+
+	return c, err
+}
+
+func (i *Img) GetContentType() string {
+	return i.contentType
+}
+
+// Placeholder computes the BlurHash and palette of the upright image.
+func (i *Img) Placeholder() *ImagePlaceholder {
+	return NewImagePlaceholder(i.img)
+}
+
+// PerceptualHash returns the hex encoded dHash of the upright image.
+func (i *Img) PerceptualHash() string {
+	return formatHash(dHash(i.img))
+}
+
+// ExecCPU returns the cpu time of the ffmpeg processes the renditions went through.
+func (i *Img) ExecCPU() time.Duration {
+	return i.execCPU
+}
+
+// GetMetadata returns the orientation applied and the metadata stripped from the original.
+func (i *Img) GetMetadata() *ImageMetadata {
+	return i.metadata
+}
+
+// Render writes every size of the presets, in every format, into the tmp directory.
+func (i *Img) Render(presets []ImagePreset) ([]*ImageRendition, error) {
+	if err := os.MkdirAll(i.tmpPath, 0755); err != nil {
+		return nil, err
+	}
+
+	var renditions []*ImageRendition
+	for _, preset := range presets {
+		quality := preset.Quality
+		if quality <= 0 || quality > 100 {
+			quality = defaultImageQuality
+		}
+
+		src, err := i.presetSource(preset)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, size := range preset.Sizes {
+			resized := resize.Thumbnail(size.Width, size.Height, src, resize.Lanczos3)
+			bounds := resized.Bounds()
+
+			for _, format := range preset.Formats {
+				ext, ok := imageExtensions[format]
+				if !ok {
+					return nil, fmt.Errorf("unsupported image format %s in preset %s", format, preset.Name)
+				}
+
+				name := fmt.Sprintf("%s-%s.%s", preset.Name, size.Name, ext)
+				path := filepath.Join(i.tmpPath, name)
+				cpu, err := encodeImage(i.executor, path, resized, format, quality)
+				i.execCPU += cpu
+				if err != nil {
+					return nil, err
+				}
+
+				info, err := os.Stat(path)
+				if err != nil {
+					return nil, err
+				}
+
+				renditions = append(renditions, &ImageRendition{
+					Preset:   preset.Name,
+					Size:     size.Name,
+					Format:   format,
+					FileName: name,
+					Width:    bounds.Dx(),
+					Height:   bounds.Dy(),
+					Bytes:    info.Size(),
+				})
+			}
+		}
+	}
+
+	return renditions, nil
+}
+
+// presetSource returns the image cropped as requested by the preset.
+func (i *Img) presetSource(preset ImagePreset) (image.Image, error) {
+	if preset.Crop == CropNone {
+		return i.img, nil
+	}
+	if preset.Policy == nil || preset.Policy.Aspect <= 0 {
+		return nil, fmt.Errorf("preset %s has no aspect ratio to crop to", preset.Name)
+	}
+
+	rect, err := cropRect(i.img, preset.Policy.Aspect, preset.Crop)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer tmp.Close()
 
-	size := resize.Thumbnail(500, 500, i.img, resize.Lanczos3)
-	err = jpeg.Encode(tmp, size, nil)
+	return i.img.SubImage(rect), nil
+}
+
+// Validate checks the image, once cropped, against the preset policy.
+// size is the byte size of the uploaded file.
+func (i *Img) Validate(preset ImagePreset, size int64) error {
+	if preset.Policy == nil {
+		return nil
+	}
+
+	src, err := i.presetSource(preset)
 	if err != nil {
 		return err
 	}
+	bounds := src.Bounds()
+
+	return preset.Policy.Validate(bounds.Dx(), bounds.Dy(), size, preset.Crop != CropNone)
+}
+
+// WriteOriginal stores the upright, metadata free pixels losslessly next to the renditions,
+// as the source of the sizes rendered on the fly. The full resolution is then public, it is only
+// written when the studio keeps the originals.
+func (i *Img) WriteOriginal() (string, error) {
+	if err := os.MkdirAll(i.tmpPath, 0755); err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(filepath.Join(i.tmpPath, originalFileName))
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	return originalFileName, png.Encode(out, i.img)
+}
+
+// encodeImage writes img to path in format, it returns the cpu time of ffmpeg when it went through it.
+func encodeImage(e *Executor, path string, img image.Image, format string, quality int) (time.Duration, error) {
+	if format == FormatWebP {
+		return encodeWebP(e, path, img, quality)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	switch format {
+	case FormatPNG:
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		return 0, enc.Encode(out, img)
+	default:
+		return 0, jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+	}
+}
+
+// encodeWebP goes through ffmpeg, there is no webp encoder in the go standard library.
+func encodeWebP(e *Executor, path string, img image.Image, quality int) (time.Duration, error) {
+	tmp, err := ioutil.TempFile("", "bstudio-*.png")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = png.Encode(tmp, img)
+	tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := e.Run(context.Background(), StageImage, "ffmpeg",
+		"-i", tmp.Name(),
+		"-c:v", "libwebp",
+		"-quality", fmt.Sprintf("%d", quality),
+		"-y", path,
+	)
+	if eerr, ok := err.(*ExecError); ok {
+		return eerr.CPU, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return res.CPU, nil
+}
+
+// FindImagePresets returns the presets matching names, in order.
+func FindImagePresets(presets []ImagePreset, names []string) ([]ImagePreset, error) {
+	var found []ImagePreset
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		var ok bool
+		for _, p := range presets {
+			if p.Name == name {
+				found = append(found, p)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown image preset %s", name)
+		}
+	}
 
-	return nil
+	return found, nil
 }
 
 func (i *Img) Delete() error {
-	return os.Remove(i.tmpPath)
+	return os.RemoveAll(i.tmpPath)
 }
 
 func (i *Img) GetTmpPath() string {
@@ -0,0 +1,137 @@
+package bstudio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func mockImage(t *testing.T, width, height int) *Img {
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, src, nil))
+
+	img, err := NewImage(&buf, DefaultImageBackground, DefaultMaxImagePixels)
+	require.NoError(t, err)
+
+	return img
+}
+
+func TestImage_Render(t *testing.T) {
+	img := mockImage(t, 800, 400)
+	defer img.Delete()
+
+	presets := []ImagePreset{{
+		Name: "cover",
+		Sizes: []ImageSize{
+			{Name: "1400", Width: 1400, Height: 1400},
+			{Name: "300", Width: 300, Height: 300},
+		},
+		Formats: []string{FormatJPEG, FormatPNG},
+	}}
+
+	renditions, err := img.Render(presets)
+	require.NoError(t, err)
+	require.Len(t, renditions, 4)
+
+	// images are never upscaled
+	require.Equal(t, "cover-1400.jpg", renditions[0].FileName)
+	require.Equal(t, 800, renditions[0].Width)
+	require.Equal(t, 400, renditions[0].Height)
+
+	require.Equal(t, "cover-300.png", renditions[3].FileName)
+	require.Equal(t, 300, renditions[3].Width)
+	require.Equal(t, 150, renditions[3].Height)
+	require.True(t, renditions[3].Bytes > 0)
+}
+
+func TestImage_FindPresets(t *testing.T) {
+	presets, err := FindImagePresets(DefaultImagePresets, []string{"avatar", " banner"})
+	require.NoError(t, err)
+	require.Len(t, presets, 2)
+	require.Equal(t, "banner", presets[1].Name)
+
+	_, err = FindImagePresets(DefaultImagePresets, []string{"poster"})
+	require.Error(t, err)
+}
+
+func TestImage_DecodeFormats(t *testing.T) {
+	// transparent png is flattened on the background
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	src.Set(1, 1, color.NRGBA{R: 255, A: 255})
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	img, err := NewImage(&buf, color.RGBA{G: 255, A: 255}, DefaultMaxImagePixels)
+	require.NoError(t, err)
+	require.Equal(t, "image/png", img.GetContentType())
+	require.Equal(t, color.RGBA{G: 255, A: 255}, img.img.At(0, 0))
+	require.Equal(t, color.RGBA{R: 255, A: 255}, img.img.At(1, 1))
+
+	// palette gif is converted to rgba
+	pal := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	buf.Reset()
+	require.NoError(t, gif.Encode(&buf, pal, nil))
+
+	img, err = NewImage(&buf, DefaultImageBackground, DefaultMaxImagePixels)
+	require.NoError(t, err)
+	require.Equal(t, "image/gif", img.GetContentType())
+	require.IsType(t, &image.RGBA{}, img.img)
+
+	_, err = NewImage(bytes.NewBufferString("<svg></svg>"), DefaultImageBackground, DefaultMaxImagePixels)
+	require.True(t, errors.Is(err, ErrUnsupportedImage))
+}
+
+func TestImage_MaxPixels(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 60))))
+	bz := buf.Bytes()
+
+	_, err := NewImage(bytes.NewReader(bz), DefaultImageBackground, 6000)
+	require.NoError(t, err)
+
+	_, err = NewImage(bytes.NewReader(bz), DefaultImageBackground, 5999)
+	require.True(t, errors.Is(err, ErrImageTooLarge))
+
+	// a header claiming 100000x100000 pixels is rejected without decoding
+	binary.BigEndian.PutUint32(bz[16:], 100000)
+	binary.BigEndian.PutUint32(bz[20:], 100000)
+	binary.BigEndian.PutUint32(bz[29:], crc32.ChecksumIEEE(bz[12:29]))
+	_, err = NewImage(bytes.NewReader(bz), DefaultImageBackground, DefaultMaxImagePixels)
+	require.True(t, errors.Is(err, ErrImageTooLarge))
+
+	// nor reading the rest of the upload
+	rest := bytes.NewReader(make([]byte, 4*maxImageHeaderSize))
+	_, err = NewImage(io.MultiReader(bytes.NewReader(bz), rest), DefaultImageBackground, DefaultMaxImagePixels)
+	require.True(t, errors.Is(err, ErrImageTooLarge))
+	require.True(t, rest.Len() > 3*maxImageHeaderSize)
+}
+
+func TestImage_ParseHexColor(t *testing.T) {
+	c, err := ParseHexColor("#1a2b3c")
+	require.NoError(t, err)
+	require.Equal(t, color.RGBA{R: 0x1a, G: 0x2b, B: 0x3c, A: 255}, c)
+
+	c, err = ParseHexColor("fff")
+	require.NoError(t, err)
+	require.Equal(t, DefaultImageBackground, c)
+
+	_, err = ParseHexColor("#12")
+	require.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package bstudio
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	imageInfoPrefix  = "image/"
+	imageAliasPrefix = "imagealias/"
+)
+
+// ImageInfo is the record kept for every processed image, keyed by its directory cid.
+type ImageInfo struct {
+	Cid         string            `json:"cid"`
+	FileName    string            `json:"filename"`
+	OriginalCid string            `json:"original_cid,omitempty"`
+	Renditions  []*ImageRendition `json:"renditions"`
+	Metadata    *ImageMetadata    `json:"metadata"`
+	Placeholder *ImagePlaceholder `json:"placeholder"`
+	PHash       string            `json:"phash"`
+	DuplicateOf *ImageDuplicate   `json:"duplicate_of,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	APIKeyID    string            `json:"api_key_id,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// SourceCid returns the cid to render new sizes from: the metadata free original,
+// or the largest rendition for images processed before originals were kept.
+func (info *ImageInfo) SourceCid() string {
+	if info.OriginalCid != "" {
+		return info.OriginalCid
+	}
+
+	var best *ImageRendition
+	for _, r := range info.Renditions {
+		if best == nil || r.Width*r.Height > best.Width*best.Height {
+			best = r
+		}
+	}
+	if best == nil {
+		return ""
+	}
+
+	return best.Cid
+}
+
+// HasPresets reports whether the renditions of every preset are available.
+func (info *ImageInfo) HasPresets(presets []ImagePreset) bool {
+	for _, p := range presets {
+		var found bool
+		for _, r := range info.Renditions {
+			if r.Preset == p.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SaveImageInfo stores info and makes it reachable from the cid of every rendition too.
+func (bs *BStudio) SaveImageInfo(info *ImageInfo) error {
+	bz, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	if err := bs.Ds.SetAndCommit([]byte(imageInfoPrefix+info.Cid), bz); err != nil {
+		return err
+	}
+
+	for _, r := range info.Renditions {
+		if r.Cid == "" {
+			continue
+		}
+		if err := bs.Ds.SetAndCommit([]byte(imageAliasPrefix+r.Cid), []byte(info.Cid)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetImageInfo returns the image record by directory or rendition cid, nil if unknown.
+func (bs *BStudio) GetImageInfo(cid string) (*ImageInfo, error) {
+	dirCid, err := bs.Ds.Get([]byte(imageAliasPrefix + cid))
+	if err != nil {
+		return nil, err
+	}
+	if len(dirCid) > 0 {
+		cid = string(dirCid)
+	}
+
+	bz, err := bs.Ds.Get([]byte(imageInfoPrefix + cid))
+	if err != nil {
+		return nil, err
+	}
+	if len(bz) == 0 {
+		return nil, nil
+	}
+
+	var info ImageInfo
+	if err := json.Unmarshal(bz, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
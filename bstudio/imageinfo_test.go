@@ -0,0 +1,32 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestImageInfo_SaveAndGet(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	info := &ImageInfo{
+		Cid:         "QmDir",
+		Renditions:  []*ImageRendition{{FileName: "cover-300.jpg", Cid: "QmCover300"}},
+		Placeholder: &ImagePlaceholder{BlurHash: "L9TSUA~q", DominantColor: "#ffffff"},
+	}
+	require.NoError(t, bs.SaveImageInfo(info))
+
+	got, err := bs.GetImageInfo("QmDir")
+	require.NoError(t, err)
+	require.Equal(t, "#ffffff", got.Placeholder.DominantColor)
+
+	// renditions resolve to the same record
+	got, err = bs.GetImageInfo("QmCover300")
+	require.NoError(t, err)
+	require.Equal(t, "QmDir", got.Cid)
+
+	got, err = bs.GetImageInfo("QmUnknown")
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
@@ -0,0 +1,155 @@
+package bstudio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+)
+
+// DefaultImageSizes is the allowlist of widths and heights served on the fly,
+// so that the cache cannot be flooded with arbitrary dimensions.
+var DefaultImageSizes = []uint{32, 64, 100, 150, 200, 300, 400, 500, 640, 800, 1000, 1200, 1400, 1600, 2000, 3000}
+
+var imageContentTypes = map[string]string{
+	FormatJPEG: "image/jpeg",
+	FormatPNG:  "image/png",
+	FormatWebP: "image/webp",
+}
+
+// ImageVariant is an on the fly rendition requested through query parameters.
+type ImageVariant struct {
+	Width   uint
+	Height  uint
+	Fit     string
+	Format  string
+	Quality int
+}
+
+// IsImageVariantQuery reports whether the query asks for a rendition rather than the image info.
+func IsImageVariantQuery(q url.Values) bool {
+	for _, k := range []string{"w", "h", "fit", "format", "q"} {
+		if _, ok := q[k]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseImageVariant reads w, h, fit, format and q, only accepting the allowed dimensions.
+func ParseImageVariant(q url.Values, allowed []uint) (*ImageVariant, error) {
+	var errs ValidationErrors
+	v := &ImageVariant{
+		Fit:     FitContain,
+		Format:  FormatJPEG,
+		Quality: defaultImageQuality,
+	}
+
+	parseSize := func(field string) uint {
+		s := q.Get(field)
+		if s == "" {
+			return 0
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			errs.Add(field, "invalid", "%s must be a positive integer", field)
+			return 0
+		}
+		for _, a := range allowed {
+			if uint(n) == a {
+				return uint(n)
+			}
+		}
+		errs.Add(field, "not_allowed", "%d is not an allowed dimension, allowed: %v", n, allowed)
+		return 0
+	}
+	v.Width = parseSize("w")
+	v.Height = parseSize("h")
+
+	if s := q.Get("fit"); s != "" {
+		v.Fit = s
+	}
+	switch v.Fit {
+	case FitContain:
+		if q.Get("w") == "" && q.Get("h") == "" {
+			errs.Add("w", "required", "w or h is required")
+		}
+	case FitCover, FitFill:
+		if q.Get("w") == "" || q.Get("h") == "" {
+			errs.Add("fit", "required", "fit %s requires both w and h", v.Fit)
+		}
+	default:
+		errs.Add("fit", "invalid", "fit must be contain, cover or fill")
+	}
+
+	if s := q.Get("format"); s != "" {
+		v.Format = s
+	}
+	if _, ok := imageContentTypes[v.Format]; !ok {
+		errs.Add("format", "invalid", "format must be jpeg, png or webp")
+	}
+
+	if s := q.Get("q"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			errs.Add("q", "invalid", "q must be between 1 and 100")
+		}
+		v.Quality = n
+	}
+
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Key identifies the rendition of the source cid, it is used as cache file name and ETag.
+func (v *ImageVariant) Key(cid string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%s|%s|%d", cid, v.Width, v.Height, v.Fit, v.Format, v.Quality)))
+	return hex.EncodeToString(sum[:16]) + "." + imageExtensions[v.Format]
+}
+
+func (v *ImageVariant) ContentType() string {
+	return imageContentTypes[v.Format]
+}
+
+// RenderVariant writes the requested rendition to path.
+func (i *Img) RenderVariant(v *ImageVariant, path string) error {
+	var out image.Image
+
+	switch v.Fit {
+	case FitCover:
+		rect, err := cropRect(i.img, float64(v.Width)/float64(v.Height), CropCenter)
+		if err != nil {
+			return err
+		}
+		out = resize.Resize(v.Width, v.Height, i.img.SubImage(rect), resize.Lanczos3)
+	case FitFill:
+		out = resize.Resize(v.Width, v.Height, i.img, resize.Lanczos3)
+	default:
+		w, h := v.Width, v.Height
+		if w == 0 {
+			w = math.MaxUint32
+		}
+		if h == 0 {
+			h = math.MaxUint32
+		}
+		out = resize.Thumbnail(w, h, i.img, resize.Lanczos3)
+	}
+
+	cpu, err := encodeImage(i.executor, path, out, v.Format, v.Quality)
+	i.execCPU += cpu
+	return err
+}
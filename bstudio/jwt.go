@@ -0,0 +1,84 @@
+package bstudio
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const jwtIssuer = "bstudio"
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// jwtHeader is the only header issued and accepted, no other algorithm is allowed.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// JWTClaims are the claims of a session token, the subject is a bech32 address.
+type JWTClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Scope     string `json:"scope"` // space separated scopes
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c *JWTClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// SignJWT returns the HS256 token of claims.
+func SignJWT(secret []byte, claims *JWTClaims) (string, error) {
+	bz, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(bz)
+
+	return unsigned + "." + jwtSignature(secret, unsigned), nil
+}
+
+func jwtSignature(secret []byte, unsigned string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParseJWT checks the signature and the expiry of token and returns its claims.
+func ParseJWT(secret []byte, token string, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := jwtSignature(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, ErrInvalidToken
+	}
+
+	bz, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims JWTClaims
+	if err := json.Unmarshal(bz, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != jwtIssuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
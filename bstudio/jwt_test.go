@@ -0,0 +1,50 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWT_SignAndParse(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	claims := &JWTClaims{
+		Issuer:    jwtIssuer,
+		Subject:   "bitsong1address",
+		Scope:     "upload:audio jobs:read",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+	}
+
+	token, err := SignJWT(secret, claims)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(token, "."), 3)
+
+	got, err := ParseJWT(secret, token, now)
+	require.NoError(t, err)
+	require.Equal(t, claims, got)
+	require.Equal(t, []string{"upload:audio", "jobs:read"}, got.Scopes())
+
+	_, err = ParseJWT(secret, token, now.Add(time.Minute))
+	require.Equal(t, ErrExpiredToken, err)
+
+	_, err = ParseJWT([]byte("other"), token, now)
+	require.Equal(t, ErrInvalidToken, err)
+}
+
+func TestJWT_RejectsOtherAlgorithms(t *testing.T) {
+	secret := []byte("secret")
+	token, err := SignJWT(secret, &JWTClaims{Issuer: jwtIssuer, Subject: "sub", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	// {"alg":"none","typ":"JWT"}
+	none := "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + "."
+	_, err = ParseJWT(secret, none, time.Now())
+	require.Equal(t, ErrInvalidToken, err)
+
+	_, err = ParseJWT(secret, parts[0]+"."+parts[1]+".tampered", time.Now())
+	require.Equal(t, ErrInvalidToken, err)
+}
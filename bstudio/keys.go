@@ -0,0 +1,215 @@
+package bstudio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	contentKeyPrefix = "contentkey/"
+	contentKeySize   = 16 // AES-128
+	masterKeySize    = 32 // AES-256-GCM key wrapping
+)
+
+var (
+	ErrKeyNotFound   = fmt.Errorf("content key not found")
+	ErrNotEntitled   = fmt.Errorf("not entitled to this content key")
+	ErrNoEntitlement = fmt.Errorf("no entitlement hook configured")
+)
+
+// ContentKey is the per-track AES-128 key used to encrypt HLS segments.
+// The key material is stored wrapped with the instance master key.
+type ContentKey struct {
+	ID         string    `json:"id"`
+	Cid        string    `json:"cid"`
+	IV         string    `json:"iv"`
+	WrappedKey []byte    `json:"wrapped_key"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// KeyStore generates content keys and persists them encrypted into the datastore.
+type KeyStore struct {
+	ds   *Ds
+	aead cipher.AEAD
+}
+
+func NewKeyStore(ds *Ds, masterKey []byte) (*KeyStore, error) {
+	if len(masterKey) != masterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(masterKey))
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyStore{ds: ds, aead: aead}, nil
+}
+
+// LoadOrCreateMasterKey reads the hex encoded master key at path, creating a random one if missing.
+func LoadOrCreateMasterKey(path string) ([]byte, error) {
+	bz, err := ioutil.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(string(bz))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Generate creates and stores a new content key for the track cid, returning the plain key.
+func (ks *KeyStore) Generate(cid string) (*ContentKey, []byte, error) {
+	uid, err := uuid2.NewRandom()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := make([]byte, contentKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, ks.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	ck := &ContentKey{
+		ID:  uid.String(),
+		Cid: cid,
+		IV:  hex.EncodeToString(iv),
+		// the key id is authenticated so a wrapped key cannot be swapped between records
+		WrappedKey: ks.aead.Seal(nonce, nonce, key, []byte(uid.String())),
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	bz, err := json.Marshal(ck)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ks.ds.SetAndCommit([]byte(contentKeyPrefix+ck.ID), bz); err != nil {
+		return nil, nil, err
+	}
+
+	return ck, key, nil
+}
+
+// Get returns the content key record by id.
+func (ks *KeyStore) Get(id string) (*ContentKey, error) {
+	bz, err := ks.ds.Get([]byte(contentKeyPrefix + id))
+	if err != nil {
+		return nil, err
+	}
+	if len(bz) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	var ck ContentKey
+	if err := json.Unmarshal(bz, &ck); err != nil {
+		return nil, err
+	}
+
+	return &ck, nil
+}
+
+// Unwrap decrypts the key material of a content key.
+func (ks *KeyStore) Unwrap(ck *ContentKey) ([]byte, error) {
+	n := ks.aead.NonceSize()
+	if len(ck.WrappedKey) < n {
+		return nil, fmt.Errorf("malformed wrapped key %s", ck.ID)
+	}
+
+	return ks.aead.Open(nil, ck.WrappedKey[:n], ck.WrappedKey[n:], []byte(ck.ID))
+}
+
+// EntitlementSubject is who asks for a content key, as told to the entitlement hook.
+// The bstudio credentials of the caller are never part of it.
+type EntitlementSubject struct {
+	Token  string // X-Entitlement-Token of the player, issued by the entitlement service
+	Client string // client id of the caller when it authenticated to bstudio
+}
+
+// Entitlement decides whether the subject may receive a content key.
+// It must return ErrNotEntitled when the access is denied.
+type Entitlement func(s EntitlementSubject, ck *ContentKey) error
+
+// DenyAllEntitlement is used when no entitlement hook is configured.
+func DenyAllEntitlement(s EntitlementSubject, ck *ContentKey) error {
+	return ErrNoEntitlement
+}
+
+// NewWebhookEntitlement delegates the entitlement check to an external service.
+// The service receives the track cid, the key id and the client id of the caller as query parameters,
+// the entitlement token as a bearer Authorization header, and must answer 200 to grant access.
+func NewWebhookEntitlement(endpoint string) Entitlement {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	return func(s EntitlementSubject, ck *ContentKey) error {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		q.Set("cid", ck.Cid)
+		q.Set("key_id", ck.ID)
+		if s.Client != "" {
+			q.Set("client", s.Client)
+		}
+		u.RawQuery = q.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		if s.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.Token)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		switch res.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return ErrNotEntitled
+		default:
+			return fmt.Errorf("entitlement service replied %d", res.StatusCode)
+		}
+	}
+}
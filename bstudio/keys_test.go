@@ -0,0 +1,85 @@
+package bstudio
+
+import (
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mockDs(t *testing.T) (*Ds, func()) {
+	dir, err := ioutil.TempDir("", "bstudio-ds")
+	require.NoError(t, err)
+
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	require.NoError(t, err)
+
+	return &Ds{Db: db}, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestKeyStore_GenerateAndUnwrap(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "bstudio-key")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	masterKey, err := LoadOrCreateMasterKey(filepath.Join(dir, "master.key"))
+	require.NoError(t, err)
+	reloaded, err := LoadOrCreateMasterKey(filepath.Join(dir, "master.key"))
+	require.NoError(t, err)
+	require.Equal(t, masterKey, reloaded)
+
+	ks, err := NewKeyStore(ds, masterKey)
+	require.NoError(t, err)
+
+	ck, key, err := ks.Generate("QmZWCE29y6omGw8vuiQQpMKehfrhggxytjCd9McxRomsLt")
+	require.NoError(t, err)
+	require.Len(t, key, contentKeySize)
+	require.NotContains(t, string(ck.WrappedKey), string(key))
+
+	stored, err := ks.Get(ck.ID)
+	require.NoError(t, err)
+	unwrapped, err := ks.Unwrap(stored)
+	require.NoError(t, err)
+	require.Equal(t, key, unwrapped)
+
+	// a wrapped key moved to another record must not decrypt
+	stored.ID = "other"
+	_, err = ks.Unwrap(stored)
+	require.Error(t, err)
+
+	_, err = ks.Get("missing")
+	require.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestWebhookEntitlement(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if r.Header.Get("Authorization") != "Bearer player-token" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	check := NewWebhookEntitlement(srv.URL + "/check")
+	ck := &ContentKey{ID: "key1", Cid: "QmTrack"}
+
+	require.NoError(t, check(EntitlementSubject{Token: "player-token", Client: "address:bitsong1abc"}, ck))
+	require.Equal(t, "QmTrack", got.URL.Query().Get("cid"))
+	require.Equal(t, "key1", got.URL.Query().Get("key_id"))
+	require.Equal(t, "address:bitsong1abc", got.URL.Query().Get("client"))
+
+	// only the client id is told when the caller has no entitlement token
+	require.Equal(t, ErrNotEntitled, check(EntitlementSubject{Client: "key:k1"}, ck))
+	require.Empty(t, got.Header.Get("Authorization"))
+}
@@ -0,0 +1,269 @@
+package bstudio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	SchemaTrackV1   = "track/v1"
+	SchemaReleaseV1 = "release/v1"
+
+	// MaxManifestSize is the largest manifest document accepted, in bytes.
+	MaxManifestSize = 1 << 20
+)
+
+var ErrManifestTooLarge = fmt.Errorf("the manifest is larger than %d bytes", MaxManifestSize)
+
+// manifestSchemaDocs are the published JSON Schemas, a manifest names the one it follows in its schema field.
+// A released version is never changed, incompatible changes get a new version.
+var manifestSchemaDocs = map[string]string{
+	SchemaTrackV1: `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "track/v1",
+  "title": "BitSong track manifest",
+  "type": "object",
+  "required": ["schema", "title", "artists", "audio", "hls", "cover", "genre", "splits", "license"],
+  "additionalProperties": false,
+  "properties": {
+    "schema": {"const": "track/v1"},
+    "title": {"type": "string", "minLength": 1, "maxLength": 256},
+    "artists": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/artist"}},
+    "audio": {"$ref": "#/definitions/cid"},
+    "hls": {"$ref": "#/definitions/cid"},
+    "cover": {"$ref": "#/definitions/cid"},
+    "genre": {"type": "string", "minLength": 1, "maxLength": 64},
+    "isrc": {"type": "string", "pattern": "^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$"},
+    "splits": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/split"}},
+    "license": {"type": "string", "minLength": 1, "maxLength": 128}
+  },
+  "definitions": {
+    "cid": {"type": "string", "pattern": "^(Qm[1-9A-HJ-NP-Za-km-z]{44}|b[a-z2-7]{58,})$"},
+    "address": {"type": "string", "pattern": "^bitsong1[02-9ac-hj-np-z]{38}$"},
+    "artist": {
+      "type": "object",
+      "required": ["name"],
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string", "minLength": 1, "maxLength": 128},
+        "address": {"$ref": "#/definitions/address"},
+        "role": {"enum": ["main", "featured", "producer", "composer", "remixer"]}
+      }
+    },
+    "split": {
+      "type": "object",
+      "required": ["address", "share"],
+      "additionalProperties": false,
+      "properties": {
+        "address": {"$ref": "#/definitions/address"},
+        "share": {"type": "number", "minimum": 0, "maximum": 100}
+      }
+    }
+  }
+}`,
+	SchemaReleaseV1: `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "release/v1",
+  "title": "BitSong release manifest",
+  "type": "object",
+  "required": ["schema", "title", "artists", "cover", "genre", "license", "tracks"],
+  "additionalProperties": false,
+  "properties": {
+    "schema": {"const": "release/v1"},
+    "title": {"type": "string", "minLength": 1, "maxLength": 256},
+    "artists": {"type": "array", "minItems": 1, "items": {"$ref": "track/v1#/definitions/artist"}},
+    "cover": {"$ref": "track/v1#/definitions/cid"},
+    "genre": {"type": "string", "minLength": 1, "maxLength": 64},
+    "upc": {"type": "string", "pattern": "^[0-9]{12,13}$"},
+    "release_date": {"type": "string", "pattern": "^[0-9]{4}-[0-9]{2}-[0-9]{2}$"},
+    "license": {"type": "string", "minLength": 1, "maxLength": 128},
+    "tracks": {"type": "array", "minItems": 1, "maxItems": 100, "items": {"$ref": "track/v1"}}
+  }
+}`,
+}
+
+var manifestSchemas = func() schemaRegistry {
+	r := make(schemaRegistry)
+	for id, doc := range manifestSchemaDocs {
+		s, err := parseJSONSchema(doc)
+		if err != nil {
+			panic(fmt.Sprintf("invalid manifest schema %s: %s", id, err))
+		}
+		r[id] = s
+	}
+	return r
+}()
+
+type ManifestArtist struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	Role    string `json:"role,omitempty"`
+}
+
+type ManifestSplit struct {
+	Address string  `json:"address"`
+	Share   float64 `json:"share"`
+}
+
+// Manifest is a track or a release, depending on its schema.
+type Manifest struct {
+	Schema      string           `json:"schema"`
+	Title       string           `json:"title"`
+	Artists     []ManifestArtist `json:"artists"`
+	Audio       string           `json:"audio,omitempty"`
+	Hls         string           `json:"hls,omitempty"`
+	Cover       string           `json:"cover"`
+	Genre       string           `json:"genre"`
+	Isrc        string           `json:"isrc,omitempty"`
+	Upc         string           `json:"upc,omitempty"`
+	ReleaseDate string           `json:"release_date,omitempty"`
+	Splits      []ManifestSplit  `json:"splits,omitempty"`
+	License     string           `json:"license"`
+	Tracks      []*Manifest      `json:"tracks,omitempty"`
+
+	// Raw is the manifest as uploaded, the bytes a signature covers
+	Raw       []byte             `json:"-"`
+	Signature *SignatureEnvelope `json:"-"`
+
+	// the manifest id and version the signature covers
+	signedID      string
+	signedVersion int
+}
+
+// ManifestSchemaIDs returns the supported schema versions.
+func ManifestSchemaIDs() []string {
+	ids := make([]string, 0, len(manifestSchemaDocs))
+	for id := range manifestSchemaDocs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// ManifestSchema returns the JSON Schema document with the given id.
+func ManifestSchema(id string) (string, bool) {
+	doc, ok := manifestSchemaDocs[id]
+	return doc, ok
+}
+
+// ParseManifest checks data against the schema it declares, the errors are ValidationErrors.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var errs ValidationErrors
+
+	if len(data) > MaxManifestSize {
+		errs.Add("(root)", "too_large", "must be at most %d bytes", MaxManifestSize)
+		return nil, errs
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		errs.Add("(root)", "invalid_json", "%s", err)
+		return nil, errs
+	}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		errs.Add("(root)", "type", "must be object")
+		return nil, errs
+	}
+
+	id, _ := obj["schema"].(string)
+	schema, ok := manifestSchemas[id]
+	if !ok {
+		errs.Add("schema", "unknown_schema", "must be one of %v", ManifestSchemaIDs())
+		return nil, errs
+	}
+
+	manifestSchemas.validate(schema, schema, "", doc, &errs)
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	m.Raw = data
+
+	m.checkSplits("", &errs)
+	for i, t := range m.Tracks {
+		t.checkSplits(fmt.Sprintf("tracks[%d].", i), &errs)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (m *Manifest) checkSplits(prefix string, errs *ValidationErrors) {
+	if len(m.Splits) == 0 {
+		return
+	}
+
+	var total float64
+	seen := make(map[string]bool)
+	for i, s := range m.Splits {
+		total += s.Share
+		if seen[s.Address] {
+			errs.Add(fmt.Sprintf("%ssplits[%d].address", prefix, i), "duplicate", "%s already has a share", s.Address)
+		}
+		seen[s.Address] = true
+	}
+
+	if math.Abs(total-100) > 0.001 {
+		errs.Add(prefix+"splits", "sum", "shares must add up to 100, got %v", total)
+	}
+}
+
+// CheckManifestReferences makes sure every cid of m was processed by this instance for p:
+// the audio finished transcoding to the referenced HLS and the cover is a known image.
+// The content of other clients is reported as unknown; p is nil when the authentication is disabled.
+func (bs *BStudio) CheckManifestReferences(m *Manifest, p *Principal) error {
+	var errs ValidationErrors
+
+	if err := bs.checkManifestReferences(m, p, "", &errs); err != nil {
+		return err
+	}
+
+	return errs.Err()
+}
+
+func (bs *BStudio) checkManifestReferences(m *Manifest, p *Principal, prefix string, errs *ValidationErrors) error {
+	info, err := bs.GetImageInfo(m.Cover)
+	if err != nil {
+		return err
+	}
+	if info == nil || p != nil && !p.Created(info.Owner, info.APIKeyID) {
+		errs.Add(prefix+"cover", "unknown_cid", "image %s was not processed by this instance", m.Cover)
+	}
+
+	if m.Audio != "" {
+		status, err := bs.GetUploadedStatus(m.Audio, p)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case status == nil:
+			errs.Add(prefix+"audio", "unknown_cid", "audio %s was not uploaded to this instance", m.Audio)
+		case status.Error != "":
+			errs.Add(prefix+"audio", "failed", "audio %s failed to transcode: %s", m.Audio, status.Error)
+		case status.Percentage < 100:
+			errs.Add(prefix+"audio", "processing", "audio %s is still transcoding (%d%%)", m.Audio, status.Percentage)
+		case status.HlsCid != m.Hls:
+			errs.Add(prefix+"hls", "mismatch", "hls must be %s, the HLS rendition of audio %s", status.HlsCid, m.Audio)
+		}
+	}
+
+	for i, t := range m.Tracks {
+		if err := bs.checkManifestReferences(t, p, fmt.Sprintf("%stracks[%d].", prefix, i), errs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package bstudio
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+var (
+	testAudioCid = "Qm" + strings.Repeat("a", 44)
+	testHlsCid   = "Qm" + strings.Repeat("b", 44)
+	testCoverCid = "Qm" + strings.Repeat("c", 44)
+	testAddress  = "bitsong1" + strings.Repeat("q", 38)
+)
+
+func testTrackManifest() map[string]interface{} {
+	return map[string]interface{}{
+		"schema":  SchemaTrackV1,
+		"title":   "Track",
+		"artists": []interface{}{map[string]interface{}{"name": "Artist", "address": testAddress, "role": "main"}},
+		"audio":   testAudioCid,
+		"hls":     testHlsCid,
+		"cover":   testCoverCid,
+		"genre":   "House",
+		"isrc":    "USRC17607839",
+		"splits":  []interface{}{map[string]interface{}{"address": testAddress, "share": 100}},
+		"license": "CC-BY-4.0",
+	}
+}
+
+func marshalManifest(t *testing.T, m map[string]interface{}) []byte {
+	bz, err := json.Marshal(m)
+	require.NoError(t, err)
+	return bz
+}
+
+func fields(err error) []string {
+	var res []string
+	for _, e := range err.(ValidationErrors) {
+		res = append(res, e.Field+":"+e.Code)
+	}
+	return res
+}
+
+func TestManifest_ValidTrack(t *testing.T) {
+	m, err := ParseManifest(marshalManifest(t, testTrackManifest()))
+	require.NoError(t, err)
+	require.Equal(t, "Track", m.Title)
+	require.Equal(t, testHlsCid, m.Hls)
+	require.Equal(t, 100.0, m.Splits[0].Share)
+}
+
+func TestManifest_FieldErrors(t *testing.T) {
+	track := testTrackManifest()
+	delete(track, "title")
+	track["isrc"] = "123"
+	track["cover"] = "not-a-cid"
+	track["mood"] = "happy"
+	track["artists"] = []interface{}{map[string]interface{}{"name": "", "role": "drummer"}}
+
+	_, err := ParseManifest(marshalManifest(t, track))
+	require.ElementsMatch(t, []string{
+		"title:required",
+		"isrc:pattern",
+		"cover:pattern",
+		"mood:unknown_field",
+		"artists[0].name:required",
+		"artists[0].role:enum",
+	}, fields(err))
+}
+
+func TestManifest_InvalidInput(t *testing.T) {
+	_, err := ParseManifest([]byte("{not json"))
+	require.Equal(t, []string{"(root):invalid_json"}, fields(err))
+
+	_, err = ParseManifest([]byte(`{"schema": "track/v0"}`))
+	require.Equal(t, []string{"schema:unknown_schema"}, fields(err))
+
+	_, err = ParseManifest([]byte(`[]`))
+	require.Equal(t, []string{"(root):type"}, fields(err))
+
+	_, err = ParseManifest([]byte(`"` + strings.Repeat("a", MaxManifestSize) + `"`))
+	require.Equal(t, []string{"(root):too_large"}, fields(err))
+}
+
+func TestManifest_Splits(t *testing.T) {
+	track := testTrackManifest()
+	track["splits"] = []interface{}{
+		map[string]interface{}{"address": testAddress, "share": 60},
+		map[string]interface{}{"address": testAddress, "share": 30},
+	}
+
+	_, err := ParseManifest(marshalManifest(t, track))
+	require.ElementsMatch(t, []string{"splits[1].address:duplicate", "splits:sum"}, fields(err))
+}
+
+func TestManifest_Release(t *testing.T) {
+	track := testTrackManifest()
+	delete(track, "audio")
+
+	release := map[string]interface{}{
+		"schema":       SchemaReleaseV1,
+		"title":        "Release",
+		"artists":      []interface{}{map[string]interface{}{"name": "Artist"}},
+		"cover":        testCoverCid,
+		"genre":        "House",
+		"release_date": "2020-10-01",
+		"license":      "CC-BY-4.0",
+		"tracks":       []interface{}{testTrackManifest(), track},
+	}
+
+	_, err := ParseManifest(marshalManifest(t, release))
+	require.Equal(t, []string{"tracks[1].audio:required"}, fields(err))
+
+	release["tracks"] = []interface{}{testTrackManifest()}
+	m, err := ParseManifest(marshalManifest(t, release))
+	require.NoError(t, err)
+	require.Len(t, m.Tracks, 1)
+	require.Equal(t, testAudioCid, m.Tracks[0].Audio)
+}
+
+func TestManifest_References(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	m, err := ParseManifest(marshalManifest(t, testTrackManifest()))
+	require.NoError(t, err)
+
+	err = bs.CheckManifestReferences(m, nil)
+	require.ElementsMatch(t, []string{"cover:unknown_cid", "audio:unknown_cid"}, fields(err))
+
+	require.NoError(t, bs.SaveImageInfo(&ImageInfo{Cid: testCoverCid}))
+	status := TranscodeStatus{Cid: testAudioCid, Type: MediaAudio, Percentage: 30}
+	bz, _ := json.Marshal(status)
+	require.NoError(t, ds.SetAndCommit([]byte(testAudioCid), bz))
+
+	err = bs.CheckManifestReferences(m, nil)
+	require.Equal(t, []string{"audio:processing"}, fields(err))
+
+	status.Percentage = 100
+	status.HlsCid = "QmOther"
+	bz, _ = json.Marshal(status)
+	require.NoError(t, ds.SetAndCommit([]byte(testAudioCid), bz))
+
+	err = bs.CheckManifestReferences(m, nil)
+	require.Equal(t, []string{"hls:mismatch"}, fields(err))
+
+	status.HlsCid = testHlsCid
+	bz, _ = json.Marshal(status)
+	require.NoError(t, ds.SetAndCommit([]byte(testAudioCid), bz))
+
+	require.NoError(t, bs.CheckManifestReferences(m, nil))
+
+	// a failed job is not reported as processing
+	status.Percentage = 30
+	status.Error = "cannot transcode"
+	bz, _ = json.Marshal(status)
+	require.NoError(t, ds.SetAndCommit([]byte(testAudioCid), bz))
+
+	err = bs.CheckManifestReferences(m, nil)
+	require.Equal(t, []string{"audio:failed"}, fields(err))
+}
+
+func TestManifest_ReferencesOwner(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := mockQueueBStudio(ds)
+
+	m, err := ParseManifest(marshalManifest(t, testTrackManifest()))
+	require.NoError(t, err)
+
+	alice := &Principal{Address: "bitsong1alice"}
+	bob := &Principal{Address: "bitsong1bob"}
+	service := &Principal{APIKeyID: "service"}
+	require.NoError(t, bs.SaveImageInfo(&ImageInfo{Cid: testCoverCid, Owner: alice.Address}))
+
+	// alice uploads the audio, then bob the same file
+	for _, p := range []*Principal{alice, bob} {
+		tr := NewTranscoder(bs, testAudioCid)
+		tr.SetPrincipal(p)
+		require.NoError(t, bs.Enqueue(tr))
+		require.NoError(t, tr.editStatus(func(status *TranscodeStatus) {
+			status.Percentage = 100
+			status.HlsCid = testHlsCid
+		}))
+	}
+
+	require.NoError(t, bs.CheckManifestReferences(m, alice))
+	require.NoError(t, bs.CheckManifestReferences(m, &Principal{APIKeyID: "admin", Scopes: []string{ScopeAdmin}}))
+
+	// bob cannot cite the cover of alice, nor a service key the content of the wallets
+	err = bs.CheckManifestReferences(m, bob)
+	require.Equal(t, []string{"cover:unknown_cid"}, fields(err))
+	err = bs.CheckManifestReferences(m, service)
+	require.ElementsMatch(t, []string{"cover:unknown_cid", "audio:unknown_cid"}, fields(err))
+}
@@ -0,0 +1,359 @@
+package bstudio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	uuid2 "github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	manifestPrefix        = "manifest/"
+	manifestVersionPrefix = "manifestversion/"
+	manifestCidPrefix     = "manifestcid/"
+
+	ipnsKeyPrefix = "bstudio-manifest-"
+)
+
+var (
+	ErrManifestNotFound = errors.New("manifest not found")
+	ErrManifestType     = errors.New("a manifest cannot change its type")
+	ErrStaleSignature   = errors.New("the signature covers another version of the manifest")
+)
+
+// ipnsPublisher publishes the latest version of every manifest, one publish at a time per manifest,
+// so that an older version never overwrites the record of a newer one.
+type ipnsPublisher struct {
+	mu      sync.Mutex
+	pending map[string]string // manifest id to the cid published next
+	running map[string]bool
+	wg      sync.WaitGroup
+
+	// publish replaces the ipfs node in the tests
+	publish func(id, cid string) error
+}
+
+// ManifestPointer is the stable reference to the latest version of a manifest.
+type ManifestPointer struct {
+	ID        string    `json:"id"`
+	Schema    string    `json:"schema"`
+	Cid       string    `json:"cid"`
+	Version   int       `json:"version"`
+	Tracks    []string  `json:"tracks,omitempty"`
+	Ipns      string    `json:"ipns,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ManifestVersion is one link of the update chain of a manifest.
+type ManifestVersion struct {
+	Version   int       `json:"version"`
+	Cid       string    `json:"cid"`
+	Previous  string    `json:"previous,omitempty"`
+	Tracks    []string  `json:"tracks,omitempty"`
+	Signer    string    `json:"signer,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateManifest stores the first version of m under a new id, owned by the owner address if any.
+func (bs *BStudio) CreateManifest(m *Manifest, owner string) (*ManifestPointer, error) {
+	bs.manifestMu.Lock()
+	defer bs.manifestMu.Unlock()
+
+	p := &ManifestPointer{
+		ID:     uuid2.New().String(),
+		Schema: m.Schema,
+		Owner:  owner,
+	}
+
+	v, err := bs.putManifestVersion(p, m)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key is only created for a manifest that made it to ipfs
+	if bs.PublishIPNS {
+		name, err := bs.ipnsKey(p.ID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create ipns key: %w", err)
+		}
+		p.Ipns = name
+	}
+
+	if err := bs.saveManifestVersion(p, v); err != nil {
+		if p.Ipns != "" {
+			bs.removeIpnsKey(p.ID)
+		}
+		return nil, err
+	}
+	bs.publishLatest(p)
+
+	return p, nil
+}
+
+// UpdateManifest stores m as the next version of the manifest id, linked to the current one.
+func (bs *BStudio) UpdateManifest(id string, m *Manifest) (*ManifestPointer, error) {
+	bs.manifestMu.Lock()
+	defer bs.manifestMu.Unlock()
+
+	p, err := bs.GetManifest(id)
+	if err != nil {
+		return nil, err
+	}
+	if manifestType(p.Schema) != manifestType(m.Schema) {
+		return nil, ErrManifestType
+	}
+	p.Schema = m.Schema
+
+	v, err := bs.putManifestVersion(p, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := bs.saveManifestVersion(p, v); err != nil {
+		return nil, err
+	}
+	bs.publishLatest(p)
+
+	return p, nil
+}
+
+// putManifestVersion adds m to ipfs as the version following p.
+func (bs *BStudio) putManifestVersion(p *ManifestPointer, m *Manifest) (*ManifestVersion, error) {
+	v := &ManifestVersion{
+		Version:   p.Version + 1,
+		Previous:  p.Cid,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	header := ManifestHeader{
+		Version:   v.Version,
+		Previous:  NewLink(v.Previous),
+		CreatedAt: v.CreatedAt.Format(time.RFC3339),
+	}
+
+	if m.Signature != nil {
+		// the first version is signed before the manifest has an id
+		id := p.ID
+		if v.Version == 1 {
+			id = ""
+		}
+		if m.signedID != id || m.signedVersion != v.Version {
+			return nil, ErrStaleSignature
+		}
+
+		rawCid, err := bs.Add(bytes.NewReader(m.Raw))
+		if err != nil {
+			return nil, err
+		}
+		header.Signature = &SignatureNode{
+			Standard:   SignatureADR036,
+			Manifest:   NewLink(rawCid),
+			ManifestID: id,
+			Signer:     m.Signature.Signer,
+			PubKey:     m.Signature.PubKey,
+			Signature:  m.Signature.Signature,
+		}
+		v.Signer = m.Signature.Signer
+	}
+
+	dag, err := bs.PutManifest(m, header)
+	if err != nil {
+		return nil, err
+	}
+	v.Cid = dag.Cid
+	v.Tracks = dag.Tracks
+
+	return v, nil
+}
+
+// saveManifestVersion records v, indexes its cids and moves the pointer to it.
+func (bs *BStudio) saveManifestVersion(p *ManifestPointer, v *ManifestVersion) error {
+	vbz, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	p.Cid = v.Cid
+	p.Version = v.Version
+	p.Tracks = v.Tracks
+	p.UpdatedAt = v.CreatedAt
+
+	pbz, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return bs.Ds.Db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte(manifestVersionKey(p.ID, v.Version)), vbz); err != nil {
+			return err
+		}
+		for _, cid := range append([]string{v.Cid}, v.Tracks...) {
+			if err := txn.Set([]byte(manifestCidPrefix+cid), []byte(p.ID)); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte(manifestPrefix+p.ID), pbz)
+	})
+}
+
+// IsManifestCid reports whether cid is a version, or a track of a version, of a stored manifest.
+func (bs *BStudio) IsManifestCid(cid string) (bool, error) {
+	bz, err := bs.Ds.Get([]byte(manifestCidPrefix + cid))
+	if err != nil {
+		return false, err
+	}
+
+	return len(bz) > 0, nil
+}
+
+// GetManifest returns the pointer to the latest version of the manifest id.
+func (bs *BStudio) GetManifest(id string) (*ManifestPointer, error) {
+	bz, err := bs.Ds.Get([]byte(manifestPrefix + id))
+	if err != nil {
+		return nil, err
+	}
+	if len(bz) == 0 {
+		return nil, ErrManifestNotFound
+	}
+
+	var p ManifestPointer
+	if err := json.Unmarshal(bz, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// GetManifestHistory returns every version of the manifest id, the latest first.
+func (bs *BStudio) GetManifestHistory(id string) ([]*ManifestVersion, error) {
+	if _, err := bs.GetManifest(id); err != nil {
+		return nil, err
+	}
+
+	var history []*ManifestVersion
+	err := bs.Ds.Iterate([]byte(manifestVersionPrefix+id+"/"), func(key, val []byte) error {
+		var v ManifestVersion
+		if err := json.Unmarshal(val, &v); err != nil {
+			return err
+		}
+		history = append([]*ManifestVersion{&v}, history...)
+		return nil
+	})
+
+	return history, err
+}
+
+// the zero padded version keeps the versions in order when iterating
+func manifestVersionKey(id string, version int) string {
+	return fmt.Sprintf("%s%s/%010d", manifestVersionPrefix, id, version)
+}
+
+func manifestType(schema string) string {
+	return strings.SplitN(schema, "/", 2)[0]
+}
+
+// ipnsKey creates the key the manifest id is published under and returns its ipns name.
+func (bs *BStudio) ipnsKey(id string) (string, error) {
+	var out struct {
+		Name string
+		Id   string
+	}
+	err := bs.sh.Request("key/gen", ipnsKeyPrefix+id).
+		Option("type", "ed25519").
+		Exec(context.Background(), &out)
+
+	return out.Id, err
+}
+
+// removeIpnsKey drops the key of a manifest that could not be saved.
+func (bs *BStudio) removeIpnsKey(id string) {
+	if err := bs.sh.Request("key/rm", ipnsKeyPrefix+id).Exec(context.Background(), nil); err != nil {
+		log.Error().Err(err).Str("id", id).Msg("cannot remove ipns key")
+	}
+}
+
+// publishLatest queues the publication of the current version of p, when it has an ipns name.
+// A version still waiting is replaced, only the latest one is published.
+func (bs *BStudio) publishLatest(p *ManifestPointer) {
+	if p.Ipns == "" {
+		return
+	}
+
+	q := &bs.ipns
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending == nil {
+		q.pending = make(map[string]string)
+		q.running = make(map[string]bool)
+	}
+	q.pending[p.ID] = p.Cid
+	if q.running[p.ID] {
+		return
+	}
+	q.running[p.ID] = true
+	q.wg.Add(1)
+	go bs.runPublish(p.ID)
+}
+
+// WaitPublishing waits for the ipns publications in flight until ctx is done, they use the ipfs api
+// and must end before it or the database is closed.
+func (bs *BStudio) WaitPublishing(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		bs.ipns.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runPublish publishes the pending versions of the manifest id until none is left.
+func (bs *BStudio) runPublish(id string) {
+	q := &bs.ipns
+	defer q.wg.Done()
+
+	for {
+		q.mu.Lock()
+		cid, ok := q.pending[id]
+		if !ok {
+			delete(q.running, id)
+			q.mu.Unlock()
+			return
+		}
+		delete(q.pending, id)
+		q.mu.Unlock()
+
+		bs.publishManifest(id, cid)
+	}
+}
+
+// publishManifest points the ipns name of the manifest id to cid, it can take a while.
+func (bs *BStudio) publishManifest(id, cid string) {
+	publish := bs.ipns.publish
+	if publish == nil {
+		publish = func(id, cid string) error {
+			_, err := bs.sh.PublishWithDetails("/ipfs/"+cid, ipnsKeyPrefix+id, 0, 0, false)
+			return err
+		}
+	}
+
+	if err := publish(id, cid); err != nil {
+		log.Error().Err(err).Str("id", id).Str("cid", cid).Msg("Failed to publish manifest to ipns")
+		return
+	}
+
+	log.Info().Str("id", id).Str("cid", cid).Msg("Manifest published to ipns")
+}
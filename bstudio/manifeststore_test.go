@@ -0,0 +1,126 @@
+package bstudio
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestManifestStore_History(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	p := &ManifestPointer{ID: "id", Schema: SchemaTrackV1}
+	for i := 1; i <= 11; i++ {
+		v := &ManifestVersion{
+			Version:   i,
+			Cid:       fmt.Sprintf("QmVersion%d", i),
+			Previous:  p.Cid,
+			CreatedAt: time.Now().UTC(),
+		}
+		require.NoError(t, bs.saveManifestVersion(p, v))
+	}
+
+	got, err := bs.GetManifest("id")
+	require.NoError(t, err)
+	require.Equal(t, 11, got.Version)
+	require.Equal(t, "QmVersion11", got.Cid)
+
+	history, err := bs.GetManifestHistory("id")
+	require.NoError(t, err)
+	require.Len(t, history, 11)
+	require.Equal(t, 11, history[0].Version)
+	require.Equal(t, "QmVersion10", history[0].Previous)
+	require.Equal(t, 1, history[10].Version)
+	require.Empty(t, history[10].Previous)
+
+	for _, cid := range []string{"QmVersion1", "QmVersion11"} {
+		known, err := bs.IsManifestCid(cid)
+		require.NoError(t, err)
+		require.True(t, known, cid)
+	}
+	known, err := bs.IsManifestCid("QmUnknown")
+	require.NoError(t, err)
+	require.False(t, known)
+}
+
+func TestManifestStore_NotFound(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	_, err := bs.GetManifest("unknown")
+	require.Equal(t, ErrManifestNotFound, err)
+
+	_, err = bs.GetManifestHistory("unknown")
+	require.Equal(t, ErrManifestNotFound, err)
+
+	_, err = bs.UpdateManifest("unknown", &Manifest{Schema: SchemaTrackV1})
+	require.Equal(t, ErrManifestNotFound, err)
+}
+
+func TestManifestStore_TypeChange(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	require.NoError(t, bs.saveManifestVersion(&ManifestPointer{ID: "id", Schema: SchemaTrackV1}, &ManifestVersion{Version: 1, Cid: "QmV1"}))
+
+	_, err := bs.UpdateManifest("id", &Manifest{Schema: SchemaReleaseV1})
+	require.Equal(t, ErrManifestType, err)
+}
+
+func TestManifestStore_PublishLatest(t *testing.T) {
+	bs := &BStudio{}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var published []string
+	bs.ipns.publish = func(id, cid string) error {
+		published = append(published, cid)
+		if cid == "QmVersion1" {
+			close(started)
+			<-release
+		}
+		return nil
+	}
+
+	p := &ManifestPointer{ID: "id", Ipns: "k51", Cid: "QmVersion1"}
+	bs.publishLatest(p)
+	<-started
+
+	// the versions queued while the first one is published collapse into the latest
+	for _, cid := range []string{"QmVersion2", "QmVersion3"} {
+		p.Cid = cid
+		bs.publishLatest(p)
+	}
+	close(release)
+	bs.ipns.wg.Wait()
+
+	require.Equal(t, []string{"QmVersion1", "QmVersion3"}, published)
+
+	// without an ipns name nothing is published
+	bs.publishLatest(&ManifestPointer{ID: "other", Cid: "QmOther"})
+	bs.ipns.wg.Wait()
+	require.Len(t, published, 2)
+}
+
+func TestManifestStore_WaitPublishing(t *testing.T) {
+	bs := &BStudio{}
+	release := make(chan struct{})
+	bs.ipns.publish = func(id, cid string) error {
+		<-release
+		return nil
+	}
+	bs.publishLatest(&ManifestPointer{ID: "id", Ipns: "k51", Cid: "QmVersion1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, bs.WaitPublishing(ctx))
+
+	close(release)
+	require.NoError(t, bs.WaitPublishing(context.Background()))
+}
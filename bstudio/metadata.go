@@ -0,0 +1,267 @@
+package bstudio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+const (
+	MetadataExif       = "exif"
+	MetadataExifGps    = "exif:gps"
+	MetadataExifCamera = "exif:camera"
+	MetadataXmp        = "xmp"
+	MetadataIcc        = "icc"
+	MetadataIptc       = "iptc"
+	MetadataComment    = "comment"
+
+	exifTagOrientation = 0x0112
+	exifTagMake        = 0x010f
+	exifTagModel       = 0x0110
+	exifTagExifIFD     = 0x8769
+	exifTagGpsIFD      = 0x8825
+
+	// bounds the inflated png profile
+	maxIccSize = 4 << 20
+)
+
+var (
+	jpegExifHeader = []byte("Exif\x00\x00")
+	jpegXmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegIccHeader  = []byte("ICC_PROFILE\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+	pngXmpKeyword  = []byte("XML:com.adobe.xmp\x00")
+)
+
+// ImageMetadata reports what was found in the original file. Nothing of it is
+// carried into the renditions: they are re-encoded from pixels only, so every
+// EXIF, XMP and ICC block is dropped. The pixels are converted to sRGB first when the
+// ICC profile is an RGB matrix profile, the others are assumed to be sRGB.
+type ImageMetadata struct {
+	Orientation    int      `json:"orientation"`
+	Removed        []string `json:"removed"`
+	ColorConverted bool     `json:"color_converted"`
+
+	icc []byte
+}
+
+func (m *ImageMetadata) add(kind string) {
+	for _, k := range m.Removed {
+		if k == kind {
+			return
+		}
+	}
+	m.Removed = append(m.Removed, kind)
+}
+
+// readImageMetadata scans the container of a jpeg, png or webp file for metadata blocks.
+// Malformed blocks are ignored, the image decoder is the one rejecting broken files.
+func readImageMetadata(contentType string, bz []byte) *ImageMetadata {
+	meta := &ImageMetadata{Orientation: 1, Removed: []string{}}
+
+	switch contentType {
+	case "image/jpeg":
+		readJpegMetadata(bz, meta)
+	case "image/png":
+		readPngMetadata(bz, meta)
+	case "image/webp":
+		readWebpMetadata(bz, meta)
+	}
+	sort.Strings(meta.Removed)
+
+	return meta
+}
+
+func readJpegMetadata(bz []byte, meta *ImageMetadata) {
+	// skip SOI, then walk the marker segments until the start of scan
+	for i := 2; i+4 <= len(bz); {
+		if bz[i] != 0xff {
+			return
+		}
+		marker := bz[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(bz[i+2:]))
+		if length < 2 || i+2+length > len(bz) {
+			return
+		}
+		payload := bz[i+4 : i+2+length]
+
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(payload, jpegExifHeader):
+			readExif(payload[len(jpegExifHeader):], meta)
+		case marker == 0xe1 && bytes.HasPrefix(payload, jpegXmpHeader):
+			meta.add(MetadataXmp)
+		case marker == 0xe2 && bytes.HasPrefix(payload, jpegIccHeader):
+			meta.add(MetadataIcc)
+			// the profile is split in numbered chunks, in order in practice
+			if chunk := payload[len(jpegIccHeader):]; len(chunk) > 2 {
+				meta.icc = append(meta.icc, chunk[2:]...)
+			}
+		case marker == 0xed:
+			meta.add(MetadataIptc)
+		case marker == 0xfe:
+			meta.add(MetadataComment)
+		}
+
+		i += 2 + length
+	}
+}
+
+func readPngMetadata(bz []byte, meta *ImageMetadata) {
+	if !bytes.HasPrefix(bz, pngSignature) {
+		return
+	}
+
+	for i := len(pngSignature); i+8 <= len(bz); {
+		length := int(binary.BigEndian.Uint32(bz[i:]))
+		kind := string(bz[i+4 : i+8])
+		if length < 0 || i+12+length > len(bz) {
+			return
+		}
+		data := bz[i+8 : i+8+length]
+
+		switch kind {
+		case "eXIf":
+			readExif(data, meta)
+		case "iCCP":
+			meta.add(MetadataIcc)
+			// profile name, null, compression method, zlib stream
+			if name := bytes.IndexByte(data, 0); name >= 0 && name+2 <= len(data) {
+				if zr, err := zlib.NewReader(bytes.NewReader(data[name+2:])); err == nil {
+					meta.icc, _ = ioutil.ReadAll(io.LimitReader(zr, maxIccSize))
+				}
+			}
+		case "iTXt":
+			if bytes.HasPrefix(data, pngXmpKeyword) {
+				meta.add(MetadataXmp)
+			} else {
+				meta.add(MetadataComment)
+			}
+		case "tEXt", "zTXt":
+			meta.add(MetadataComment)
+		case "IEND":
+			return
+		}
+
+		i += 12 + length
+	}
+}
+
+func readWebpMetadata(bz []byte, meta *ImageMetadata) {
+	if len(bz) < 12 || string(bz[:4]) != "RIFF" || string(bz[8:12]) != "WEBP" {
+		return
+	}
+
+	for i := 12; i+8 <= len(bz); {
+		kind := string(bz[i : i+4])
+		length := int(binary.LittleEndian.Uint32(bz[i+4:]))
+		if length < 0 || i+8+length > len(bz) {
+			return
+		}
+		data := bz[i+8 : i+8+length]
+
+		switch kind {
+		case "EXIF":
+			// some writers keep the jpeg app1 header in the chunk
+			readExif(bytes.TrimPrefix(data, jpegExifHeader), meta)
+		case "XMP ":
+			meta.add(MetadataXmp)
+		case "ICCP":
+			meta.add(MetadataIcc)
+			meta.icc = data
+		}
+
+		// chunks are padded to an even size
+		i += 8 + length + length%2
+	}
+}
+
+// readExif parses the TIFF structure of an EXIF block looking at IFD0 only.
+func readExif(tiff []byte, meta *ImageMetadata) {
+	meta.add(MetadataExif)
+
+	if len(tiff) < 8 {
+		return
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return
+		}
+
+		switch order.Uint16(tiff[entry:]) {
+		case exifTagOrientation:
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				meta.Orientation = o
+			}
+		case exifTagGpsIFD:
+			meta.add(MetadataExifGps)
+		case exifTagMake, exifTagModel, exifTagExifIFD:
+			meta.add(MetadataExifCamera)
+		}
+	}
+}
+
+// orient applies the EXIF orientation so that the pixels are stored upright.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// 5 to 8 swap the axes
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	min := img.Bounds().Min
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 cw
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 ccw
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, img.RGBAAt(min.X+x, min.Y+y))
+		}
+	}
+
+	return dst
+}
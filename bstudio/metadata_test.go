@@ -0,0 +1,134 @@
+package bstudio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// mockExif builds a little endian TIFF block with the orientation and a GPS IFD pointer.
+func mockExif(orientation uint16) []byte {
+	var b bytes.Buffer
+	b.WriteString("II*\x00")
+	binary.Write(&b, binary.LittleEndian, uint32(8))
+	binary.Write(&b, binary.LittleEndian, uint16(2))
+	// tag, type SHORT, count, value
+	binary.Write(&b, binary.LittleEndian, []uint16{exifTagOrientation, 3, 1, 0, orientation, 0})
+	binary.Write(&b, binary.LittleEndian, []uint16{exifTagGpsIFD, 4, 1, 0, 0, 0})
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+
+	return b.Bytes()
+}
+
+func mockJpegWithExif(t *testing.T, img image.Image, orientation uint16) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	bz := buf.Bytes()
+
+	payload := append(append([]byte{}, jpegExifHeader...), mockExif(orientation)...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	// insert the APP1 segment right after SOI
+	return append(append(append([]byte{}, bz[:2]...), app1...), bz[2:]...)
+}
+
+func TestMetadata_JpegOrientation(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 32; y++ {
+			src.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	bz := mockJpegWithExif(t, src, 6)
+	meta := readImageMetadata("image/jpeg", bz)
+	require.Equal(t, 6, meta.Orientation)
+	require.Equal(t, []string{MetadataExif, MetadataExifGps}, meta.Removed)
+
+	img, err := NewImage(bytes.NewReader(bz), DefaultImageBackground, DefaultMaxImagePixels)
+	require.NoError(t, err)
+	require.Equal(t, 32, img.img.Bounds().Dx())
+	require.Equal(t, 64, img.img.Bounds().Dy())
+
+	// the red left half is now on top
+	r, g, _, _ := img.img.At(16, 8).RGBA()
+	require.True(t, r > 0xf000 && g < 0x1000)
+}
+
+func TestMetadata_Orient(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	marker := color.RGBA{R: 255, A: 255}
+	src.SetRGBA(0, 0, marker)
+
+	cases := map[int]image.Point{
+		1: {0, 0},
+		2: {2, 0},
+		3: {2, 1},
+		4: {0, 1},
+		5: {0, 0},
+		6: {1, 0},
+		7: {1, 2},
+		8: {0, 2},
+	}
+	for o, p := range cases {
+		dst := orient(src, o)
+		require.Equal(t, marker, dst.RGBAAt(p.X, p.Y), "orientation %d", o)
+	}
+}
+
+func TestMetadata_Png(t *testing.T) {
+	var b bytes.Buffer
+	b.Write(pngSignature)
+	for _, chunk := range []struct {
+		kind string
+		data []byte
+	}{
+		{"iCCP", []byte("srgb\x00\x00")},
+		{"iTXt", append(append([]byte{}, pngXmpKeyword...), "<x:xmpmeta/>"...)},
+		{"IEND", nil},
+	} {
+		binary.Write(&b, binary.BigEndian, uint32(len(chunk.data)))
+		b.WriteString(chunk.kind)
+		b.Write(chunk.data)
+		binary.Write(&b, binary.BigEndian, uint32(0))
+	}
+
+	meta := readImageMetadata("image/png", b.Bytes())
+	require.Equal(t, 1, meta.Orientation)
+	require.Equal(t, []string{MetadataIcc, MetadataXmp}, meta.Removed)
+}
+
+func TestMetadata_JpegIccConverted(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			src.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, src, &jpeg.Options{Quality: 100}))
+	bz := buf.Bytes()
+
+	// a single APP2 chunk: header, sequence number, count, profile
+	payload := append(append([]byte{}, jpegIccHeader...), 1, 1)
+	payload = append(payload, matrixICC(adobeRGBD50, 2.2)...)
+	app2 := []byte{0xff, 0xe2, 0, 0}
+	binary.BigEndian.PutUint16(app2[2:], uint16(len(payload)+2))
+	bz = append(append(append([]byte{}, bz[:2]...), append(app2, payload...)...), bz[2:]...)
+
+	img, err := NewImage(bytes.NewReader(bz), DefaultImageBackground, DefaultMaxImagePixels)
+	require.NoError(t, err)
+	require.Equal(t, []string{MetadataIcc}, img.GetMetadata().Removed)
+	require.True(t, img.GetMetadata().ColorConverted)
+
+	// the adobe rgb orange is more saturated once in srgb
+	c := img.img.RGBAAt(8, 8)
+	require.InDelta(t, 227, c.R, 3)
+	require.InDelta(t, 42, c.B, 3)
+}
@@ -0,0 +1,163 @@
+package bstudio
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const metricsNamespace = "bstudio"
+
+// Stages of a job as measured by the job duration histogram.
+const (
+	JobStageDownload   = "download"
+	JobStageEncode     = "encode"
+	JobStageHls        = "hls"
+	JobStageThumbnails = "thumbnails"
+	JobStagePin        = "pin"
+)
+
+// Status of a measured job stage.
+const (
+	StageStatusOK    = "ok"
+	StageStatusError = "error"
+)
+
+// Metrics are the prometheus collectors of a studio, a nil *Metrics records nothing.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	activeWorkers   prometheus.Gauge
+	stageDuration   *prometheus.HistogramVec
+	failures        *prometheus.CounterVec
+	ipfsDuration    *prometheus.HistogramVec
+}
+
+// NewMetrics registers the collectors of bs, with the go runtime and process ones, in a new registry.
+func NewMetrics(bs *BStudio) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latencies by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		activeWorkers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_workers",
+			Help:      "Transcoding jobs running.",
+		}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "job_stage_duration_seconds",
+			Help:      "Duration of the stages of the transcoding jobs: download, encode, hls, thumbnails and pin, by status ok or error.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+		}, []string{"type", "stage", "status"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "job_failures_total",
+			Help:      "Failed transcoding jobs by type and reason code.",
+		}, []string{"type", "reason"}),
+		ipfsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "ipfs_request_duration_seconds",
+			Help:      "Latency of the IPFS API calls by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"op"}),
+	}
+
+	queueLength := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_length",
+		Help:      "Transcoding jobs waiting in the queue.",
+	}, func() float64 {
+		n, err := bs.waitingJobs()
+		if err != nil {
+			return 0
+		}
+		return float64(n)
+	})
+
+	badgerSize := func(kind string, size func() int64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "badger_size_bytes",
+			Help:        "Size of the badger database by kind of files.",
+			ConstLabels: prometheus.Labels{"kind": kind},
+		}, func() float64 {
+			return float64(size())
+		})
+	}
+
+	m.Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.activeWorkers,
+		m.stageDuration,
+		m.failures,
+		m.ipfsDuration,
+		queueLength,
+		badgerSize("lsm", func() int64 { lsm, _ := bs.Ds.Db.Size(); return lsm }),
+		badgerSize("vlog", func() int64 { _, vlog := bs.Ds.Db.Size(); return vlog }),
+	)
+
+	return m
+}
+
+// ObserveRequest counts a request to route, its path template.
+func (m *Metrics) ObserveRequest(route, method, code string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(route, method, code).Inc()
+	m.requestDuration.WithLabelValues(route, method).Observe(d.Seconds())
+}
+
+func (m *Metrics) workerStarted() {
+	if m != nil {
+		m.activeWorkers.Inc()
+	}
+}
+
+func (m *Metrics) workerDone() {
+	if m != nil {
+		m.activeWorkers.Dec()
+	}
+}
+
+// observeStage records the duration of a job stage started at start, meant to be deferred
+// with the address of the error the stage returns.
+func (m *Metrics) observeStage(mediaType, stage string, start time.Time, err *error) {
+	if m == nil {
+		return
+	}
+
+	status := StageStatusOK
+	if err != nil && *err != nil {
+		status = StageStatusError
+	}
+	m.stageDuration.WithLabelValues(mediaType, stage, status).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) jobFailed(mediaType, reason string) {
+	if m != nil {
+		m.failures.WithLabelValues(mediaType, reason).Inc()
+	}
+}
+
+// observeIPFS records the latency of an IPFS call started at start, meant to be deferred.
+func (m *Metrics) observeIPFS(op string, start time.Time) {
+	if m != nil {
+		m.ipfsDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}
+}
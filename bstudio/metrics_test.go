@@ -0,0 +1,114 @@
+package bstudio
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+
+	require.NotPanics(t, func() {
+		m.ObserveRequest("/api/v1/upload/audio", http.MethodPost, "200", time.Second)
+		m.workerStarted()
+		m.workerDone()
+		m.observeStage(MediaAudio, JobStageEncode, time.Now(), nil)
+		m.jobFailed(MediaAudio, ReasonTimeout)
+		m.observeIPFS("add", time.Now())
+	})
+}
+
+func TestMetrics_Collect(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+	bs.Metrics = NewMetrics(bs)
+	m := bs.Metrics
+
+	m.ObserveRequest("/api/v1/images/{cid}", http.MethodGet, "200", 10*time.Millisecond)
+	m.ObserveRequest("/api/v1/images/{cid}", http.MethodGet, "200", 20*time.Millisecond)
+	m.ObserveRequest("/api/v1/images/{cid}", http.MethodGet, "404", time.Millisecond)
+	require.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("/api/v1/images/{cid}", http.MethodGet, "200")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues("/api/v1/images/{cid}", http.MethodGet, "404")))
+
+	m.workerStarted()
+	require.Equal(t, float64(1), testutil.ToFloat64(m.activeWorkers))
+	m.workerDone()
+	require.Equal(t, float64(0), testutil.ToFloat64(m.activeWorkers))
+
+	require.NoError(t, bs.Enqueue(NewTranscoder(bs, "QmWaiting")))
+	families, err := m.Registry.Gather()
+	require.NoError(t, err)
+
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+		if f.GetName() == "bstudio_queue_length" {
+			require.Equal(t, float64(1), f.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+	for _, name := range []string{
+		"bstudio_http_requests_total",
+		"bstudio_http_request_duration_seconds",
+		"bstudio_active_workers",
+		"bstudio_queue_length",
+		"bstudio_badger_size_bytes",
+		"go_goroutines",
+	} {
+		require.True(t, names[name], name)
+	}
+}
+
+func TestMetrics_Stage(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+	bs.Metrics = NewMetrics(bs)
+	tr := &Transcoder{bs: bs, mediaType: MediaAudio}
+
+	require.NoError(t, tr.stage(JobStageEncode, func() error { return nil }))
+	require.Error(t, tr.stage(JobStageEncode, func() error { return errors.New("broken input") }))
+	require.Error(t, tr.stage(JobStagePin, func() error { return errors.New("ipfs unreachable") }))
+
+	count := func(stage, status string) int {
+		families, err := bs.Metrics.Registry.Gather()
+		require.NoError(t, err)
+		for _, f := range families {
+			if f.GetName() != "bstudio_job_stage_duration_seconds" {
+				continue
+			}
+			for _, m := range f.GetMetric() {
+				labels := make(map[string]string)
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["stage"] == stage && labels["status"] == status {
+					return int(m.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+		return 0
+	}
+	require.Equal(t, 1, count(JobStageEncode, StageStatusOK))
+	require.Equal(t, 1, count(JobStageEncode, StageStatusError))
+	require.Equal(t, 1, count(JobStagePin, StageStatusError))
+	require.Equal(t, 0, count(JobStagePin, StageStatusOK))
+}
+
+func TestMetrics_TranscoderFailure(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+	bs.Metrics = NewMetrics(bs)
+
+	tr := &Transcoder{bs: bs, cid: "QmFailed", mediaType: MediaVideo}
+	tr.fail(&ExecError{Stage: StageHls, Reason: ReasonMemoryLimit, Err: errors.New("signal: killed")})
+	tr.fail(errors.New("ipfs unreachable"))
+
+	require.Equal(t, float64(1), testutil.ToFloat64(bs.Metrics.failures.WithLabelValues(MediaVideo, ReasonMemoryLimit)))
+	require.Equal(t, float64(1), testutil.ToFloat64(bs.Metrics.failures.WithLabelValues(MediaVideo, "error")))
+}
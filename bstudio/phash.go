@@ -0,0 +1,130 @@
+package bstudio
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/nfnt/resize"
+	"image"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const (
+	phashPrefix = "phash/"
+
+	// the hash is indexed by byte, the threshold can be at most phashBands-1
+	phashBands = 8
+
+	DuplicateOff    = "off"
+	DuplicateFlag   = "flag"
+	DuplicateDedupe = "dedupe"
+)
+
+// DuplicatePolicy decides what happens to an image close to one already processed.
+type DuplicatePolicy struct {
+	Mode      string `json:"mode" yaml:"mode"`           // off, flag or dedupe
+	Threshold int    `json:"threshold" yaml:"threshold"` // maximum hamming distance between two hashes
+}
+
+var DefaultDuplicatePolicy = DuplicatePolicy{
+	Mode:      DuplicateDedupe,
+	Threshold: 4,
+}
+
+// ImageDuplicate is the closest known image to an upload.
+type ImageDuplicate struct {
+	Cid      string `json:"cid"`
+	Distance int    `json:"distance"`
+}
+
+// dHash computes the 64 bits difference hash of img: every bit tells whether a
+// pixel of the 9x8 grayscale thumbnail is brighter than its right neighbour.
+// It survives resizing, re-encoding and small color changes.
+func dHash(img image.Image) uint64 {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luminance(small, bounds.Min.X+x, bounds.Min.Y+y) > luminance(small, bounds.Min.X+x+1, bounds.Min.Y+y) {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+
+	return hash
+}
+
+func luminance(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// phashBandKey is the index prefix of one byte of the hash: two hashes within phashBands-1 bits
+// of each other share at least one byte, so only the images sharing a byte are compared.
+func phashBandKey(tenant string, band int, hash uint64) []byte {
+	return []byte(fmt.Sprintf("%s%s/%d/%02x/", phashPrefix, tenant, band, byte(hash>>(8*uint(band)))))
+}
+
+// IndexImageHash records the perceptual hash of the image stored at cid by tenant, the client who uploaded it.
+func (bs *BStudio) IndexImageHash(tenant, hash, cid string) error {
+	h, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil {
+		return err
+	}
+
+	return bs.Ds.Db.Update(func(txn *badger.Txn) error {
+		for band := 0; band < phashBands; band++ {
+			if err := txn.Set(append(phashBandKey(tenant, band, h), hash+"/"+cid...), []byte(cid)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindDuplicateImage returns the image of tenant closest to hash within threshold, nil if none.
+// The images of the other tenants are never matched, it would tell them apart from private uploads.
+func (bs *BStudio) FindDuplicateImage(tenant, hash string, threshold int) (*ImageDuplicate, error) {
+	h, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	if threshold >= phashBands {
+		return nil, fmt.Errorf("duplicate threshold %d is above %d", threshold, phashBands-1)
+	}
+
+	var best *ImageDuplicate
+	for band := 0; band < phashBands; band++ {
+		prefix := phashBandKey(tenant, band, h)
+		err = bs.Ds.Iterate(prefix, func(key, val []byte) error {
+			parts := strings.SplitN(string(key[len(prefix):]), "/", 2)
+			other, err := strconv.ParseUint(parts[0], 16, 64)
+			if err != nil {
+				return nil
+			}
+
+			d := hammingDistance(h, other)
+			if d <= threshold && (best == nil || d < best.Distance) {
+				best = &ImageDuplicate{Cid: string(val), Distance: d}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return best, nil
+}
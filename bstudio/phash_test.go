@@ -0,0 +1,66 @@
+package bstudio
+
+import (
+	"github.com/nfnt/resize"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func mockGradient(width, height int, invert bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			v := uint8((x*255/width + y*128/height) % 256)
+			if invert {
+				v = 255 - v
+			}
+			img.SetRGBA(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+
+	return img
+}
+
+func TestPHash_NearDuplicate(t *testing.T) {
+	original := mockGradient(400, 400, false)
+	resized := resize.Resize(150, 150, original, resize.Lanczos3)
+	different := mockGradient(400, 400, true)
+
+	h := dHash(original)
+	require.True(t, hammingDistance(h, dHash(resized)) <= DefaultDuplicatePolicy.Threshold)
+	require.True(t, hammingDistance(h, dHash(different)) > DefaultDuplicatePolicy.Threshold)
+}
+
+func TestPHash_FindDuplicate(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	require.NoError(t, bs.IndexImageHash("key:a", formatHash(0xff00), "QmFirst"))
+	require.NoError(t, bs.IndexImageHash("key:a", formatHash(0xff03), "QmSecond"))
+	// 7 bits apart, each in another byte
+	require.NoError(t, bs.IndexImageHash("key:a", formatHash(0x0101010101010180), "QmSpread"))
+	require.NoError(t, bs.IndexImageHash("key:b", formatHash(0xff01), "QmOther"))
+
+	dup, err := bs.FindDuplicateImage("key:a", formatHash(0xff01), 4)
+	require.NoError(t, err)
+	require.Equal(t, &ImageDuplicate{Cid: "QmFirst", Distance: 1}, dup)
+
+	dup, err = bs.FindDuplicateImage("key:a", formatHash(0x0000000000000080), 7)
+	require.NoError(t, err)
+	require.Equal(t, &ImageDuplicate{Cid: "QmSpread", Distance: 7}, dup)
+
+	dup, err = bs.FindDuplicateImage("key:a", formatHash(0xffffffff), 4)
+	require.NoError(t, err)
+	require.Nil(t, dup)
+
+	// the images of another client are not matched
+	dup, err = bs.FindDuplicateImage("key:c", formatHash(0xff01), 4)
+	require.NoError(t, err)
+	require.Nil(t, dup)
+
+	_, err = bs.FindDuplicateImage("key:a", formatHash(0xff01), phashBands)
+	require.Error(t, err)
+}
@@ -0,0 +1,222 @@
+package bstudio
+
+import (
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+	blurHashSampleSize  = 32
+	paletteSampleSize   = 64
+	paletteSize         = 5
+	base83Chars         = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// ImagePlaceholder is what clients draw while the artwork loads from the gateway.
+type ImagePlaceholder struct {
+	BlurHash      string   `json:"blurhash"`
+	DominantColor string   `json:"dominant_color"`
+	Palette       []string `json:"palette"`
+}
+
+// NewImagePlaceholder computes the BlurHash and the color palette of img.
+func NewImagePlaceholder(img image.Image) *ImagePlaceholder {
+	palette := extractPalette(resize.Thumbnail(paletteSampleSize, paletteSampleSize, img, resize.Bilinear), paletteSize)
+
+	hexes := make([]string, len(palette))
+	for i, c := range palette {
+		hexes[i] = c.hex()
+	}
+
+	p := &ImagePlaceholder{
+		BlurHash: blurHash(resize.Thumbnail(blurHashSampleSize, blurHashSampleSize, img, resize.Bilinear), blurHashComponentsX, blurHashComponentsY),
+		Palette:  hexes,
+	}
+	if len(hexes) > 0 {
+		p.DominantColor = hexes[0]
+	}
+
+	return p
+}
+
+// blurHash implements the encoder of https://github.com/woltapp/blurhash.
+func blurHash(img image.Image, cx, cy int) string {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	factors := make([][3]float64, 0, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := norm * math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					f[0] += basis * srgbToLinear(r>>8)
+					f[1] += basis * srgbToLinear(g>>8)
+					f[2] += basis * srgbToLinear(b>>8)
+				}
+			}
+
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((cx-1)+(cy-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	hash.WriteString(encode83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4))
+	for _, f := range ac {
+		q := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(q(f[0])*19*19+q(f[1])*19+q(f[2]), 2))
+	}
+
+	return hash.String()
+}
+
+func encode83(value, length int) string {
+	var b strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		b.WriteByte(base83Chars[digit])
+	}
+
+	return b.String()
+}
+
+func srgbToLinear(v uint32) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) int {
+	c := math.Max(0, math.Min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+type paletteColor struct {
+	r, g, b uint8
+	count   int
+}
+
+func (c paletteColor) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.r, c.g, c.b)
+}
+
+// extractPalette quantizes img with the median cut algorithm and returns up to
+// size colors ordered by the number of pixels they represent.
+func extractPalette(img image.Image, size int) []paletteColor {
+	bounds := img.Bounds()
+	pixels := make([][3]uint8, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			pixels = append(pixels, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := [][][3]uint8{pixels}
+	for len(boxes) < size {
+		// split the box with the widest channel range
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			channel, r := widestChannel(box)
+			if r > bestRange {
+				best, bestChannel, bestRange = i, channel, r
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i][bestChannel] < box[j][bestChannel] })
+		mid := len(box) / 2
+		boxes = append(boxes[:best], append([][][3]uint8{box[:mid], box[mid:]}, boxes[best+1:]...)...)
+	}
+
+	colors := make([]paletteColor, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, b int
+		for _, p := range box {
+			r += int(p[0])
+			g += int(p[1])
+			b += int(p[2])
+		}
+		n := len(box)
+		colors = append(colors, paletteColor{r: uint8(r / n), g: uint8(g / n), b: uint8(b / n), count: n})
+	}
+	sort.SliceStable(colors, func(i, j int) bool { return colors[i].count > colors[j].count })
+
+	return colors
+}
+
+func widestChannel(box [][3]uint8) (int, int) {
+	min := [3]uint8{255, 255, 255}
+	var max [3]uint8
+	for _, p := range box {
+		for c := 0; c < 3; c++ {
+			if p[c] < min[c] {
+				min[c] = p[c]
+			}
+			if p[c] > max[c] {
+				max[c] = p[c]
+			}
+		}
+	}
+
+	channel, r := 0, 0
+	for c := 0; c < 3; c++ {
+		if int(max[c])-int(min[c]) > r {
+			channel, r = c, int(max[c])-int(min[c])
+		}
+	}
+
+	return channel, r
+}
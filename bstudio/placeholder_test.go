@@ -0,0 +1,50 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPlaceholder_Uniform(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 40; y++ {
+			img.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+
+	p := NewImagePlaceholder(img)
+	require.Equal(t, "#ffffff", p.DominantColor)
+	require.Equal(t, []string{"#ffffff"}, p.Palette)
+
+	// size flag, max ac, white dc, then the ac components
+	require.Len(t, p.BlurHash, 4+2*blurHashComponentsX*blurHashComponentsY)
+	require.Equal(t, encode83(3+2*9, 1), p.BlurHash[:1])
+	require.Equal(t, encode83(0xffffff, 4), p.BlurHash[2:6])
+}
+
+func TestPlaceholder_Palette(t *testing.T) {
+	// three quarters red, one quarter blue
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 40; y++ {
+			c := color.RGBA{R: 200, A: 255}
+			if x >= 30 {
+				c = color.RGBA{B: 200, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	p := NewImagePlaceholder(img)
+	require.Equal(t, "#c80000", p.DominantColor)
+	require.Contains(t, p.Palette, "#0000c8")
+}
+
+func TestPlaceholder_Encode83(t *testing.T) {
+	require.Equal(t, "00", encode83(0, 2))
+	require.Equal(t, "~", encode83(82, 1))
+	require.Equal(t, "10", encode83(83, 2))
+}
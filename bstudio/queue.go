@@ -0,0 +1,280 @@
+package bstudio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	jobPrefix       = "job/"
+	jobStatusPrefix = "jobstatus/"
+
+	// jobCidPrefix points a content cid to its latest job
+	jobCidPrefix = "jobcid/"
+	// jobOwnerCidPrefix points a content cid to the latest job of each uploader
+	jobOwnerCidPrefix = "jobownercid/"
+
+	// jobCancelGrace is how long Shutdown waits for the canceled job to stop
+	jobCancelGrace = 5 * time.Second
+)
+
+// ErrShuttingDown is returned by Enqueue once the shutdown started.
+var ErrShuttingDown = errors.New("bstudio is shutting down, no new job is accepted")
+
+// queuedJob is what is kept of a job until it succeeds or fails, to requeue it after a restart.
+type queuedJob struct {
+	ID        string    `json:"id"`
+	Cid       string    `json:"cid"`
+	Type      string    `json:"type"`
+	Encrypted bool      `json:"encrypted,omitempty"`
+	APIKeyID  string    `json:"api_key_id,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	QueuedAt  time.Time `json:"queued_at,omitempty"`
+}
+
+func jobKey(id string) []byte {
+	return []byte(jobPrefix + id)
+}
+
+func jobStatusKey(id string) []byte {
+	return []byte(jobStatusPrefix + id)
+}
+
+func jobCidKey(cid string) []byte {
+	return []byte(jobCidPrefix + cid)
+}
+
+func jobOwnerCidKey(owner, apiKeyID, cid string) []byte {
+	return []byte(jobOwnerCidPrefix + ClientID(apiKeyID, owner) + "/" + cid)
+}
+
+// Enqueue stores the job and its initial status and wakes the worker up, it never waits for the
+// running jobs: the worker reads the jobs from the store, the oldest first.
+func (bs *BStudio) Enqueue(t *Transcoder) error {
+	select {
+	case <-bs.stopping:
+		return ErrShuttingDown
+	default:
+	}
+
+	bz, err := json.Marshal(queuedJob{
+		ID:        t.id,
+		Cid:       t.cid,
+		Type:      t.mediaType,
+		Encrypted: t.encrypted,
+		APIKeyID:  t.apiKeyID,
+		Owner:     t.owner,
+		Client:    t.client,
+		RequestID: t.requestID,
+		QueuedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	statusBz, err := json.Marshal(t.initialStatus())
+	if err != nil {
+		return err
+	}
+	err = bs.Ds.Db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(jobKey(t.id), bz); err != nil {
+			return err
+		}
+		if err := txn.Set(jobStatusKey(t.id), statusBz); err != nil {
+			return err
+		}
+		if t.owner != "" || t.apiKeyID != "" {
+			if err := txn.Set(jobOwnerCidKey(t.owner, t.apiKeyID, t.cid), []byte(t.id)); err != nil {
+				return err
+			}
+		}
+		return txn.Set(jobCidKey(t.cid), []byte(t.id))
+	})
+	if err != nil {
+		return err
+	}
+
+	bs.wakeWorker()
+	return nil
+}
+
+// wakeWorker tells the worker a job is stored, without waiting when it was already told.
+func (bs *BStudio) wakeWorker() {
+	select {
+	case bs.queued <- struct{}{}:
+	default:
+	}
+}
+
+// RequeuePending logs the jobs left unfinished by the previous run and wakes the worker up,
+// they are still stored and run first. A requeued job keeps its id.
+func (bs *BStudio) RequeuePending() error {
+	jobs, err := bs.storedJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		j.transcoder(bs).log().Info().Msg("requeued unfinished job")
+	}
+	bs.wakeWorker()
+
+	return nil
+}
+
+// storedJobs returns the jobs waiting or running, the oldest first.
+func (bs *BStudio) storedJobs() ([]queuedJob, error) {
+	var jobs []queuedJob
+	err := bs.Ds.Iterate([]byte(jobPrefix), func(key, val []byte) error {
+		var j queuedJob
+		if err := json.Unmarshal(val, &j); err != nil {
+			return err
+		}
+		jobs = append(jobs, j)
+		return nil
+	})
+	sort.SliceStable(jobs, func(i, k int) bool { return jobs[i].QueuedAt.Before(jobs[k].QueuedAt) })
+
+	return jobs, err
+}
+
+// nextJob returns the oldest stored job not run yet, nil when there is none.
+func (bs *BStudio) nextJob(ran map[string]bool) (*Transcoder, error) {
+	jobs, err := bs.storedJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, j := range jobs {
+		if t := j.transcoder(bs); !ran[t.id] {
+			return t, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// transcoder returns the job as it was enqueued.
+func (j queuedJob) transcoder(bs *BStudio) *Transcoder {
+	// the jobs of the older versions are keyed by their cid
+	id := j.ID
+	if id == "" {
+		id = j.Cid
+	}
+
+	return &Transcoder{
+		bs:        bs,
+		id:        id,
+		cid:       j.Cid,
+		mediaType: j.Type,
+		encrypted: j.Encrypted,
+		apiKeyID:  j.APIKeyID,
+		owner:     j.Owner,
+		client:    j.Client,
+		requestID: j.RequestID,
+	}
+}
+
+// StartTranscoding runs the stored jobs one after the other, the oldest first, until the shutdown.
+func (bs *BStudio) StartTranscoding() {
+	atomic.StoreInt32(&bs.workerStarted, 1)
+	if bs.workerDone != nil {
+		defer close(bs.workerDone)
+	}
+
+	// a job runs once per start, even when it could not be removed from the store afterwards
+	ran := make(map[string]bool)
+	for {
+		// a shutdown wins over the jobs still stored, they run at the next start
+		select {
+		case <-bs.stopping:
+			return
+		default:
+		}
+
+		t, err := bs.nextJob(ran)
+		if err != nil {
+			log.Error().Err(err).Msg("cannot read the queued jobs")
+		}
+		if t == nil {
+			select {
+			case <-bs.stopping:
+				return
+			case <-bs.queued:
+			}
+			continue
+		}
+
+		ran[t.id] = true
+		atomic.StoreInt32(&bs.runningJobs, 1)
+		bs.Metrics.workerStarted()
+		t.Transcode(bs.jobsCtx())
+		bs.Metrics.workerDone()
+		atomic.StoreInt32(&bs.runningJobs, 0)
+	}
+}
+
+// waitingJobs is the number of stored jobs not running yet.
+func (bs *BStudio) waitingJobs() (int, error) {
+	n := 0
+	err := bs.Ds.Iterate([]byte(jobPrefix), func(key, val []byte) error {
+		n++
+		return nil
+	})
+
+	return n - int(atomic.LoadInt32(&bs.runningJobs)), err
+}
+
+func (bs *BStudio) jobsCtx() context.Context {
+	if bs.jobs == nil {
+		return context.Background()
+	}
+	return bs.jobs
+}
+
+// StopAccepting starts the shutdown: the new jobs are refused and the instance is no longer ready.
+func (bs *BStudio) StopAccepting() {
+	bs.stopOnce.Do(func() { close(bs.stopping) })
+}
+
+// Stopping reports whether the shutdown started.
+func (bs *BStudio) Stopping() bool {
+	select {
+	case <-bs.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stops accepting jobs and lets the running one finish until ctx is done, then cancels it
+// and waits for it at most jobCancelGrace. Without a worker started there is nothing to wait for.
+// The interrupted and queued jobs are requeued by RequeuePending at the next start.
+func (bs *BStudio) Shutdown(ctx context.Context) error {
+	bs.StopAccepting()
+	if atomic.LoadInt32(&bs.workerStarted) == 0 {
+		return nil
+	}
+
+	select {
+	case <-bs.workerDone:
+		return nil
+	case <-ctx.Done():
+	}
+
+	bs.cancelJobs()
+	select {
+	case <-bs.workerDone:
+	case <-time.After(jobCancelGrace):
+		log.Warn().Msg("the canceled job did not stop in time")
+	}
+
+	return ctx.Err()
+}
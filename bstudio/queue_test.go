@@ -0,0 +1,170 @@
+package bstudio
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func mockQueueBStudio(ds *Ds) *BStudio {
+	jobs, cancelJobs := context.WithCancel(context.Background())
+	return &BStudio{
+		Ds:         ds,
+		queued:     make(chan struct{}, 1),
+		stopping:   make(chan struct{}),
+		workerDone: make(chan struct{}),
+		jobs:       jobs,
+		cancelJobs: cancelJobs,
+	}
+}
+
+// storedJobs returns the cids of the stored jobs.
+func storedJobs(t *testing.T, ds *Ds) []string {
+	var cids []string
+	require.NoError(t, ds.Iterate([]byte(jobPrefix), func(key, val []byte) error {
+		var j queuedJob
+		require.NoError(t, json.Unmarshal(val, &j))
+		require.Equal(t, string(key[len(jobPrefix):]), j.ID)
+		cids = append(cids, j.Cid)
+		return nil
+	}))
+	return cids
+}
+
+func TestQueue_ShutdownKeepsQueuedJobs(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := mockQueueBStudio(ds)
+
+	tr := NewVideoTranscoder(bs, "QmQueued")
+	tr.SetEncrypted(true)
+	tr.SetPrincipal(&Principal{APIKeyID: "key1"})
+	tr.SetClient("key:key1")
+	tr.SetRequestID("req-1")
+	require.NoError(t, bs.Enqueue(tr))
+	require.Equal(t, []string{"QmQueued"}, storedJobs(t, ds))
+
+	// the worker is started once stopping, it must not take the queued job
+	require.False(t, bs.Stopping())
+	bs.StopAccepting()
+	require.True(t, bs.Stopping())
+	go bs.StartTranscoding()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, bs.Shutdown(ctx))
+	require.Equal(t, []string{"QmQueued"}, storedJobs(t, ds))
+
+	require.Equal(t, ErrShuttingDown, bs.Enqueue(NewTranscoder(bs, "QmRefused")))
+	require.Equal(t, []string{"QmQueued"}, storedJobs(t, ds))
+
+	// the next run requeues the job as it was
+	next := mockQueueBStudio(ds)
+	require.NoError(t, next.RequeuePending())
+	requeued, err := next.nextJob(map[string]bool{})
+	require.NoError(t, err)
+	require.Equal(t, &Transcoder{
+		bs:        next,
+		id:        tr.ID(),
+		cid:       "QmQueued",
+		mediaType: MediaVideo,
+		encrypted: true,
+		apiKeyID:  "key1",
+		client:    "key:key1",
+		requestID: "req-1",
+	}, requeued)
+}
+
+func TestQueue_EnqueueNeverWaits(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := mockQueueBStudio(ds)
+
+	// no worker runs, the uploads are stored and answered right away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, cid := range []string{"QmFirst", "QmSecond", "QmThird"} {
+			require.NoError(t, bs.Enqueue(NewTranscoder(bs, cid)))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("enqueue waited for the worker")
+	}
+	require.Len(t, bs.queued, 1)
+
+	waiting, err := bs.waitingJobs()
+	require.NoError(t, err)
+	require.Equal(t, 3, waiting)
+
+	// the worker takes the oldest job it did not run yet
+	ran := make(map[string]bool)
+	for _, cid := range []string{"QmFirst", "QmSecond", "QmThird"} {
+		next, err := bs.nextJob(ran)
+		require.NoError(t, err)
+		require.Equal(t, cid, next.cid)
+		ran[next.id] = true
+	}
+	next, err := bs.nextJob(ran)
+	require.NoError(t, err)
+	require.Nil(t, next)
+}
+
+func TestQueue_JobsOfTheSameContent(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := mockQueueBStudio(ds)
+
+	first := NewTranscoder(bs, "QmSame")
+	first.SetPrincipal(&Principal{APIKeyID: "key1"})
+	second := NewTranscoder(bs, "QmSame")
+	second.SetPrincipal(&Principal{Address: "bitsong1owner"})
+	require.NoError(t, bs.Enqueue(first))
+	require.NoError(t, bs.Enqueue(second))
+	require.NotEqual(t, first.ID(), second.ID())
+	require.Equal(t, []string{"QmSame", "QmSame"}, storedJobs(t, ds))
+
+	// each upload keeps its own status and owner
+	status, err := bs.GetJobStatus(first.ID())
+	require.NoError(t, err)
+	require.Equal(t, "key1", status.APIKeyID)
+	require.Empty(t, status.Owner)
+
+	status, err = bs.GetJobStatus(second.ID())
+	require.NoError(t, err)
+	require.Equal(t, "bitsong1owner", status.Owner)
+
+	// the content points to the latest job
+	bz, err := bs.GetTranscodingStatus("QmSame")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(bz, status))
+	require.Equal(t, second.ID(), status.ID)
+
+	status, err = bs.GetJobStatus("unknown")
+	require.NoError(t, err)
+	require.Nil(t, status)
+}
+
+func TestQueue_ShutdownWithoutWorker(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := mockQueueBStudio(ds)
+	require.NoError(t, bs.Enqueue(NewTranscoder(bs, "QmQueued")))
+
+	// the worker never started, there is no job to wait for
+	done := make(chan error, 1)
+	go func() {
+		done <- bs.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown waited for a worker that never started")
+	}
+	require.Equal(t, []string{"QmQueued"}, storedJobs(t, ds))
+}
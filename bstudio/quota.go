@@ -0,0 +1,324 @@
+package bstudio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	quotaPrefix = "quota/"
+
+	quotaDayTTL   = 48 * time.Hour
+	quotaMonthTTL = 62 * 24 * time.Hour
+)
+
+// Usage counts what a client consumed in a quota period.
+type Usage struct {
+	Uploads int64   `json:"uploads"`
+	Bytes   int64   `json:"bytes"`
+	Minutes float64 `json:"minutes"`
+}
+
+func (u *Usage) add(d Usage) {
+	u.Uploads += d.Uploads
+	u.Bytes += d.Bytes
+	u.Minutes += d.Minutes
+}
+
+// sub removes d from u, a counter never goes below zero.
+func (u *Usage) sub(d Usage) {
+	u.Uploads = maxInt64(u.Uploads-d.Uploads, 0)
+	u.Bytes = maxInt64(u.Bytes-d.Bytes, 0)
+	u.Minutes = math.Max(u.Minutes-d.Minutes, 0)
+}
+
+// exceeds returns which field of u is over limit, zero fields of limit are unlimited.
+func (u Usage) exceeds(limit Usage) string {
+	switch {
+	case limit.Uploads > 0 && u.Uploads > limit.Uploads:
+		return "uploads"
+	case limit.Bytes > 0 && u.Bytes > limit.Bytes:
+		return "bytes"
+	case limit.Minutes > 0 && u.Minutes > limit.Minutes:
+		return "minutes"
+	}
+
+	return ""
+}
+
+// QuotaLimits is the usage allowed to each client per day and per calendar month (UTC).
+type QuotaLimits struct {
+	Daily   Usage `json:"daily"`
+	Monthly Usage `json:"monthly"`
+}
+
+func (l QuotaLimits) Enabled() bool {
+	return l.Daily != (Usage{}) || l.Monthly != (Usage{})
+}
+
+// ParseUsage reads comma separated uploads=, bytes= and minutes= limits, e.g. uploads=100,minutes=600.
+func ParseUsage(s string) (Usage, error) {
+	var u Usage
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return u, fmt.Errorf("quota %s must be field=value", pair)
+		}
+
+		var err error
+		switch kv[0] {
+		case "uploads":
+			u.Uploads, err = strconv.ParseInt(kv[1], 10, 64)
+		case "bytes":
+			u.Bytes, err = strconv.ParseInt(kv[1], 10, 64)
+		case "minutes":
+			u.Minutes, err = strconv.ParseFloat(kv[1], 64)
+		default:
+			return u, fmt.Errorf("unknown quota %s, expected uploads, bytes or minutes", kv[0])
+		}
+		if err != nil {
+			return u, fmt.Errorf("invalid %s quota %s", kv[0], kv[1])
+		}
+	}
+
+	return u, nil
+}
+
+// QuotaExceededError is returned when a request would go over a quota, until Reset.
+type QuotaExceededError struct {
+	Period string
+	Field  string
+	Reset  time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %s exceeded", e.Period, e.Field)
+}
+
+// QuotaState is the usage of a client in the current periods.
+type QuotaState struct {
+	Daily        Usage     `json:"daily"`
+	Monthly      Usage     `json:"monthly"`
+	DailyReset   time.Time `json:"daily_reset"`
+	MonthlyReset time.Time `json:"monthly_reset"`
+}
+
+// Quotas tracks the usage per client in badger, the counters expire with their period.
+type Quotas struct {
+	ds     *Ds
+	Limits QuotaLimits
+
+	mu sync.Mutex
+}
+
+func NewQuotas(ds *Ds, limits QuotaLimits) *Quotas {
+	return &Quotas{ds: ds, Limits: limits}
+}
+
+func quotaDayKey(client string, now time.Time) []byte {
+	return []byte(fmt.Sprintf("%s%s/day/%s", quotaPrefix, client, now.UTC().Format("2006-01-02")))
+}
+
+func quotaMonthKey(client string, now time.Time) []byte {
+	return []byte(fmt.Sprintf("%s%s/month/%s", quotaPrefix, client, now.UTC().Format("2006-01")))
+}
+
+func (q *Quotas) usage(key []byte) (Usage, error) {
+	var u Usage
+
+	bz, err := q.ds.Get(key)
+	if err != nil || len(bz) == 0 {
+		return u, err
+	}
+
+	return u, json.Unmarshal(bz, &u)
+}
+
+// State returns the usage of client at now.
+func (q *Quotas) State(client string, now time.Time) (*QuotaState, error) {
+	day, err := q.usage(quotaDayKey(client, now))
+	if err != nil {
+		return nil, err
+	}
+	month, err := q.usage(quotaMonthKey(client, now))
+	if err != nil {
+		return nil, err
+	}
+
+	y, m, d := now.UTC().Date()
+
+	return &QuotaState{
+		Daily:        day,
+		Monthly:      month,
+		DailyReset:   time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC),
+		MonthlyReset: time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+// Check returns a QuotaExceededError when adding d to the usage of client would go over a limit.
+func (q *Quotas) Check(client string, d Usage, now time.Time) (*QuotaState, error) {
+	s, err := q.State(client, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, q.check(s, d)
+}
+
+func (q *Quotas) check(s *QuotaState, d Usage) error {
+	day, month := s.Daily, s.Monthly
+	day.add(d)
+	month.add(d)
+
+	if f := day.exceeds(q.Limits.Daily); f != "" {
+		return &QuotaExceededError{Period: "daily", Field: f, Reset: s.DailyReset}
+	}
+	if f := month.exceeds(q.Limits.Monthly); f != "" {
+		return &QuotaExceededError{Period: "monthly", Field: f, Reset: s.MonthlyReset}
+	}
+
+	return nil
+}
+
+// QuotaReservation is usage counted before the work is done, Cancel gives it back when the work is not done.
+type QuotaReservation struct {
+	q      *Quotas
+	client string
+	usage  Usage
+	at     time.Time
+}
+
+// Reserve adds d to the usage of client unless it goes over a limit, checking and adding at once
+// so that concurrent requests cannot both pass the check. The state is the usage before d.
+func (q *Quotas) Reserve(client string, d Usage, now time.Time) (*QuotaState, *QuotaReservation, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s, err := q.State(client, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := q.check(s, d); err != nil {
+		return s, nil, err
+	}
+	if err := q.apply(client, now, func(u *Usage) { u.add(d) }); err != nil {
+		return s, nil, err
+	}
+
+	return s, &QuotaReservation{q: q, client: client, usage: d, at: now}, nil
+}
+
+// Cancel removes the reserved usage, from the periods it was counted in. A nil reservation is a no-op.
+func (r *QuotaReservation) Cancel() error {
+	if r == nil {
+		return nil
+	}
+
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+
+	return r.q.apply(r.client, r.at, func(u *Usage) { u.sub(r.usage) })
+}
+
+// Record adds d to the usage of client, whatever the limits.
+func (q *Quotas) Record(client string, d Usage, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.apply(client, now, func(u *Usage) { u.add(d) })
+}
+
+// apply changes the usage of client in the periods of now. Must hold mu.
+func (q *Quotas) apply(client string, now time.Time, change func(u *Usage)) error {
+	for _, p := range []struct {
+		key []byte
+		ttl time.Duration
+	}{
+		{quotaDayKey(client, now), quotaDayTTL},
+		{quotaMonthKey(client, now), quotaMonthTTL},
+	} {
+		u, err := q.usage(p.key)
+		if err != nil {
+			return err
+		}
+		change(&u)
+
+		bz, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if err := q.ds.SetWithTTL(p.key, bz, p.ttl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// QuotaRemaining is what a client may still use before the tightest of its quotas,
+// a nil field has no limit.
+type QuotaRemaining struct {
+	Uploads *int64
+	Bytes   *int64
+	Minutes *float64
+	Reset   time.Time
+}
+
+// Remaining returns the usage left to the client of s under limits.
+func (s *QuotaState) Remaining(limits QuotaLimits) QuotaRemaining {
+	var r QuotaRemaining
+
+	for _, p := range []struct {
+		used, limit Usage
+		reset       time.Time
+	}{
+		{s.Daily, limits.Daily, s.DailyReset},
+		{s.Monthly, limits.Monthly, s.MonthlyReset},
+	} {
+		if p.limit == (Usage{}) {
+			continue
+		}
+		if r.Reset.IsZero() {
+			r.Reset = p.reset
+		}
+
+		if p.limit.Uploads > 0 {
+			left := maxInt64(p.limit.Uploads-p.used.Uploads, 0)
+			if r.Uploads == nil || left < *r.Uploads {
+				r.Uploads = &left
+			}
+		}
+		if p.limit.Bytes > 0 {
+			left := maxInt64(p.limit.Bytes-p.used.Bytes, 0)
+			if r.Bytes == nil || left < *r.Bytes {
+				r.Bytes = &left
+			}
+		}
+		if p.limit.Minutes > 0 {
+			left := math.Max(p.limit.Minutes-p.used.Minutes, 0)
+			if r.Minutes == nil || left < *r.Minutes {
+				r.Minutes = &left
+			}
+		}
+	}
+
+	return r
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,140 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuotas_CheckAndRecord(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+
+	q := NewQuotas(ds, QuotaLimits{
+		Daily:   Usage{Uploads: 2},
+		Monthly: Usage{Bytes: 1000, Minutes: 10},
+	})
+	now := time.Date(2020, 9, 30, 12, 0, 0, 0, time.UTC)
+
+	_, err := q.Check("key:a", Usage{Uploads: 1, Bytes: 400}, now)
+	require.NoError(t, err)
+	require.NoError(t, q.Record("key:a", Usage{Uploads: 1, Bytes: 400}, now))
+	require.NoError(t, q.Record("key:a", Usage{Uploads: 1, Bytes: 400}, now))
+
+	_, err = q.Check("key:a", Usage{Uploads: 1}, now)
+	qerr, ok := err.(*QuotaExceededError)
+	require.True(t, ok)
+	require.Equal(t, "daily", qerr.Period)
+	require.Equal(t, "uploads", qerr.Field)
+	require.Equal(t, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC), qerr.Reset)
+
+	// the next day, the daily counter starts over but not the monthly one
+	tomorrow := now.Add(12 * time.Hour)
+	_, err = q.Check("key:a", Usage{Uploads: 1, Bytes: 100}, tomorrow)
+	require.NoError(t, err)
+
+	// 2020-10-01 is a new month too
+	s, err := q.State("key:a", tomorrow)
+	require.NoError(t, err)
+	require.Equal(t, Usage{}, s.Monthly)
+
+	_, err = q.Check("key:a", Usage{Uploads: 1, Bytes: 300}, now.Add(-24*time.Hour))
+	qerr, ok = err.(*QuotaExceededError)
+	require.True(t, ok)
+	require.Equal(t, "monthly", qerr.Period)
+	require.Equal(t, "bytes", qerr.Field)
+
+	// other clients are not affected
+	_, err = q.Check("key:b", Usage{Uploads: 1}, now)
+	require.NoError(t, err)
+}
+
+func TestQuotas_Reserve(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+
+	q := NewQuotas(ds, QuotaLimits{Daily: Usage{Uploads: 3}})
+	now := time.Date(2020, 9, 30, 12, 0, 0, 0, time.UTC)
+
+	// concurrent reservations cannot pass the limit together
+	results := make(chan *QuotaReservation, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, res, err := q.Reserve("key:a", Usage{Uploads: 1, Bytes: 100}, now)
+			if err == nil {
+				results <- res
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	var reserved []*QuotaReservation
+	for res := range results {
+		reserved = append(reserved, res)
+	}
+	require.Len(t, reserved, 3)
+
+	s, err := q.State("key:a", now)
+	require.NoError(t, err)
+	require.Equal(t, Usage{Uploads: 3, Bytes: 300}, s.Daily)
+	require.Equal(t, Usage{Uploads: 3, Bytes: 300}, s.Monthly)
+
+	// a canceled reservation is given back, in the period it was made in
+	require.NoError(t, reserved[0].Cancel())
+	s, err = q.State("key:a", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, Usage{Uploads: 2, Bytes: 200}, s.Daily)
+
+	_, _, err = q.Reserve("key:a", Usage{Uploads: 1}, now)
+	require.NoError(t, err)
+	_, _, err = q.Reserve("key:a", Usage{Uploads: 1}, now)
+	require.IsType(t, &QuotaExceededError{}, err)
+
+	require.NoError(t, (*QuotaReservation)(nil).Cancel())
+}
+
+func TestQuotaState_Remaining(t *testing.T) {
+	limits := QuotaLimits{
+		Daily:   Usage{Uploads: 10, Bytes: 1000},
+		Monthly: Usage{Uploads: 100, Bytes: 1500},
+	}
+	s := &QuotaState{
+		Daily:      Usage{Uploads: 4, Bytes: 200},
+		Monthly:    Usage{Uploads: 95, Bytes: 1200},
+		DailyReset: time.Unix(1600000000, 0),
+	}
+
+	r := s.Remaining(limits)
+	require.Equal(t, int64(5), *r.Uploads)
+	require.Equal(t, int64(300), *r.Bytes)
+	require.Nil(t, r.Minutes)
+	require.Equal(t, s.DailyReset, r.Reset)
+
+	require.True(t, (&QuotaState{}).Remaining(QuotaLimits{}).Reset.IsZero())
+}
+
+func TestParseUsage(t *testing.T) {
+	u, err := ParseUsage("uploads=100, bytes=1024,minutes=60.5")
+	require.NoError(t, err)
+	require.Equal(t, Usage{Uploads: 100, Bytes: 1024, Minutes: 60.5}, u)
+
+	u, err = ParseUsage("")
+	require.NoError(t, err)
+	require.Equal(t, Usage{}, u)
+
+	for _, s := range []string{"files=1", "uploads", "bytes=1kb"} {
+		_, err := ParseUsage(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestPrincipal_ClientID(t *testing.T) {
+	require.Equal(t, "key:abc", (&Principal{APIKeyID: "abc"}).ClientID())
+	require.Equal(t, "address:bitsong1x", (&Principal{Address: "bitsong1x"}).ClientID())
+	require.Equal(t, "", (&Principal{}).ClientID())
+}
@@ -0,0 +1,174 @@
+package bstudio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RouteGroupAuth     = "auth"
+	RouteGroupUpload   = "upload"
+	RouteGroupManifest = "manifest"
+	RouteGroupRead     = "read"
+
+	// RouteGroupIP limits every request by IP, before the credentials are checked
+	RouteGroupIP = "ip"
+
+	// above this many buckets, the idle ones are dropped
+	maxIdleBuckets = 10000
+)
+
+// RateLimit is a token bucket: Rate tokens per minute, up to Burst at once.
+type RateLimit struct {
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Burst int     `json:"burst" yaml:"burst"`
+}
+
+var DefaultRateLimits = map[string]RateLimit{
+	RouteGroupAuth:     {Rate: 10, Burst: 5},
+	RouteGroupUpload:   {Rate: 10, Burst: 5},
+	RouteGroupManifest: {Rate: 30, Burst: 10},
+	RouteGroupRead:     {Rate: 300, Burst: 100},
+	RouteGroupIP:       {Rate: 600, Burst: 200},
+}
+
+// ParseRateLimits reads group=rate:burst pairs separated by commas, e.g. upload=10:5,read=300:100.
+// The groups left out keep their default limit.
+func ParseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit, len(DefaultRateLimits))
+	for g, l := range DefaultRateLimits {
+		limits[g] = l
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if _, ok := DefaultRateLimits[kv[0]]; !ok {
+			return nil, fmt.Errorf("unknown rate limit group %s", kv[0])
+		}
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("rate limit %s must be group=rate:burst", pair)
+		}
+
+		rb := strings.SplitN(kv[1], ":", 2)
+		rate, err := strconv.ParseFloat(rb[0], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("rate of %s must be a positive number of requests per minute", kv[0])
+		}
+		burst := int(math.Ceil(rate))
+		if len(rb) == 2 {
+			if burst, err = strconv.Atoi(rb[1]); err != nil || burst < 1 {
+				return nil, fmt.Errorf("burst of %s must be a positive integer", kv[0])
+			}
+		}
+
+		limits[kv[0]] = RateLimit{Rate: rate, Burst: burst}
+	}
+
+	return limits, nil
+}
+
+// FormatRateLimits is the inverse of ParseRateLimits.
+func FormatRateLimits(limits map[string]RateLimit) string {
+	groups := make([]string, 0, len(limits))
+	for g := range limits {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	pairs := make([]string, len(groups))
+	for i, g := range groups {
+		pairs[i] = fmt.Sprintf("%s=%s:%d", g, strconv.FormatFloat(limits[g].Rate, 'f', -1, 64), limits[g].Burst)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// RateLimitResult is the state of a bucket after a request, as sent in the X-RateLimit-* headers.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps a token bucket per route group and client in memory.
+type RateLimiter struct {
+	limits map[string]RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limits:  limits,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the bucket of client in group, groups without a limit always allow.
+func (rl *RateLimiter) Allow(group, client string, now time.Time) RateLimitResult {
+	limit, ok := rl.limits[group]
+	if !ok {
+		return RateLimitResult{Allowed: true}
+	}
+	perSecond := limit.Rate / 60
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	key := group + "|" + client
+	b, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= maxIdleBuckets {
+			rl.prune(now)
+		}
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	res := RateLimitResult{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsDuration((1 - b.tokens) / perSecond)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = secondsDuration((float64(limit.Burst) - b.tokens) / perSecond)
+
+	return res
+}
+
+// prune drops the buckets that have refilled, they are the same as new ones. Must hold mu.
+func (rl *RateLimiter) prune(now time.Time) {
+	for key, b := range rl.buckets {
+		limit := rl.limits[strings.SplitN(key, "|", 2)[0]]
+		if b.tokens+now.Sub(b.last).Seconds()*limit.Rate/60 >= float64(limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
@@ -0,0 +1,67 @@
+package bstudio
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Burst(t *testing.T) {
+	rl := NewRateLimiter(map[string]RateLimit{RouteGroupUpload: {Rate: 60, Burst: 3}})
+	now := time.Unix(1600000000, 0)
+
+	for i := 2; i >= 0; i-- {
+		res := rl.Allow(RouteGroupUpload, "key:a", now)
+		require.True(t, res.Allowed)
+		require.Equal(t, 3, res.Limit)
+		require.Equal(t, i, res.Remaining)
+	}
+
+	res := rl.Allow(RouteGroupUpload, "key:a", now)
+	require.False(t, res.Allowed)
+	require.Equal(t, time.Second, res.RetryAfter)
+	require.Equal(t, 3*time.Second, res.Reset)
+
+	// other clients have their own bucket
+	require.True(t, rl.Allow(RouteGroupUpload, "key:b", now).Allowed)
+
+	// one token a second
+	require.True(t, rl.Allow(RouteGroupUpload, "key:a", now.Add(time.Second)).Allowed)
+	require.False(t, rl.Allow(RouteGroupUpload, "key:a", now.Add(time.Second)).Allowed)
+}
+
+func TestRateLimiter_UnknownGroup(t *testing.T) {
+	rl := NewRateLimiter(map[string]RateLimit{})
+	for i := 0; i < 100; i++ {
+		require.True(t, rl.Allow(RouteGroupRead, "ip:127.0.0.1", time.Now()).Allowed)
+	}
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+	rl := NewRateLimiter(map[string]RateLimit{RouteGroupRead: {Rate: 60, Burst: 1}})
+	now := time.Unix(1600000000, 0)
+
+	rl.Allow(RouteGroupRead, "key:a", now)
+	rl.prune(now)
+	require.Len(t, rl.buckets, 1)
+
+	rl.prune(now.Add(time.Second))
+	require.Len(t, rl.buckets, 0)
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("upload=2:1, read=600")
+	require.NoError(t, err)
+	require.Equal(t, RateLimit{Rate: 2, Burst: 1}, limits[RouteGroupUpload])
+	require.Equal(t, RateLimit{Rate: 600, Burst: 600}, limits[RouteGroupRead])
+	require.Equal(t, DefaultRateLimits[RouteGroupAuth], limits[RouteGroupAuth])
+
+	parsed, err := ParseRateLimits(FormatRateLimits(DefaultRateLimits))
+	require.NoError(t, err)
+	require.Equal(t, DefaultRateLimits, parsed)
+
+	for _, s := range []string{"foo=1:1", "upload", "upload=0", "upload=x:1", "upload=1:0"} {
+		_, err := ParseRateLimits(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,225 @@
+package bstudio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the subset of JSON Schema draft-07 used by the manifest schemas.
+type jsonSchema struct {
+	ID                   string                 `json:"$id"`
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Const                interface{}            `json:"const"`
+	Enum                 []interface{}          `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaRegistry resolves $ref either to a definition of the root schema or to another registered schema id.
+type schemaRegistry map[string]*jsonSchema
+
+func parseJSONSchema(doc string) (*jsonSchema, error) {
+	var s jsonSchema
+	if err := json.Unmarshal([]byte(doc), &s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *jsonSchema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+
+	for _, sub := range s.Properties {
+		if err := sub.compile(); err != nil {
+			return err
+		}
+	}
+	for _, sub := range s.Definitions {
+		if err := sub.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+
+	return nil
+}
+
+// resolve returns the referenced schema and the root its own references are relative to.
+func (r schemaRegistry) resolve(root *jsonSchema, ref string) (*jsonSchema, *jsonSchema) {
+	parts := strings.SplitN(ref, "#", 2)
+	if parts[0] != "" {
+		root = r[parts[0]]
+		if root == nil {
+			return nil, nil
+		}
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		return root, root
+	}
+	if !strings.HasPrefix(parts[1], "/definitions/") {
+		return nil, nil
+	}
+
+	return root.Definitions[strings.TrimPrefix(parts[1], "/definitions/")], root
+}
+
+// validate appends an error for every violation of s by v, found at path.
+func (r schemaRegistry) validate(root, s *jsonSchema, path string, v interface{}, errs *ValidationErrors) {
+	field := path
+	if field == "" {
+		field = "(root)"
+	}
+
+	if s.Ref != "" {
+		ref, refRoot := r.resolve(root, s.Ref)
+		if ref == nil {
+			errs.Add(field, "schema", "unresolved schema reference %s", s.Ref)
+			return
+		}
+		root, s = refRoot, ref
+	}
+
+	if s.Type != "" && !hasJSONType(v, s.Type) {
+		errs.Add(field, "type", "must be %s", s.Type)
+		return
+	}
+	if s.Const != nil && v != s.Const {
+		errs.Add(field, "const", "must be %v", s.Const)
+	}
+	if len(s.Enum) > 0 {
+		var found bool
+		for _, e := range s.Enum {
+			if v == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs.Add(field, "enum", "must be one of %v", s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := len([]rune(val))
+		if s.MinLength != nil && n < *s.MinLength {
+			if *s.MinLength == 1 {
+				errs.Add(field, "required", "must not be empty")
+			} else {
+				errs.Add(field, "min_length", "must be at least %d characters", *s.MinLength)
+			}
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			errs.Add(field, "max_length", "must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			errs.Add(field, "pattern", "must match %s", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			errs.Add(field, "minimum", "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			errs.Add(field, "maximum", "must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			errs.Add(field, "min_items", "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			errs.Add(field, "max_items", "must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				r.validate(root, s.Items, fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				errs.Add(joinField(path, name), "required", "is required")
+			}
+		}
+
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					errs.Add(joinField(path, name), "unknown_field", "is not allowed")
+				}
+				continue
+			}
+			r.validate(root, prop, joinField(path, name), val[name], errs)
+		}
+	}
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+func hasJSONType(v interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+
+	return false
+}
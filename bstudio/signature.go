@@ -0,0 +1,298 @@
+package bstudio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"golang.org/x/crypto/ripemd160"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+)
+
+const (
+	AddressPrefix = "bitsong"
+
+	PubKeySecp256k1Type = "tendermint/PubKeySecp256k1"
+	SignatureADR036     = "adr-036"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrUnsignedManifest = errors.New("manifest is not signed")
+)
+
+// PubKey is an amino encoded public key, its value is base64.
+type PubKey struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// SignatureEnvelope is the envelope returned by the wallets when signing arbitrary data (ADR-036),
+// e.g. a manifest exactly as uploaded or a login challenge.
+type SignatureEnvelope struct {
+	Signer    string `json:"signer"`
+	PubKey    PubKey `json:"pub_key"`
+	Signature string `json:"signature"` // base64 of the 64 bytes r || s
+}
+
+// SignatureNode is the signature envelope embedded in the manifest DAG. It links the
+// signed bytes, so that indexers can check the authorship offline.
+type SignatureNode struct {
+	Standard   string `json:"standard"`
+	Manifest   *Link  `json:"manifest"`
+	ManifestID string `json:"manifest_id,omitempty"`
+	Signer     string `json:"signer"`
+	PubKey     PubKey `json:"pub_key"`
+	Signature  string `json:"signature"`
+}
+
+func ParseSignatureEnvelope(data []byte) (*SignatureEnvelope, error) {
+	var sig SignatureEnvelope
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	return &sig, nil
+}
+
+// PubKeyAddress returns the bech32 account address of a compressed secp256k1 public key.
+func PubKeyAddress(pubKey []byte) (string, error) {
+	sha := sha256.Sum256(pubKey)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+
+	return Bech32Encode(AddressPrefix, hasher.Sum(nil))
+}
+
+// adr036SignDoc returns the amino json sign document of a MsgSignData: keys sorted,
+// no whitespace, zero chain id, account number, sequence and fee.
+func adr036SignDoc(signer string, data []byte) []byte {
+	signerBz, _ := json.Marshal(signer)
+	dataBz, _ := json.Marshal(base64.StdEncoding.EncodeToString(data))
+
+	return []byte(fmt.Sprintf(
+		`{"account_number":"0","chain_id":"","fee":{"amount":[],"gas":"0"},"memo":"","msgs":[{"type":"sign/MsgSignData","value":{"data":%s,"signer":%s}}],"sequence":"0"}`,
+		dataBz, signerBz,
+	))
+}
+
+// ManifestSignData returns the bytes an artist signs for the given version of the manifest id:
+// a line with the id and the version, then the manifest as uploaded. A signature cannot be replayed
+// on another manifest or version, the first version is signed with an empty id since it has none yet.
+func ManifestSignData(id string, version int, raw []byte) []byte {
+	return append([]byte(fmt.Sprintf("bstudio-manifest:%s:%d\n", id, version)), raw...)
+}
+
+// Verify checks that data was signed by the key of the signer address.
+func (s *SignatureEnvelope) Verify(data []byte) error {
+	hrp, _, err := Bech32Decode(s.Signer)
+	if err != nil {
+		return fmt.Errorf("%w: signer %s: %s", ErrInvalidSignature, s.Signer, err)
+	}
+	if hrp != AddressPrefix {
+		return fmt.Errorf("%w: signer must be a %s address", ErrInvalidSignature, AddressPrefix)
+	}
+
+	if s.PubKey.Type != PubKeySecp256k1Type {
+		return fmt.Errorf("%w: unsupported public key type %s", ErrInvalidSignature, s.PubKey.Type)
+	}
+	pubKeyBz, err := base64.StdEncoding.DecodeString(s.PubKey.Value)
+	if err != nil || len(pubKeyBz) != btcec.PubKeyBytesLenCompressed {
+		return fmt.Errorf("%w: public key must be a base64 compressed secp256k1 key", ErrInvalidSignature)
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBz, btcec.S256())
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	addr, err := PubKeyAddress(pubKeyBz)
+	if err != nil {
+		return err
+	}
+	if addr != s.Signer {
+		return fmt.Errorf("%w: public key belongs to %s, not %s", ErrInvalidSignature, addr, s.Signer)
+	}
+
+	sigBz, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil || len(sigBz) != 64 {
+		return fmt.Errorf("%w: signature must be base64 of 64 bytes", ErrInvalidSignature)
+	}
+	sig := &btcec.Signature{
+		R: new(big.Int).SetBytes(sigBz[:32]),
+		S: new(big.Int).SetBytes(sigBz[32:]),
+	}
+	// like the cosmos sdk, only accept the lower S form to rule out malleability
+	if sig.S.Cmp(new(big.Int).Rsh(btcec.S256().N, 1)) > 0 {
+		return fmt.Errorf("%w: signature is not in lower S form", ErrInvalidSignature)
+	}
+
+	hash := sha256.Sum256(adr036SignDoc(s.Signer, data))
+	if !sig.Verify(hash[:], pubKey) {
+		return fmt.Errorf("%w: signature does not match the manifest", ErrInvalidSignature)
+	}
+
+	return nil
+}
+
+// HasArtist reports whether address belongs to an artist of the manifest or of one of its tracks.
+func (m *Manifest) HasArtist(address string) bool {
+	for _, a := range m.Artists {
+		if a.Address == address {
+			return true
+		}
+	}
+	for _, t := range m.Tracks {
+		if t.HasArtist(address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetSignature attaches sig to m, once checked that it covers the uploaded bytes as the given
+// version of the manifest id and comes from one of the artists. The errors are ValidationErrors.
+func (m *Manifest) SetSignature(sig *SignatureEnvelope, id string, version int) error {
+	var errs ValidationErrors
+
+	if err := sig.Verify(ManifestSignData(id, version, m.Raw)); err != nil {
+		errs.Add("signature", "invalid", "%s", err)
+		return errs
+	}
+	if !m.HasArtist(sig.Signer) {
+		errs.Add("signature", "not_artist", "%s is not the address of an artist of the manifest", sig.Signer)
+		return errs
+	}
+
+	m.Signature = sig
+	m.signedID = id
+	m.signedVersion = version
+	return nil
+}
+
+// VerifyManifestDag checks the signature embedded in the manifest object at cid, against the signed bytes
+// it links, and that the objects of the DAG encode those bytes and nothing else. Only the manifests of the
+// store are verified, any other cid is ErrManifestNotFound.
+func (bs *BStudio) VerifyManifestDag(ctx context.Context, cid string) (*SignatureEnvelope, error) {
+	known, err := bs.IsManifestCid(cid)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		return nil, ErrManifestNotFound
+	}
+
+	var root struct {
+		ManifestHeader
+		Schema string `json:"schema"`
+	}
+	if err := bs.DagGet(ctx, cid, &root); err != nil {
+		return nil, err
+	}
+	if root.Signature == nil || root.Signature.Manifest == nil {
+		return nil, ErrUnsignedManifest
+	}
+
+	rc, err := bs.CatContext(ctx, root.Signature.Manifest.Cid)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	raw, err := ioutil.ReadAll(io.LimitReader(rc, MaxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > MaxManifestSize {
+		return nil, ErrManifestTooLarge
+	}
+
+	m, err := ParseManifest(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &SignatureEnvelope{
+		Signer:    root.Signature.Signer,
+		PubKey:    root.Signature.PubKey,
+		Signature: root.Signature.Signature,
+	}
+	if err := m.SetSignature(sig, root.Signature.ManifestID, root.Version); err != nil {
+		return sig, err
+	}
+
+	return sig, bs.checkManifestDag(ctx, cid, m)
+}
+
+// checkManifestDag encodes m again and compares it with the objects stored at cid. The artwork and
+// track links are taken from the stored objects, they are not part of the signed bytes.
+func (bs *BStudio) checkManifestDag(ctx context.Context, cid string, m *Manifest) error {
+	var errs ValidationErrors
+
+	if m.Schema != SchemaReleaseV1 {
+		var got TrackNode
+		if err := bs.DagGet(ctx, cid, &got); err != nil {
+			return err
+		}
+		want := newTrackNode(m, linkCid(got.Artwork))
+		want.ManifestHeader = got.ManifestHeader
+		if !sameNode(want, &got) {
+			errs.Add("(root)", "mismatch", "the manifest object does not match the signed manifest")
+		}
+
+		return errs.Err()
+	}
+
+	var got ReleaseNode
+	if err := bs.DagGet(ctx, cid, &got); err != nil {
+		return err
+	}
+	var tracks []string
+	for _, l := range got.Tracks {
+		tracks = append(tracks, linkCid(l))
+	}
+	want := newReleaseNode(m, linkCid(got.Artwork), tracks)
+	want.ManifestHeader = got.ManifestHeader
+	if !sameNode(want, &got) || len(tracks) != len(m.Tracks) {
+		errs.Add("(root)", "mismatch", "the release object does not match the signed manifest")
+		return errs
+	}
+
+	for i, t := range m.Tracks {
+		var got TrackNode
+		if err := bs.DagGet(ctx, tracks[i], &got); err != nil {
+			return err
+		}
+		if !sameNode(newTrackNode(t, linkCid(got.Artwork)), &got) {
+			errs.Add(fmt.Sprintf("tracks[%d]", i), "mismatch", "the track object does not match the signed manifest")
+		}
+	}
+
+	return errs.Err()
+}
+
+func linkCid(l *Link) string {
+	if l == nil {
+		return ""
+	}
+	return l.Cid
+}
+
+// sameNode compares two objects through their json encoding, the one they are stored from.
+func sameNode(a, b interface{}) bool {
+	var da, db interface{}
+	if bz, err := json.Marshal(a); err != nil || json.Unmarshal(bz, &da) != nil {
+		return false
+	}
+	if bz, err := json.Marshal(b); err != nil || json.Unmarshal(bz, &db) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(da, db)
+}
@@ -0,0 +1,133 @@
+package bstudio
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestBech32_Vectors(t *testing.T) {
+	hrp, data, err := Bech32Decode("abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw")
+	require.NoError(t, err)
+	require.Equal(t, "abcdef", hrp)
+
+	s, err := Bech32Encode(hrp, data)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", s)
+
+	_, _, err = Bech32Decode("abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx")
+	require.Error(t, err)
+	_, _, err = Bech32Decode("A12uEL5L")
+	require.Error(t, err)
+}
+
+func signADR036(t *testing.T, key *btcec.PrivateKey, signer string, raw []byte) *SignatureEnvelope {
+	hash := sha256.Sum256(adr036SignDoc(signer, raw))
+	sig, err := key.Sign(hash[:])
+	require.NoError(t, err)
+
+	bz := make([]byte, 64)
+	r, s := sig.R.Bytes(), sig.S.Bytes()
+	copy(bz[32-len(r):32], r)
+	copy(bz[64-len(s):], s)
+
+	return &SignatureEnvelope{
+		Signer:    signer,
+		PubKey:    PubKey{Type: PubKeySecp256k1Type, Value: base64.StdEncoding.EncodeToString(key.PubKey().SerializeCompressed())},
+		Signature: base64.StdEncoding.EncodeToString(bz),
+	}
+}
+
+func signedTrack(t *testing.T) (*btcec.PrivateKey, string, []byte) {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	addr, err := PubKeyAddress(key.PubKey().SerializeCompressed())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(addr, "bitsong1"))
+
+	track := testTrackManifest()
+	track["artists"] = []interface{}{map[string]interface{}{"name": "Artist", "address": addr}}
+
+	return key, addr, marshalManifest(t, track)
+}
+
+func TestSignature_Verify(t *testing.T) {
+	key, addr, raw := signedTrack(t)
+	m, err := ParseManifest(raw)
+	require.NoError(t, err)
+
+	sig := signADR036(t, key, addr, ManifestSignData("", 1, raw))
+	require.NoError(t, m.SetSignature(sig, "", 1))
+	require.Equal(t, sig, m.Signature)
+
+	// a single changed byte breaks the signature
+	tampered := append([]byte{}, raw...)
+	tampered[len(tampered)-2] = ' '
+	require.Error(t, sig.Verify(ManifestSignData("", 1, tampered)))
+}
+
+func TestSignature_Replay(t *testing.T) {
+	key, addr, raw := signedTrack(t)
+	sig := signADR036(t, key, addr, ManifestSignData("id", 2, raw))
+
+	// the signature of a version does not cover another version or manifest
+	for _, c := range []struct {
+		id      string
+		version int
+	}{{"id", 3}, {"other", 2}, {"", 1}} {
+		m, err := ParseManifest(raw)
+		require.NoError(t, err)
+		require.Equal(t, []string{"signature:invalid"}, fields(m.SetSignature(sig, c.id, c.version)))
+	}
+
+	m, err := ParseManifest(raw)
+	require.NoError(t, err)
+	require.NoError(t, m.SetSignature(sig, "id", 2))
+
+	// the store refuses it once the manifest moved past the signed version
+	bs := &BStudio{}
+	_, err = bs.putManifestVersion(&ManifestPointer{ID: "id", Version: 2}, m)
+	require.Equal(t, ErrStaleSignature, err)
+}
+
+func TestSignature_WrongSigner(t *testing.T) {
+	key, addr, raw := signedTrack(t)
+	other, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	otherAddr, err := PubKeyAddress(other.PubKey().SerializeCompressed())
+	require.NoError(t, err)
+
+	// the public key does not match the claimed address
+	err = signADR036(t, key, otherAddr, raw).Verify(raw)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), addr)
+
+	// a valid signature from someone else than the artists
+
+	m, err := ParseManifest(raw)
+	require.NoError(t, err)
+	err = m.SetSignature(signADR036(t, other, otherAddr, ManifestSignData("", 1, raw)), "", 1)
+	require.Equal(t, []string{"signature:not_artist"}, fields(err))
+	require.Nil(t, m.Signature)
+}
+
+func TestSignature_HighS(t *testing.T) {
+	key, addr, raw := signedTrack(t)
+	sig := signADR036(t, key, addr, raw)
+
+	// s and n - s both verify, only the lower one is accepted
+	bz, _ := base64.StdEncoding.DecodeString(sig.Signature)
+	s := new(big.Int).Sub(btcec.S256().N, new(big.Int).SetBytes(bz[32:])).Bytes()
+	high := make([]byte, 64)
+	copy(high, bz[:32])
+	copy(high[64-len(s):], s)
+	sig.Signature = base64.StdEncoding.EncodeToString(high)
+
+	err := sig.Verify(raw)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "lower S")
+}
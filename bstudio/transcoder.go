@@ -1,18 +1,39 @@
 package bstudio
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	uuid2 "github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const (
+	MediaAudio = "audio"
+	MediaVideo = "video"
+	MediaImage = "image"
 )
 
 type Transcoder struct {
-	bs     *BStudio
-	cid    string
-	mp3Cid string
+	bs        *BStudio
+	id        string
+	cid       string
+	mp3Cid    string
+	mediaType string
+	encrypted bool
+	apiKeyID  string
+	owner     string
+	ctx       context.Context
+	client    string
+	requestID string
+	cpu       time.Duration
+	artifacts []UsageArtifact
 }
 type TranscodeResult struct {
 	mp3Cid string
@@ -20,13 +41,166 @@ type TranscodeResult struct {
 }
 
 type TranscodeStatus struct {
+	ID         string `json:"id"`
 	Cid        string `json:"cid"`
+	Type       string `json:"type"`
 	HlsCid     string `json:"hls_cid"`
 	Percentage uint   `json:"percentage"`
+	KeyID      string `json:"key_id,omitempty"`
+	APIKeyID   string `json:"api_key_id,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Reason     string `json:"reason,omitempty"` // reason code when a limit was hit
+	Stage      string `json:"stage,omitempty"`  // stage that failed
+	RequestID  string `json:"request_id,omitempty"`
 }
 
 func NewTranscoder(bs *BStudio, cid string) *Transcoder {
-	return &Transcoder{bs: bs, cid: cid}
+	return &Transcoder{bs: bs, id: uuid2.New().String(), cid: cid, mediaType: MediaAudio}
+}
+
+func NewVideoTranscoder(bs *BStudio, cid string) *Transcoder {
+	return &Transcoder{bs: bs, id: uuid2.New().String(), cid: cid, mediaType: MediaVideo}
+}
+
+// ID returns the id of the job, each upload has its own even when the content is the same.
+func (t *Transcoder) ID() string {
+	return t.id
+}
+
+// SetEncrypted enables AES-128 encryption of the HLS segments with a per-track content key.
+func (t *Transcoder) SetEncrypted(encrypted bool) {
+	t.encrypted = encrypted
+}
+
+// SetPrincipal records the API key or the wallet address the job was created by.
+func (t *Transcoder) SetPrincipal(p *Principal) {
+	if p == nil {
+		return
+	}
+	t.apiKeyID = p.APIKeyID
+	t.owner = p.Address
+}
+
+// SetClient sets who the job is counted against in the quotas and the usage.
+func (t *Transcoder) SetClient(client string) {
+	t.client = client
+}
+
+// SetRequestID sets the id of the upload request, carried by every log line of the job.
+func (t *Transcoder) SetRequestID(id string) {
+	t.requestID = id
+}
+
+// log returns the logger of the job.
+func (t *Transcoder) log() *zerolog.Logger {
+	l := log.With().Str("job", t.id).Str("cid", t.cid).Str("type", t.mediaType)
+	if t.requestID != "" {
+		l = l.Str("request_id", t.requestID)
+	}
+	logger := l.Logger()
+	return &logger
+}
+
+// runFFmpeg runs the stage with the executor of the studio and counts its cpu time for the usage.
+func (t *Transcoder) runFFmpeg(stage string, args ...string) error {
+	res, err := t.bs.executor().Run(t.ctx, stage, "ffmpeg", args...)
+	if eerr, ok := err.(*ExecError); ok {
+		t.cpu += eerr.CPU
+	}
+	if err != nil {
+		return err
+	}
+	t.cpu += res.CPU
+	t.log().Debug().Str("stage", stage).Str("stderr", stderrTail(string(res.Stderr))).Msg("ffmpeg done")
+
+	return nil
+}
+
+// fail records why the job failed in its status.
+func (t *Transcoder) fail(err error) {
+	event := t.log().Error().Err(err).Str("reason", ExecReason(err))
+	var eerr *ExecError
+	if errors.As(err, &eerr) {
+		// the tail of the stderr tells why ffmpeg failed
+		event = event.Str("stage", eerr.Stage).Str("stderr", stderrTail(eerr.Stderr))
+	}
+	event.Msg("transcoding failed")
+
+	reason := ExecReason(err)
+	if reason == "" {
+		reason = "error"
+	}
+	t.bs.Metrics.jobFailed(t.mediaType, reason)
+
+	serr := t.editStatus(func(status *TranscodeStatus) {
+		status.Error = err.Error()
+		status.Reason = ExecReason(err)
+		if eerr != nil {
+			status.Stage = eerr.Stage
+		}
+	})
+	if serr != nil {
+		t.log().Error().Err(serr).Msg("cannot save transcode failure")
+	}
+}
+
+// addArtifact records an output of the job, path is its local file or directory.
+func (t *Transcoder) addArtifact(name, cid, path string) {
+	size, err := pathSize(path)
+	if err != nil {
+		t.log().Error().Err(err).Str("path", path).Msg("cannot measure artifact")
+	}
+	t.artifacts = append(t.artifacts, UsageArtifact{Name: name, Cid: cid, Bytes: size})
+}
+
+// recordUsage records the usage event of the finished job, succeeded or failed. Its minutes were counted
+// in the quotas when the upload was accepted, from the probed duration.
+func (t *Transcoder) recordUsage(status string) {
+	tmpPath := filepath.Join(TmpDir, t.cid)
+
+	e := &UsageEvent{
+		Client:     t.client,
+		APIKeyID:   t.apiKeyID,
+		Address:    t.owner,
+		Job:        t.mediaType,
+		Status:     status,
+		Cid:        t.cid,
+		CPUSeconds: t.cpu.Seconds(),
+	}
+	if fi, err := os.Stat(tmpPath); err == nil {
+		e.InputBytes = fi.Size()
+	}
+	if ffprobe, err := t.bs.executor().Probe(t.ctx, tmpPath); err == nil {
+		e.Minutes = float64(ffprobe.GetDuration()) / 60
+	} else {
+		t.log().Error().Err(err).Msg("cannot probe duration for the usage")
+	}
+
+	// the original and every artifact added before the end are pinned
+	for _, cid := range append([]string{t.cid}, artifactCids(t.artifacts)...) {
+		size, err := t.bs.PinnedSize(cid)
+		if err != nil {
+			t.log().Error().Err(err).Str("artifact", cid).Msg("cannot measure pinned size")
+			continue
+		}
+		e.PinnedBytes += size
+	}
+	for _, a := range t.artifacts {
+		e.AddArtifact(a.Name, a.Cid, a.Bytes)
+	}
+
+	if err := t.bs.RecordUsage(e); err != nil {
+		t.log().Error().Err(err).Msg("cannot record usage")
+	}
+}
+
+func artifactCids(artifacts []UsageArtifact) []string {
+	cids := make([]string, len(artifacts))
+	for i, a := range artifacts {
+		cids[i] = a.Cid
+	}
+	return cids
 }
 
 func (t *Transcoder) GetCidDuration() (float32, error) {
@@ -35,82 +209,134 @@ func (t *Transcoder) GetCidDuration() (float32, error) {
 		return 0, err
 	}
 
-	ffprobe, err := NewFFProbe(*tmpPath)
+	ffprobe, err := t.bs.executor().Probe(context.Background(), *tmpPath)
 	if err != nil {
 		return 0, err
 	}
 
 	return ffprobe.GetDuration(), err
 }
-func (t *Transcoder) Transcode() (*TranscodeResult, error) {
-	// create record into badger
-	data := TranscodeStatus{
-		Cid:        t.cid,
-		Percentage: 0,
+
+// Transcode runs the job, ctx cancels the running stage. A failure is recorded in the job status.
+func (t *Transcoder) Transcode(ctx context.Context) (*TranscodeResult, error) {
+	t.ctx = ctx
+
+	res, err := t.transcode()
+	if err != nil && ctx.Err() != nil {
+		// interrupted by the shutdown, the job stays stored to be requeued
+		t.log().Warn().Err(err).Msg("transcoding interrupted, the job is requeued at the next start")
+		if err := t.updateStatus(0, ""); err != nil {
+			t.log().Error().Err(err).Msg("cannot reset transcode status")
+		}
+		return &TranscodeResult{}, err
+	}
+
+	if derr := t.bs.Ds.Delete(jobKey(t.id)); derr != nil {
+		t.log().Error().Err(derr).Msg("cannot delete finished job")
 	}
-	dataBz, err := json.Marshal(data)
 	if err != nil {
+		t.fail(err)
+		t.recordUsage(UsageFailed)
 		return &TranscodeResult{}, err
 	}
+	t.recordUsage(UsageSucceeded)
 
-	// save initial status
-	if err = t.bs.Ds.SetAndCommit([]byte(t.cid), dataBz); err != nil {
-		panic(err)
-		return &TranscodeResult{}, err
+	return res, nil
+}
+
+// initialStatus is the status of the job before it runs.
+func (t *Transcoder) initialStatus() TranscodeStatus {
+	return TranscodeStatus{
+		ID:        t.id,
+		Cid:       t.cid,
+		Type:      t.mediaType,
+		APIKeyID:  t.apiKeyID,
+		Owner:     t.owner,
+		RequestID: t.requestID,
+	}
+}
+
+func (t *Transcoder) transcode() (*TranscodeResult, error) {
+	// a requeued job starts over
+	dataBz, err := json.Marshal(t.initialStatus())
+	if err != nil {
+		return nil, err
+	}
+	if err = t.bs.Ds.SetAndCommit(jobStatusKey(t.id), dataBz); err != nil {
+		return nil, err
+	}
+
+	if t.mediaType == MediaVideo {
+		cid, err := t.transcodeVideoToHls()
+		if err != nil {
+			return nil, err
+		}
+
+		return &TranscodeResult{hlsCid: cid}, nil
 	}
 
-	// switch type transcoding audio/video
-	// case:
 	// transcode to mp3
 	cid, err := t.transcodeCidToMp3()
 	if err != nil {
-		return &TranscodeResult{}, err
+		return nil, err
 	}
 	t.mp3Cid = cid
 
 	// generate hls
-	cid, err = t.transcodeMp3ToHls()
+	cid, err = t.transcodeToHls()
 	if err != nil {
-		return &TranscodeResult{}, err
+		return nil, err
 	}
 
 	return &TranscodeResult{
 		mp3Cid: t.mp3Cid,
 		hlsCid: cid,
-	}, err
+	}, nil
 }
 
 func (t *Transcoder) updateStatus(percentage uint, hlsCid string) error {
-	dataBz, err := t.bs.Ds.Get([]byte(t.cid))
+	return t.editStatus(func(status *TranscodeStatus) {
+		status.Percentage = percentage
+		if hlsCid != "" {
+			status.HlsCid = hlsCid
+		}
+	})
+}
+
+// editStatus applies edit to the stored status of the job.
+func (t *Transcoder) editStatus(edit func(status *TranscodeStatus)) error {
+	dataBz, err := t.bs.Ds.Get(jobStatusKey(t.id))
 	if err != nil {
 		return err
 	}
 
-	var status TranscodeStatus
-	if err := json.Unmarshal(dataBz, &status); err != nil {
-		return err
-	}
-	status.Percentage = percentage
-	if hlsCid != "" {
-		status.HlsCid = hlsCid
+	status := t.initialStatus()
+	if len(dataBz) > 0 {
+		if err := json.Unmarshal(dataBz, &status); err != nil {
+			return err
+		}
 	}
+	edit(&status)
 
 	dataBz, err = json.Marshal(status)
 	if err != nil {
 		return err
 	}
 
-	err = t.bs.Ds.SetAndCommit([]byte(t.cid), dataBz)
-	if err != nil {
-		return err
-	}
+	return t.bs.Ds.SetAndCommit(jobStatusKey(t.id), dataBz)
+}
 
-	return err
+// stage runs f as a stage of the job, its duration is observed whatever the outcome.
+func (t *Transcoder) stage(name string, f func() error) (err error) {
+	defer t.bs.Metrics.observeStage(t.mediaType, name, time.Now(), &err)
+	return f()
 }
 
 func (t *Transcoder) getCid() (*string, error) {
-	tmpPath := fmt.Sprintf("/tmp/%s", t.cid)
-	err := t.bs.Get(t.cid, tmpPath)
+	tmpPath := filepath.Join(TmpDir, t.cid)
+	err := t.stage(JobStageDownload, func() error {
+		return t.bs.Get(t.cid, tmpPath)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -129,28 +355,21 @@ func (t *Transcoder) transcodeCidToMp3() (string, error) {
 
 	outTmpPath := *tmpPath + ".mp3"
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i",
-		*tmpPath,
-		"-acodec",
-		"libmp3lame",
-		"-ar",
-		"48000",
-		"-b:a",
-		"320k",
-		"-y",
-		outTmpPath,
-	)
-
-	var ffmpegStdErr bytes.Buffer
-	cmd.Stderr = &ffmpegStdErr
-
-	err = cmd.Run()
+	err = t.stage(JobStageEncode, func() error {
+		return t.runFFmpeg(StageMp3,
+			"-i",
+			*tmpPath,
+			"-acodec",
+			"libmp3lame",
+			"-ar",
+			"48000",
+			"-b:a",
+			"320k",
+			"-y",
+			outTmpPath,
+		)
+	})
 	if err != nil {
-		//log.Print("FFMpeg error ", err)
-		//log.Print(string(ffmpegStdErr.Bytes()))
-
 		return "", err
 	}
 
@@ -165,14 +384,25 @@ func (t *Transcoder) transcodeCidToMp3() (string, error) {
 		panic(err)
 	}
 
-	return t.bs.Add(f)
+	var cid string
+	err = t.stage(JobStagePin, func() (err error) {
+		cid, err = t.bs.Add(f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	t.addArtifact("mp3", cid, outTmpPath)
+
+	return cid, nil
 }
-func (t *Transcoder) transcodeMp3ToHls() (string, error) {
-	tmpMp3Path := fmt.Sprintf("/tmp/%s.mp3", t.cid)
+func (t *Transcoder) transcodeToHls() (string, error) {
+	// renditions are encoded from the original upload, not from the lossy mp3
+	tmpPath := filepath.Join(TmpDir, t.cid)
 	// TODO: check if file exist
 
 	// create tmp hls dir
-	tmpHlsPath := fmt.Sprintf("/tmp/%s-hls", t.cid)
+	tmpHlsPath := filepath.Join(TmpDir, t.cid+"-hls")
 	if _, err := os.Stat(tmpHlsPath); os.IsNotExist(err) {
 		err = os.MkdirAll(tmpHlsPath, 0755)
 		if err != nil {
@@ -180,45 +410,66 @@ func (t *Transcoder) transcodeMp3ToHls() (string, error) {
 		}
 	}
 
-	segmentFilePattern := tmpHlsPath + "/segment%03d.ts"
-	m3u8FileName := tmpHlsPath + "/playlist.m3u8"
-
 	if err := t.updateStatus(40, ""); err != nil {
 		panic(err)
 	}
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", tmpMp3Path,
-		"-ar", "48000", // sample rate
-		"-b:a", "320k", // bitrate
-		"-hls_time", "5", // 5s for each segment
-		"-hls_segment_type", "mpegts", // hls segment type: Output segment files in MPEG-2 Transport Stream format. This is compatible with all HLS versions.
-		"-hls_list_size", "0", //  If set to 0 the list file will contain all the segments
-		//"-hls_base_url", "segments/",
-		"-hls_segment_filename", segmentFilePattern,
-		"-vn", m3u8FileName,
-	)
-
-	var ffmpegStdErr bytes.Buffer
-	cmd.Stderr = &ffmpegStdErr
+	var profiles []HlsProfile
+	for _, p := range t.bs.HlsProfiles {
+		if !t.bs.HasEncoder(p.Encoder) {
+			t.log().Warn().Str("profile", p.Name).Str("encoder", p.Encoder).Msg("encoder not available, skipping hls rendition")
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	if len(profiles) == 0 {
+		return "", fmt.Errorf("no hls profile can be encoded with the local ffmpeg")
+	}
 
-	err := cmd.Run()
+	opts, cleanup, err := t.encryptionOpts()
 	if err != nil {
-		//log.Print("FFMpeg error ", err)
-		//log.Print(string(ffmpegStdErr.Bytes()))
-
 		return "", err
 	}
+	defer cleanup()
+
+	err = t.stage(JobStageHls, func() error {
+		variants := make([]*hlsVariant, 0, len(profiles))
+		for i, p := range profiles {
+			dir := filepath.Join(tmpHlsPath, p.Name)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+
+			if err := t.runFFmpeg(StageHls, p.args(tmpPath, dir, opts...)...); err != nil {
+				return err
+			}
+
+			v, err := measureVariant(dir, p.Name, p.Codecs)
+			if err != nil {
+				return err
+			}
+			variants = append(variants, v)
+
+			if err := t.updateStatus(uint(40+40*(i+1)/len(profiles)), ""); err != nil {
+				panic(err)
+			}
+		}
 
-	if err := t.updateStatus(80, ""); err != nil {
-		panic(err)
+		return writeMasterPlaylist(tmpHlsPath, variants)
+	})
+	if err != nil {
+		return "", err
 	}
 
-	hlsCid, err := t.bs.AddDir(tmpHlsPath)
+	var hlsCid string
+	err = t.stage(JobStagePin, func() (err error) {
+		hlsCid, err = t.bs.AddDir(tmpHlsPath)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
+	t.addArtifact("hls", hlsCid, tmpHlsPath)
 
 	if err := t.updateStatus(100, hlsCid); err != nil {
 		panic(err)
@@ -226,3 +477,61 @@ func (t *Transcoder) transcodeMp3ToHls() (string, error) {
 
 	return hlsCid, err
 }
+
+// encryptionOpts returns the ffmpeg hls options encrypting the segments when enabled,
+// and a cleanup func removing the plain key from disk.
+func (t *Transcoder) encryptionOpts() ([]string, func(), error) {
+	if !t.encrypted {
+		return nil, func() {}, nil
+	}
+
+	ck, keyInfoPath, err := t.prepareContentKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(filepath.Dir(keyInfoPath)) }
+
+	if err := t.setKeyID(ck.ID); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return []string{"-hls_key_info_file", keyInfoPath}, cleanup, nil
+}
+
+// prepareContentKey generates the track content key and writes the ffmpeg key info file.
+// Both files live outside of the hls directory so the key is never published to ipfs.
+func (t *Transcoder) prepareContentKey() (*ContentKey, string, error) {
+	if t.bs.Keys == nil {
+		return nil, "", fmt.Errorf("hls encryption is not configured")
+	}
+
+	ck, key, err := t.bs.Keys.Generate(t.cid)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, err := ioutil.TempDir("", t.cid+"-key")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyPath := filepath.Join(dir, "content.key")
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return nil, "", err
+	}
+
+	keyInfo := fmt.Sprintf("%s%s\n%s\n%s\n", t.bs.KeyURL, ck.ID, keyPath, ck.IV)
+	keyInfoPath := filepath.Join(dir, "key.info")
+	if err := ioutil.WriteFile(keyInfoPath, []byte(keyInfo), 0600); err != nil {
+		return nil, "", err
+	}
+
+	return ck, keyInfoPath, nil
+}
+
+func (t *Transcoder) setKeyID(keyID string) error {
+	return t.editStatus(func(status *TranscodeStatus) {
+		status.KeyID = keyID
+	})
+}
@@ -1,6 +1,13 @@
 package bstudio
 
-import "mime/multipart"
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+)
 
 type Upload struct {
 	header *multipart.FileHeader
@@ -27,9 +34,101 @@ func (u *Upload) IsAudio() bool {
 		contentType == "application/octet-stream" ||
 		contentType == "audio/mpeg"
 }
+
+// videoFormats are the ffprobe demuxers of the containers accepted as video uploads.
+var videoFormats = map[string]bool{
+	"mov,mp4,m4a,3gp,3g2,mj2": true, // mp4 and quicktime
+	"matroska,webm":           true,
+}
+
+// IsVideo probes the upload with ffprobe, it must be an mp4, quicktime, matroska or webm container
+// with a video stream. The declared content type is not trusted.
+func (u *Upload) IsVideo(ctx context.Context) bool {
+	path, cleanup, err := u.localPath()
+	if err != nil {
+		return false
+	}
+	defer cleanup()
+
+	probe, err := u.bs.executor().Probe(ctx, path)
+	if err != nil {
+		return false
+	}
+
+	return isVideoProbe(probe)
+}
+
+// Minutes probes the duration of the upload, the transcoding quota is reserved from it before the job runs.
+// The probe stops when ctx is done, e.g. when the client hangs up.
+func (u *Upload) Minutes(ctx context.Context) (float64, error) {
+	path, cleanup, err := u.localPath()
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	probe, err := u.bs.executor().Probe(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(probe.GetDuration()) / 60, nil
+}
+
+func isVideoProbe(p *ffProbe) bool {
+	return videoFormats[p.Format.Format] && p.VideoStream() != nil
+}
+
+// localPath returns a path to the content of the upload: the multipart file when it was buffered on disk,
+// else a temporary copy removed by cleanup.
+func (u *Upload) localPath() (string, func(), error) {
+	if f, ok := u.file.(*os.File); ok {
+		return f.Name(), func() {}, nil
+	}
+
+	tmp, err := ioutil.TempFile(TmpDir, "upload-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	if _, err := io.Copy(tmp, u.file); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return tmp.Name(), cleanup, nil
+}
+
+// DetectContentType sniffs the content type from the first bytes of the file,
+// the declared header is not trusted.
+func (u *Upload) DetectContentType() (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(u.file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(buf[:n]), nil
+}
 func (u *Upload) IsImage() bool {
-	contentType := u.GetContentType()
-	return contentType == "image/jpeg"
+	contentType, err := u.DetectContentType()
+	if err != nil {
+		return false
+	}
+
+	_, ok := imageDecoders[contentType]
+	return ok
 }
 
 func (u *Upload) StoreOriginal() (string, error) {
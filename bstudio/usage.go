@@ -0,0 +1,241 @@
+package bstudio
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	usagePrefix = "usage/"
+
+	UsageGroupClient = "client"
+	UsageGroupDay    = "day"
+	UsageGroupMonth  = "month"
+
+	UsageFormatCSV  = "csv"
+	UsageFormatJSON = "json"
+
+	UsageSucceeded = "succeeded"
+	UsageFailed    = "failed"
+)
+
+// UsageArtifact is an output of a job.
+type UsageArtifact struct {
+	Name  string `json:"name"`
+	Cid   string `json:"cid"`
+	Bytes int64  `json:"bytes"`
+}
+
+// UsageEvent is what a job cost, for billing.
+type UsageEvent struct {
+	ID          string          `json:"id"`
+	Time        time.Time       `json:"time"`
+	Client      string          `json:"client"`
+	APIKeyID    string          `json:"api_key_id,omitempty"`
+	Address     string          `json:"address,omitempty"`
+	Job         string          `json:"job"`
+	Status      string          `json:"status"` // succeeded or failed, a failed job is billed what it cost
+	Cid         string          `json:"cid"`
+	InputBytes  int64           `json:"input_bytes"`
+	OutputBytes int64           `json:"output_bytes"`
+	Artifacts   []UsageArtifact `json:"artifacts"`
+	Minutes     float64         `json:"minutes"`     // duration of the transcoded audio or video
+	CPUSeconds  float64         `json:"cpu_seconds"` // user and system time of the ffmpeg processes and of the image rendering
+	PinnedBytes int64           `json:"pinned_bytes"`
+}
+
+// AddArtifact appends an output to the event and counts its bytes.
+func (e *UsageEvent) AddArtifact(name, cid string, bytes int64) {
+	e.Artifacts = append(e.Artifacts, UsageArtifact{Name: name, Cid: cid, Bytes: bytes})
+	e.OutputBytes += bytes
+}
+
+func usageKey(t time.Time, id string) []byte {
+	return []byte(fmt.Sprintf("%s%020d/%s", usagePrefix, t.UnixNano(), id))
+}
+
+// RecordUsage stores the event, its id and time are set when empty.
+func (bs *BStudio) RecordUsage(e *UsageEvent) error {
+	if e.ID == "" {
+		e.ID = uuid2.New().String()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	bz, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return bs.Ds.SetAndCommit(usageKey(e.Time, e.ID), bz)
+}
+
+// ListUsage returns the events from from included to to excluded, of client only when not empty.
+func (bs *BStudio) ListUsage(from, to time.Time, client string) ([]*UsageEvent, error) {
+	var events []*UsageEvent
+
+	err := bs.Ds.IterateRange(usageKey(from, ""), usageKey(to, ""), func(key, val []byte) error {
+		var e UsageEvent
+		if err := json.Unmarshal(val, &e); err != nil {
+			return err
+		}
+		if client == "" || e.Client == client {
+			events = append(events, &e)
+		}
+		return nil
+	})
+
+	return events, err
+}
+
+// UsageReportRow sums the events of a client, in a period when grouped by day or month.
+type UsageReportRow struct {
+	Client      string  `json:"client"`
+	Period      string  `json:"period,omitempty"`
+	Jobs        int64   `json:"jobs"`
+	FailedJobs  int64   `json:"failed_jobs"`
+	InputBytes  int64   `json:"input_bytes"`
+	OutputBytes int64   `json:"output_bytes"`
+	Minutes     float64 `json:"minutes"`
+	CPUSeconds  float64 `json:"cpu_seconds"`
+	PinnedBytes int64   `json:"pinned_bytes"`
+}
+
+// AggregateUsage sums events per client, and per UTC day or month for those groups.
+func AggregateUsage(events []*UsageEvent, group string) ([]*UsageReportRow, error) {
+	var layout string
+	switch group {
+	case UsageGroupClient:
+	case UsageGroupDay:
+		layout = "2006-01-02"
+	case UsageGroupMonth:
+		layout = "2006-01"
+	default:
+		return nil, fmt.Errorf("unknown usage group %s, expected client, day or month", group)
+	}
+
+	rows := make(map[string]*UsageReportRow)
+	for _, e := range events {
+		var period string
+		if layout != "" {
+			period = e.Time.UTC().Format(layout)
+		}
+
+		row, ok := rows[e.Client+"|"+period]
+		if !ok {
+			row = &UsageReportRow{Client: e.Client, Period: period}
+			rows[e.Client+"|"+period] = row
+		}
+
+		row.Jobs++
+		if e.Status == UsageFailed {
+			row.FailedJobs++
+		}
+		row.InputBytes += e.InputBytes
+		row.OutputBytes += e.OutputBytes
+		row.Minutes += e.Minutes
+		row.CPUSeconds += e.CPUSeconds
+		row.PinnedBytes += e.PinnedBytes
+	}
+
+	res := make([]*UsageReportRow, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, row)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Client != res[j].Client {
+			return res[i].Client < res[j].Client
+		}
+		return res[i].Period < res[j].Period
+	})
+
+	return res, nil
+}
+
+var usageCSVHeader = []string{
+	"time", "id", "client", "api_key_id", "address", "job", "cid",
+	"input_bytes", "output_bytes", "minutes", "cpu_seconds", "pinned_bytes", "status",
+}
+
+// WriteUsage writes the events as CSV, one line per event without the artifacts, or as a JSON array.
+func WriteUsage(w io.Writer, events []*UsageEvent, format string) error {
+	switch format {
+	case UsageFormatJSON:
+		if events == nil {
+			events = []*UsageEvent{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(events)
+	case UsageFormatCSV:
+	default:
+		return fmt.Errorf("unknown usage format %s, expected csv or json", format)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(usageCSVHeader); err != nil {
+		return err
+	}
+	for _, e := range events {
+		err := cw.Write([]string{
+			e.Time.UTC().Format(time.RFC3339),
+			e.ID,
+			e.Client,
+			e.APIKeyID,
+			e.Address,
+			e.Job,
+			e.Cid,
+			strconv.FormatInt(e.InputBytes, 10),
+			strconv.FormatInt(e.OutputBytes, 10),
+			strconv.FormatFloat(e.Minutes, 'f', 3, 64),
+			strconv.FormatFloat(e.CPUSeconds, 'f', 3, 64),
+			strconv.FormatInt(e.PinnedBytes, 10),
+			e.Status,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// ParseUsageTime reads a RFC 3339 time or a 2006-01-02 date, at midnight UTC.
+func ParseUsageTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %s, expected 2006-01-02 or RFC 3339", s)
+	}
+
+	return t, nil
+}
+
+// pathSize returns the size of a file or the total size of the files in a directory.
+func pathSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
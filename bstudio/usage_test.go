@@ -0,0 +1,138 @@
+package bstudio
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUsageEvents() []*UsageEvent {
+	day := time.Date(2020, 9, 30, 10, 0, 0, 0, time.UTC)
+
+	a := &UsageEvent{Time: day, Client: "key:a", APIKeyID: "a", Job: MediaAudio, Status: UsageSucceeded, Cid: "QmA", InputBytes: 100, Minutes: 3, CPUSeconds: 12.5, PinnedBytes: 400}
+	a.AddArtifact("mp3", "QmMp3", 50)
+	a.AddArtifact("hls", "QmHls", 250)
+
+	return []*UsageEvent{
+		a,
+		{Time: day.Add(time.Hour), Client: "key:a", Job: MediaImage, Cid: "QmI", InputBytes: 10, PinnedBytes: 30},
+		{Time: day.Add(24 * time.Hour), Client: "key:a", Job: MediaVideo, Status: UsageFailed, Cid: "QmV", InputBytes: 1000, Minutes: 2},
+		{Time: day.Add(2 * time.Hour), Client: "address:bitsong1x", Address: "bitsong1x", Job: MediaAudio, Cid: "QmB", InputBytes: 5},
+	}
+}
+
+func TestUsage_RecordAndList(t *testing.T) {
+	ds, cleanup := mockDs(t)
+	defer cleanup()
+	bs := &BStudio{Ds: ds}
+
+	for _, e := range testUsageEvents() {
+		require.NoError(t, bs.RecordUsage(e))
+		require.NotEmpty(t, e.ID)
+	}
+
+	from := time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC)
+	events, err := bs.ListUsage(from, from.Add(24*time.Hour), "")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	// in time order
+	require.Equal(t, "QmA", events[0].Cid)
+	require.Equal(t, "QmI", events[1].Cid)
+	require.Equal(t, "QmB", events[2].Cid)
+	require.Equal(t, int64(300), events[0].OutputBytes)
+	require.Len(t, events[0].Artifacts, 2)
+
+	// the end is excluded
+	events, err = bs.ListUsage(from, time.Date(2020, 9, 30, 10, 0, 0, 0, time.UTC), "")
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	events, err = bs.ListUsage(from, from.Add(48*time.Hour), "key:a")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+}
+
+func TestAggregateUsage(t *testing.T) {
+	rows, err := AggregateUsage(testUsageEvents(), UsageGroupClient)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, &UsageReportRow{Client: "address:bitsong1x", Jobs: 1, InputBytes: 5}, rows[0])
+	require.Equal(t, &UsageReportRow{Client: "key:a", Jobs: 3, FailedJobs: 1, InputBytes: 1110, OutputBytes: 300, Minutes: 5, CPUSeconds: 12.5, PinnedBytes: 430}, rows[1])
+
+	rows, err = AggregateUsage(testUsageEvents(), UsageGroupDay)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, "key:a", rows[1].Client)
+	require.Equal(t, "2020-09-30", rows[1].Period)
+	require.Equal(t, int64(2), rows[1].Jobs)
+	require.Equal(t, "2020-10-01", rows[2].Period)
+
+	rows, err = AggregateUsage(testUsageEvents(), UsageGroupMonth)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+
+	_, err = AggregateUsage(nil, "year")
+	require.Error(t, err)
+}
+
+func TestWriteUsage(t *testing.T) {
+	events := testUsageEvents()[:1]
+	events[0].ID = "1"
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteUsage(&buf, events, UsageFormatCSV))
+	require.Equal(t, strings.Join([]string{
+		"time,id,client,api_key_id,address,job,cid,input_bytes,output_bytes,minutes,cpu_seconds,pinned_bytes,status",
+		"2020-09-30T10:00:00Z,1,key:a,a,,audio,QmA,100,300,3.000,12.500,400,succeeded",
+		"",
+	}, "\n"), buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteUsage(&buf, events, UsageFormatJSON))
+	var decoded []*UsageEvent
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, events[0].Artifacts, decoded[0].Artifacts)
+
+	buf.Reset()
+	require.NoError(t, WriteUsage(&buf, nil, UsageFormatJSON))
+	require.Equal(t, "[]\n", buf.String())
+
+	require.Error(t, WriteUsage(&buf, nil, "xml"))
+}
+
+func TestParseUsageTime(t *testing.T) {
+	ts, err := ParseUsageTime("2020-09-01")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), ts)
+
+	ts, err = ParseUsageTime("2020-09-01T12:00:00+02:00")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC), ts.UTC())
+
+	_, err = ParseUsageTime("01/09/2020")
+	require.Error(t, err)
+}
+
+func TestPathSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bstudio-usage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "360p"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "master.m3u8"), make([]byte, 10), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "360p", "0.ts"), make([]byte, 32), 0644))
+
+	size, err := pathSize(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), size)
+
+	size, err = pathSize(filepath.Join(dir, "master.m3u8"))
+	require.NoError(t, err)
+	require.Equal(t, int64(10), size)
+}
@@ -0,0 +1,42 @@
+package bstudio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError describes why a single field of an input was rejected.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors collects every problem of an input so that they are reported at once.
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+func (v *ValidationErrors) Add(field, code, format string, args ...interface{}) {
+	*v = append(*v, ValidationError{
+		Field:   field,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Err returns nil when no error was collected.
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	return v
+}
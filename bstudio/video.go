@@ -0,0 +1,289 @@
+package bstudio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	posterFileName     = "poster.jpg"
+	spriteFileName     = "thumbnails.jpg"
+	spriteVttFileName  = "thumbnails.vtt"
+	spriteColumns      = 10
+	spriteRows         = 10
+	spriteThumbWidth   = 160
+	defaultFrameRate   = 25
+	videoAudioCodecs   = "mp4a.40.2"
+	videoAudioBitrate  = 128
+	maxPosterHeight    = 1080
+	posterTimeFraction = 0.1
+)
+
+// VideoProfile describes a single H.264/AAC rendition of the video HLS ladder.
+type VideoProfile struct {
+	Name         string `json:"name" yaml:"name"`
+	Height       int    `json:"height" yaml:"height"`
+	VideoBitrate uint   `json:"video_bitrate" yaml:"video_bitrate"` // kbit/s
+	MaxRate      uint   `json:"max_rate" yaml:"max_rate"`           // kbit/s
+	H264Profile  string `json:"h264_profile" yaml:"h264_profile"`
+	H264Level    string `json:"h264_level" yaml:"h264_level"`
+	Codecs       string `json:"codecs" yaml:"codecs"` // RFC 6381 codec string of the video track
+}
+
+// DefaultVideoProfiles is the video ladder; rungs taller than the source are skipped.
+var DefaultVideoProfiles = []VideoProfile{
+	{Name: "1080p", Height: 1080, VideoBitrate: 5000, MaxRate: 5350, H264Profile: "high", H264Level: "4.0", Codecs: "avc1.640028"},
+	{Name: "720p", Height: 720, VideoBitrate: 2800, MaxRate: 2996, H264Profile: "main", H264Level: "3.1", Codecs: "avc1.4d401f"},
+	{Name: "480p", Height: 480, VideoBitrate: 1400, MaxRate: 1498, H264Profile: "main", H264Level: "3.0", Codecs: "avc1.4d401e"},
+	{Name: "360p", Height: 360, VideoBitrate: 800, MaxRate: 856, H264Profile: "baseline", H264Level: "3.0", Codecs: "avc1.42e01e"},
+}
+
+// args returns the ffmpeg arguments encoding src into a CMAF rendition inside dir.
+// Keyframes are forced on every segment boundary so all the renditions switch cleanly.
+func (p VideoProfile) args(src, dir string, fps float64, hasAudio bool, opts ...string) []string {
+	gop := strconv.Itoa(int(math.Round(fps * hlsSegmentDuration)))
+
+	args := []string{
+		"-i", src,
+		"-map", "0:v:0",
+	}
+	if hasAudio {
+		args = append(args, "-map", "0:a:0")
+	}
+
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-profile:v", p.H264Profile,
+		"-level:v", p.H264Level,
+		"-pix_fmt", "yuv420p",
+		"-vf", fmt.Sprintf("scale=-2:%d", p.Height),
+		"-b:v", fmt.Sprintf("%dk", p.VideoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", p.MaxRate),
+		"-bufsize", fmt.Sprintf("%dk", 2*p.MaxRate),
+		"-g", gop,
+		"-keyint_min", gop,
+		"-sc_threshold", "0",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentDuration),
+	)
+	if hasAudio {
+		args = append(args,
+			"-c:a", "aac",
+			"-profile:a", "aac_low",
+			"-b:a", fmt.Sprintf("%dk", videoAudioBitrate),
+			"-ar", "48000",
+			"-ac", "2",
+		)
+	}
+	args = append(args, opts...)
+
+	return append(args, hlsOutputArgs(dir)...)
+}
+
+func (p VideoProfile) codecs(hasAudio bool) string {
+	if hasAudio {
+		return p.Codecs + "," + videoAudioCodecs
+	}
+
+	return p.Codecs
+}
+
+// selectVideoProfiles returns the rungs not taller than the source, never upscaling
+// except for the smallest rung which is always kept.
+func selectVideoProfiles(profiles []VideoProfile, height int) []VideoProfile {
+	var selected []VideoProfile
+	smallest := -1
+
+	for i, p := range profiles {
+		if p.Height <= height {
+			selected = append(selected, p)
+		}
+		if smallest < 0 || p.Height < profiles[smallest].Height {
+			smallest = i
+		}
+	}
+	if len(selected) == 0 && smallest >= 0 {
+		selected = append(selected, profiles[smallest])
+	}
+
+	return selected
+}
+
+// scaledWidth mirrors the ffmpeg scale=-2:height filter.
+func scaledWidth(width, height, targetHeight int) int {
+	if height == 0 {
+		return 0
+	}
+
+	return int(math.Round(float64(width)*float64(targetHeight)/float64(height)/2)) * 2
+}
+
+// spriteInterval returns the seconds between two thumbnails so that the whole video fits one sprite.
+func spriteInterval(duration float64) float64 {
+	return math.Max(1, math.Ceil(duration/(spriteColumns*spriteRows)))
+}
+
+// thumbnailsVTT renders the WebVTT track pointing every cue to its tile of the sprite.
+func thumbnailsVTT(duration, interval float64, width, height int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	count := int(math.Min(math.Ceil(duration/interval), spriteColumns*spriteRows))
+	for i := 0; i < count; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, duration)
+		x := (i % spriteColumns) * width
+		y := (i / spriteColumns) * height
+
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTimestamp(start), vttTimestamp(end), spriteFileName, x, y, width, height)
+	}
+
+	return b.String()
+}
+
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func (t *Transcoder) transcodeVideoToHls() (string, error) {
+	tmpPath, err := t.getCid()
+	if err != nil {
+		return "", err
+	}
+
+	if err := t.updateStatus(5, ""); err != nil {
+		panic(err)
+	}
+
+	probe, err := t.bs.executor().Probe(t.ctx, *tmpPath)
+	if err != nil {
+		return "", err
+	}
+	video := probe.VideoStream()
+	if video == nil {
+		return "", fmt.Errorf("%s has no video stream", t.cid)
+	}
+	fps := video.FrameRate()
+	if fps <= 0 {
+		fps = defaultFrameRate
+	}
+	hasAudio := probe.HasAudio()
+	duration := float64(probe.GetDuration())
+
+	// create tmp hls dir
+	tmpHlsPath := filepath.Join(TmpDir, t.cid+"-hls")
+	if err := os.MkdirAll(tmpHlsPath, 0755); err != nil {
+		return "", err
+	}
+
+	opts, cleanup, err := t.encryptionOpts()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	if err := t.updateStatus(10, ""); err != nil {
+		panic(err)
+	}
+
+	profiles := selectVideoProfiles(t.bs.VideoProfiles, video.Height)
+	if len(profiles) == 0 {
+		return "", fmt.Errorf("no video profile configured")
+	}
+
+	err = t.stage(JobStageHls, func() error {
+		variants := make([]*hlsVariant, 0, len(profiles))
+		for i, p := range profiles {
+			dir := filepath.Join(tmpHlsPath, p.Name)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+
+			if err := t.runFFmpeg(StageHls, p.args(*tmpPath, dir, fps, hasAudio, opts...)...); err != nil {
+				return err
+			}
+
+			v, err := measureVariant(dir, p.Name, p.codecs(hasAudio))
+			if err != nil {
+				return err
+			}
+			v.resolution = fmt.Sprintf("%dx%d", scaledWidth(video.Width, video.Height, p.Height), p.Height)
+			v.frameRate = fps
+			variants = append(variants, v)
+
+			if err := t.updateStatus(uint(10+70*(i+1)/len(profiles)), ""); err != nil {
+				panic(err)
+			}
+		}
+
+		return writeMasterPlaylist(tmpHlsPath, variants)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = t.stage(JobStageThumbnails, func() error {
+		// poster frame
+		err := t.runFFmpeg(StagePoster,
+			"-ss", strconv.FormatFloat(duration*posterTimeFraction, 'f', 3, 64),
+			"-i", *tmpPath,
+			"-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", maxPosterHeight),
+			"-q:v", "2",
+			"-y", filepath.Join(tmpHlsPath, posterFileName),
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := t.updateStatus(85, ""); err != nil {
+			panic(err)
+		}
+
+		// thumbnails sprite with its WebVTT track
+		interval := spriteInterval(duration)
+		thumbHeight := scaledWidth(video.Height, video.Width, spriteThumbWidth)
+		err = t.runFFmpeg(StageSprite,
+			"-i", *tmpPath,
+			"-vf", fmt.Sprintf("fps=1/%g,scale=%d:%d,tile=%dx%d", interval, spriteThumbWidth, thumbHeight, spriteColumns, spriteRows),
+			"-frames:v", "1",
+			"-q:v", "5",
+			"-y", filepath.Join(tmpHlsPath, spriteFileName),
+		)
+		if err != nil {
+			return err
+		}
+
+		vtt := thumbnailsVTT(duration, interval, spriteThumbWidth, thumbHeight)
+		return ioutil.WriteFile(filepath.Join(tmpHlsPath, spriteVttFileName), []byte(vtt), 0644)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := t.updateStatus(90, ""); err != nil {
+		panic(err)
+	}
+
+	var hlsCid string
+	err = t.stage(JobStagePin, func() (err error) {
+		hlsCid, err = t.bs.AddDir(tmpHlsPath)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	t.addArtifact("hls", hlsCid, tmpHlsPath)
+
+	if err := t.updateStatus(100, hlsCid); err != nil {
+		panic(err)
+	}
+
+	return hlsCid, err
+}
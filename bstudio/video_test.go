@@ -0,0 +1,109 @@
+package bstudio
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVideo_SelectProfiles(t *testing.T) {
+	selected := selectVideoProfiles(DefaultVideoProfiles, 720)
+	require.Len(t, selected, 3)
+	require.Equal(t, "720p", selected[0].Name)
+
+	// a tiny source still gets the smallest rung
+	selected = selectVideoProfiles(DefaultVideoProfiles, 240)
+	require.Len(t, selected, 1)
+	require.Equal(t, "360p", selected[0].Name)
+}
+
+func TestVideo_ScaledWidth(t *testing.T) {
+	require.Equal(t, 1280, scaledWidth(1920, 1080, 720))
+	require.Equal(t, 406, scaledWidth(1080, 1920, 720))
+}
+
+func TestVideo_FrameRate(t *testing.T) {
+	s := ffProbeStream{AvgFrameRate: "30000/1001"}
+	require.InDelta(t, 29.97, s.FrameRate(), 0.01)
+
+	s = ffProbeStream{AvgFrameRate: "0/0"}
+	require.Equal(t, float64(0), s.FrameRate())
+}
+
+func TestVideo_ThumbnailsVTT(t *testing.T) {
+	interval := spriteInterval(25)
+	require.Equal(t, float64(1), interval)
+
+	vtt := thumbnailsVTT(12.5, 5, 160, 90)
+	require.True(t, strings.HasPrefix(vtt, "WEBVTT\n"))
+	require.Contains(t, vtt, "00:00:00.000 --> 00:00:05.000\nthumbnails.jpg#xywh=0,0,160,90\n")
+	require.Contains(t, vtt, "00:00:10.000 --> 00:00:12.500\nthumbnails.jpg#xywh=320,0,160,90\n")
+
+	// long videos wrap on the next sprite row
+	vtt = thumbnailsVTT(3600, spriteInterval(3600), 160, 90)
+	require.Contains(t, vtt, "#xywh=0,90,160,90")
+	require.Equal(t, spriteColumns*spriteRows, strings.Count(vtt, "-->"))
+}
+
+func TestVideo_MasterPlaylist(t *testing.T) {
+	v := &hlsVariant{
+		codecs:           DefaultVideoProfiles[1].codecs(true),
+		resolution:       "1280x720",
+		frameRate:        29.97,
+		uri:              "720p/index.m3u8",
+		bandwidth:        3000000,
+		averageBandwidth: 2800000,
+	}
+
+	require.Contains(t, masterPlaylist([]*hlsVariant{v}),
+		"#EXT-X-STREAM-INF:BANDWIDTH=3000000,AVERAGE-BANDWIDTH=2800000,CODECS=\"avc1.4d401f,mp4a.40.2\",RESOLUTION=1280x720,FRAME-RATE=29.970\n720p/index.m3u8\n")
+}
+
+// memFile is a multipart file kept in memory.
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+func TestVideo_IsVideoProbe(t *testing.T) {
+	mp4 := &ffProbe{
+		Format:  ffProbeFormat{Format: "mov,mp4,m4a,3gp,3g2,mj2"},
+		Streams: []ffProbeStream{{CodecType: "video", CodecName: "h264"}, {CodecType: "audio", CodecName: "aac"}},
+	}
+	require.True(t, isVideoProbe(mp4))
+
+	// an m4a with its cover art is not a video
+	m4a := &ffProbe{
+		Format:  ffProbeFormat{Format: "mov,mp4,m4a,3gp,3g2,mj2"},
+		Streams: []ffProbeStream{{CodecType: "audio", CodecName: "aac"}, {CodecType: "video", CodecName: "mjpeg"}},
+	}
+	require.False(t, isVideoProbe(m4a))
+
+	avi := &ffProbe{
+		Format:  ffProbeFormat{Format: "avi"},
+		Streams: []ffProbeStream{{CodecType: "video", CodecName: "mpeg4"}},
+	}
+	require.False(t, isVideoProbe(avi))
+}
+
+func TestUpload_LocalPath(t *testing.T) {
+	u := &Upload{file: memFile{bytes.NewReader([]byte("video"))}}
+	path, cleanup, err := u.localPath()
+	require.NoError(t, err)
+	bz, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "video", string(bz))
+
+	// the upload is read again from the start when stored
+	bz, err = ioutil.ReadAll(u.file)
+	require.NoError(t, err)
+	require.Equal(t, "video", string(bz))
+
+	cleanup()
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/bitsongofficial/bstudio/bstudio"
 	"github.com/bitsongofficial/bstudio/server"
@@ -12,20 +14,10 @@ import (
 	"github.com/spf13/cobra"
 	"net/http"
 	"os"
-	"sync"
-	"time"
-)
-
-const (
-	logLevelJSON = "json"
-	logLevelText = "text"
-	listenAddr   = "127.0.0.1:1347"
-)
-
-var (
-	logLevel  string
-	logFormat string
-	ipfsAddr  string
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 )
 
 var rootCmd = &cobra.Command{
@@ -34,8 +26,14 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&homeDir, "home", defaultHome(), "home directory of the config.yaml, the database and the keys; also set by "+envHome)
+
 	rootCmd.AddCommand(getStartCmd())
+	rootCmd.AddCommand(getConfigCmd())
 	rootCmd.AddCommand(getVersionCmd())
+	rootCmd.AddCommand(getKeysCmd())
+	rootCmd.AddCommand(getUsageCmd())
+	rootCmd.AddCommand(getExecLimitsCmd())
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -52,64 +50,306 @@ func getStartCmd() *cobra.Command {
 		Use:   "start",
 		Short: "Start BitSong Studio API",
 		RunE: func(cmd *cobra.Command, args []string) error {
-
-			DefaultStudioHome := os.ExpandEnv("$HOME/.bstudio")
-
-			logLvl, err := zerolog.ParseLevel(logLevel)
+			cfg, err := loadConfig(cmd.Flags())
 			if err != nil {
 				return err
 			}
 
+			logLvl, _ := zerolog.ParseLevel(cfg.Log.Level)
 			zerolog.SetGlobalLevel(logLvl)
+			if cfg.Log.Format == bstudio.LogFormatText {
+				log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+			}
 
-			if _, err := os.Stat(DefaultStudioHome); os.IsNotExist(err) {
-				if err := os.Mkdir(DefaultStudioHome, os.ModePerm); err != nil {
-					return err
-				}
+			if err := os.MkdirAll(homeDir, 0700); err != nil {
+				return err
+			}
+			if cfg.Storage.TmpDir != "" {
+				bstudio.TmpDir = cfg.Storage.TmpDir
 			}
 
 			// Start IPFS Shell
-			sh := shell.NewShell(ipfsAddr)
+			sh := shell.NewShell(cfg.IPFS.Addr)
 			if !sh.IsUp() {
 				return fmt.Errorf("ipfs api is down!")
 			}
 
-			bs := bstudio.NewBStudio(sh)
+			ds, err := bstudio.OpenDs(cfg.Path(homeDir, cfg.Storage.DbDir))
+			if err != nil {
+				return err
+			}
+			bs := bstudio.NewBStudioWithDs(sh, ds)
 			defer bs.Ds.Db.Close()
 
-			var wg sync.WaitGroup
-			wg.Add(1)
+			// HLS content keys are wrapped with the instance master key
+			masterKey, err := bstudio.LoadOrCreateMasterKey(filepath.Join(homeDir, "master.key"))
+			if err != nil {
+				return err
+			}
+			if bs.Keys, err = bstudio.NewKeyStore(bs.Ds, masterKey); err != nil {
+				return err
+			}
+			bs.KeyURL = cfg.HLSKeyURL()
+
+			bs.ImageBackground, _ = bstudio.ParseHexColor(cfg.Images.Background)
+			bs.ImageSizes = cfg.Images.Sizes
+			bs.ImageMaxPixels = cfg.Images.MaxPixels
+			bs.KeepImageOriginal = cfg.Images.KeepOriginal
+			bs.DuplicatePolicy = bstudio.DuplicatePolicy{Mode: cfg.Images.Duplicates, Threshold: cfg.Images.DuplicateDistance}
+			bs.UploadMemory = cfg.Upload.MaxMemory << 20
+			bs.ImageUploadMemory = cfg.Upload.ImageMaxMemory << 20
+			bs.ImageMaxSize = cfg.Upload.ImageMaxSize << 20
+			bs.UploadTimeout = cfg.Upload.Timeout
+
+			bs.ManifestCodec = cfg.Manifests.Codec
+			bs.PublishIPNS = cfg.Manifests.IPNS
+			bs.RequireSignatures = cfg.Manifests.RequireSignatures
+			bs.Auth = cfg.Auth.Enabled
+			if !bs.Auth {
+				log.Warn().Msg("api key authentication is disabled, anyone can upload")
+			}
+
+			// wallet session tokens are signed with their own instance secret
+			if bs.SessionSecret, err = bstudio.LoadOrCreateMasterKey(filepath.Join(homeDir, "session.key")); err != nil {
+				return err
+			}
+			bs.SessionTTL = cfg.Auth.SessionTTL
+
+			// untrusted uploads are processed with limits
+			bs.Executor.Timeouts, _ = bstudio.ParseStageTimeouts(cfg.FFmpeg.Timeouts)
+			bs.Executor.Limits = bstudio.ExecLimits{CPU: cfg.FFmpeg.CPULimit, Memory: cfg.FFmpeg.MemoryLimit << 20, FileSize: cfg.FFmpeg.FileSizeLimit << 20}
+			bs.Executor.Isolate = cfg.FFmpeg.Isolate
+			bs.Executor.Wrapper = strings.Fields(cfg.FFmpeg.Wrapper)
+
+			bs.MinFreeDisk = cfg.Server.ReadyMinFreeDisk << 20
+			bs.MaxPendingJobs = cfg.Server.ReadyMaxJobs
+			if cfg.Server.Metrics {
+				bs.Metrics = bstudio.NewMetrics(bs)
+			}
+
+			if cfg.RateLimit.Enabled {
+				limits, _ := bstudio.ParseRateLimits(cfg.RateLimit.Limits)
+				bs.RateLimiter = bstudio.NewRateLimiter(limits)
+			}
+			var quotas bstudio.QuotaLimits
+			quotas.Daily, _ = bstudio.ParseUsage(cfg.Quota.Daily)
+			quotas.Monthly, _ = bstudio.ParseUsage(cfg.Quota.Monthly)
+			if quotas.Enabled() {
+				bs.Quotas = bstudio.NewQuotas(bs.Ds, quotas)
+			}
+
+			if cfg.Images.CacheSize > 0 {
+				if bs.ImageCache, err = bstudio.NewDiskCache(filepath.Join(homeDir, "cache", "images"), cfg.Images.CacheSize<<20); err != nil {
+					return err
+				}
+			}
+			if cfg.HLS.EntitlementURL != "" {
+				bs.Entitlement = bstudio.NewWebhookEntitlement(cfg.HLS.EntitlementURL)
+			}
+
 			go bs.StartTranscoding()
-			defer wg.Done()
+			go func() {
+				if err := bs.RequeuePending(); err != nil && err != bstudio.ErrShuttingDown {
+					log.Error().Err(err).Msg("cannot requeue the unfinished jobs")
+				}
+			}()
 
 			// create HTTP router and mount routes
 			router := mux.NewRouter()
 			c := cors.New(cors.Options{
-				AllowedOrigins: []string{"*"},
-				//AllowedMethods: []string{"GET", "PUT", "PATCH", "POST", "DELETE", "OPTIONS"},
-				//AllowedHeaders: []string{"Origin", "Authorization", "Content-Type", "Accept", "Access-Control-Allow-Methods", "Access-Control-Request-Headers"},
-				//ExposedHeaders: []string{""},
-				//MaxAge: 10,
-				//AllowCredentials: true,
+				AllowedOrigins: cfg.Server.CORS.Origins,
+				AllowedMethods: cfg.Server.CORS.Methods,
+				AllowedHeaders: cfg.Server.CORS.Headers,
+				ExposedHeaders: []string{
+					"X-Request-ID",
+					"Retry-After",
+					"X-RateLimit-Limit",
+					"X-RateLimit-Remaining",
+					"X-RateLimit-Reset",
+					"X-RateLimit-Quota-Uploads-Remaining",
+					"X-RateLimit-Quota-Bytes-Remaining",
+					"X-RateLimit-Quota-Minutes-Remaining",
+					"X-RateLimit-Quota-Reset",
+				},
 			})
 
 			server.RegisterRoutes(router, bs)
 
 			srv := &http.Server{
-				Handler:      c.Handler(router),
-				Addr:         listenAddr,
-				WriteTimeout: 15 * time.Second,
-				ReadTimeout:  15 * time.Second,
+				Handler:      c.Handler(server.LoggingHandler(router)),
+				WriteTimeout: cfg.Server.WriteTimeout,
+				ReadTimeout:  cfg.Server.ReadTimeout,
+				ConnContext:  server.ConnContext,
+			}
+
+			tlsCfg := cfg.Server.TLS
+			clientAuth := tls.RequireAndVerifyClientCert
+			if tlsCfg.ClientAuth == "optional" {
+				clientAuth = tls.VerifyClientCertIfGiven
+			}
+			if tlsCfg.Cert != "" {
+				reloader, err := server.NewTLSReloader(server.TLSFiles{CertFile: tlsCfg.Cert, KeyFile: tlsCfg.Key, ClientCAFile: tlsCfg.ClientCA, ClientAuth: clientAuth})
+				if err != nil {
+					return err
+				}
+				srv.TLSConfig = reloader.Config()
+
+				// renewed certificates are picked up on SIGHUP
+				hup := make(chan os.Signal, 1)
+				signal.Notify(hup, syscall.SIGHUP)
+				go func() {
+					for range hup {
+						if err := reloader.Reload(); err != nil {
+							log.Error().Err(err).Msg("cannot reload tls certificate, keeping the previous one")
+							continue
+						}
+						log.Info().Str("cert", tlsCfg.Cert).Msg("tls certificate reloaded")
+					}
+				}()
+			}
+
+			listeners, err := server.Listen(cfg.Server.Listen, cfg.Server.UnixSocket, srv.TLSConfig)
+			if err != nil {
+				return err
+			}
+			for _, l := range listeners {
+				log.Info().Str("network", l.Addr().Network()).Str("address", l.Addr().String()).Bool("tls", srv.TLSConfig != nil && l.Addr().Network() == "tcp").Msg("starting API server...")
+			}
+			internal, err := server.ListenInternal(cfg.Server.InternalListen)
+			if err != nil {
+				for _, l := range listeners {
+					l.Close()
+				}
+				return err
+			}
+			for _, l := range internal {
+				log.Info().Str("address", l.Addr().String()).Msg("starting internal API server...")
+			}
+			listeners = append(listeners, internal...)
+
+			errc := make(chan error, 1)
+			go func() {
+				errc <- server.Serve(srv, listeners)
+			}()
+
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+			var serveErr error
+			select {
+			case serveErr = <-errc:
+				log.Error().Err(serveErr).Msg("API server stopped")
+			case sig := <-sigs:
+				log.Info().Str("signal", sig.String()).Dur("timeout", cfg.Server.ShutdownTimeout).Msg("shutting down...")
+			}
+
+			// no job is accepted and /readyz fails from now on, then each phase gets its own deadline:
+			// the requests in flight, the running job, and the ipns publications before the database is closed
+			bs.StopAccepting()
+			phase := func(name string, stop func(context.Context) error) {
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+				defer cancel()
+				if err := stop(ctx); err != nil {
+					log.Warn().Err(err).Msg(name)
+				}
 			}
+			phase("requests still in flight were cut off", srv.Shutdown)
+			phase("running job interrupted, it is requeued at the next start", bs.Shutdown)
+			phase("ipns publications still running were abandoned", bs.WaitPublishing)
+			log.Info().Msg("shutdown complete")
 
-			log.Info().Str("address", listenAddr).Msg("starting API server...")
-			return srv.ListenAndServe()
+			return serveErr
 		},
 	}
 
-	startCmd.Flags().StringVar(&logLevel, "log-level", zerolog.InfoLevel.String(), "logging level")
-	startCmd.Flags().StringVar(&logFormat, "log-format", logLevelJSON, "logging format; must be either json or text")
-	startCmd.Flags().StringVar(&ipfsAddr, "ipfs-addr", "localhost:5001", "ipfs api address")
+	def := bstudio.DefaultConfig()
+	fs := startCmd.Flags()
+	fs.String("log-level", def.Log.Level, "logging level")
+	fs.String("log-format", def.Log.Format, "logging format; must be either json or text")
+	fs.String("ipfs-addr", def.IPFS.Addr, "ipfs api address")
+	fs.StringSlice("listen", def.Server.Listen, "comma separated tcp addresses to listen on")
+	fs.String("unix-socket", def.Server.UnixSocket, "also listen on this unix socket, without tls; it serves the internal routes /readyz and /metrics without credentials")
+	fs.StringSlice("internal-listen", def.Server.InternalListen, "comma separated tcp addresses serving the internal routes too, without tls nor credentials")
+	fs.String("tls-cert", def.Server.TLS.Cert, "PEM certificate file, serve https when set; reloaded on SIGHUP")
+	fs.String("tls-key", def.Server.TLS.Key, "PEM private key file of --tls-cert")
+	fs.String("tls-client-ca", def.Server.TLS.ClientCA, "PEM CA file verifying the client certificates of the tcp clients (mTLS)")
+	fs.String("tls-client-auth", def.Server.TLS.ClientAuth, "with --tls-client-ca, require a client certificate or only verify it when given (require | optional)")
+	fs.StringSlice("cors-origins", def.Server.CORS.Origins, "comma separated origins allowed by CORS, * allows any")
+	fs.StringSlice("cors-methods", def.Server.CORS.Methods, "comma separated methods allowed by CORS")
+	fs.StringSlice("cors-headers", def.Server.CORS.Headers, "comma separated request headers allowed by CORS")
+	fs.String("hls-key-url", def.HLS.KeyURL, "public base url of the content key endpoint written into EXT-X-KEY; derived from the first --listen address without tls, required with it")
+	fs.String("image-background", def.Images.Background, "background color used to flatten transparent images")
+	fs.String("image-duplicates", def.Images.Duplicates, "what to do with near duplicate images: off, flag or dedupe")
+	fs.Int("image-duplicate-distance", def.Images.DuplicateDistance, "maximum perceptual hash hamming distance of near duplicate images, 0 to 7")
+	fs.Int64("image-cache-size", def.Images.CacheSize, "size in MB of the on the fly image renditions cache, 0 disables resizing")
+	fs.Int("image-max-pixels", def.Images.MaxPixels, "images of more pixels are rejected before being decoded")
+	fs.Bool("image-keep-original", def.Images.KeepOriginal, "publish the full resolution image next to the renditions, on the fly sizes are rendered from it")
+	fs.String("manifest-codec", def.Manifests.Codec, "codec of the manifest DAG objects: dag-cbor or dag-json")
+	fs.Bool("manifest-ipns", def.Manifests.IPNS, "publish the latest version of every manifest to its own ipns name")
+	fs.Bool("auth", def.Auth.Enabled, "require an api key on the upload, manifest and job routes; create keys with bstudio keys create")
+	fs.Duration("session-ttl", def.Auth.SessionTTL, "lifetime of the session tokens issued by the wallet login")
+	fs.Bool("require-signatures", def.Manifests.RequireSignatures, "reject the manifests not signed by one of their artists")
+	fs.Bool("rate-limiting", def.RateLimit.Enabled, "limit the request rate of every api key, address or ip per route group")
+	fs.String("rate-limits", def.RateLimit.Limits, "comma separated group=rate:burst token buckets, rate per minute; groups: auth, upload, manifest, read, and ip for every request of an ip")
+	fs.String("quota-daily", def.Quota.Daily, "daily quota of every client, e.g. uploads=100,bytes=10737418240,minutes=600; empty is unlimited")
+	fs.String("quota-monthly", def.Quota.Monthly, "calendar month quota of every client, same format as --quota-daily")
+	fs.String("ffmpeg-timeouts", def.FFmpeg.Timeouts, "comma separated stage=duration timeouts of ffmpeg and ffprobe, 0 disables one")
+	fs.Duration("ffmpeg-cpu-limit", def.FFmpeg.CPULimit, "cpu time limit of an ffmpeg process, 0 is unlimited")
+	fs.Uint64("ffmpeg-memory-limit", def.FFmpeg.MemoryLimit, "address space limit in MB of an ffmpeg process, 0 is unlimited")
+	fs.Uint64("ffmpeg-file-size-limit", def.FFmpeg.FileSizeLimit, "largest file in MB an ffmpeg process may write, 0 is unlimited")
+	fs.Bool("ffmpeg-isolate", def.FFmpeg.Isolate, "run ffmpeg in its own user and network namespaces without network (linux, needs unprivileged user namespaces); the filesystem stays visible, restrict it with a wrapper")
+	fs.String("ffmpeg-wrapper", def.FFmpeg.Wrapper, "command prefixed to ffmpeg and ffprobe, e.g. to apply a seccomp profile with nsjail")
+	fs.Bool("metrics", def.Server.Metrics, "serve the prometheus metrics on /metrics, to the admin api keys or on the internal connections")
+	fs.Uint64("ready-min-free-disk", def.Server.ReadyMinFreeDisk, "free space in MB of the temp directory under which /readyz fails")
+	fs.Int("ready-max-jobs", def.Server.ReadyMaxJobs, "pending transcoding jobs over which /readyz fails, 0 disables the check")
+	fs.Duration("shutdown-timeout", def.Server.ShutdownTimeout, "on SIGTERM, time given to the requests and the running job to finish before they are cut off")
+	fs.String("entitlement-url", def.HLS.EntitlementURL, "url of the service checking content key entitlements; keys are denied when empty")
+
+	// the flags set on the command line override the config file and the environment
+	for name, key := range map[string]string{
+		"log-level":                "log.level",
+		"log-format":               "log.format",
+		"ipfs-addr":                "ipfs.addr",
+		"listen":                   "server.listen",
+		"unix-socket":              "server.unix_socket",
+		"internal-listen":          "server.internal_listen",
+		"tls-cert":                 "server.tls.cert",
+		"tls-key":                  "server.tls.key",
+		"tls-client-ca":            "server.tls.client_ca",
+		"tls-client-auth":          "server.tls.client_auth",
+		"cors-origins":             "server.cors.origins",
+		"cors-methods":             "server.cors.methods",
+		"cors-headers":             "server.cors.headers",
+		"hls-key-url":              "hls.key_url",
+		"image-background":         "images.background",
+		"image-duplicates":         "images.duplicates",
+		"image-duplicate-distance": "images.duplicate_distance",
+		"image-cache-size":         "images.cache_size",
+		"image-max-pixels":         "images.max_pixels",
+		"image-keep-original":      "images.keep_original",
+		"manifest-codec":           "manifests.codec",
+		"manifest-ipns":            "manifests.ipns",
+		"auth":                     "auth.enabled",
+		"session-ttl":              "auth.session_ttl",
+		"require-signatures":       "manifests.require_signatures",
+		"rate-limiting":            "rate_limit.enabled",
+		"rate-limits":              "rate_limit.limits",
+		"quota-daily":              "quota.daily",
+		"quota-monthly":            "quota.monthly",
+		"ffmpeg-timeouts":          "ffmpeg.timeouts",
+		"ffmpeg-cpu-limit":         "ffmpeg.cpu_limit",
+		"ffmpeg-memory-limit":      "ffmpeg.memory_limit",
+		"ffmpeg-file-size-limit":   "ffmpeg.file_size_limit",
+		"ffmpeg-isolate":           "ffmpeg.isolate",
+		"ffmpeg-wrapper":           "ffmpeg.wrapper",
+		"metrics":                  "server.metrics",
+		"ready-min-free-disk":      "server.ready_min_free_disk",
+		"ready-max-jobs":           "server.ready_max_jobs",
+		"shutdown-timeout":         "server.shutdown_timeout",
+		"entitlement-url":          "hls.entitlement_url",
+	} {
+		bindFlag(fs, name, key)
+	}
 
 	return startCmd
 }
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// flagConfigKey annotates the flags overriding a config key
+	flagConfigKey = "bstudio_config_key"
+
+	envHome = "BSTUDIO_HOME"
+)
+
+var (
+	homeDir     string
+	configForce bool
+)
+
+func defaultHome() string {
+	if home := os.Getenv(envHome); home != "" {
+		return home
+	}
+	return os.ExpandEnv("$HOME/.bstudio")
+}
+
+// bindFlag makes the flag override the config key when it is set on the command line.
+func bindFlag(fs *pflag.FlagSet, name, key string) {
+	if err := fs.SetAnnotation(name, flagConfigKey, []string{key}); err != nil {
+		panic(err)
+	}
+}
+
+// loadConfig reads the config of the home directory, the environment variables and then the flags
+// set on the command line, and validates the result. All the invalid values are returned at once.
+func loadConfig(fs *pflag.FlagSet) (*bstudio.Config, error) {
+	path := filepath.Join(homeDir, bstudio.ConfigFileName)
+	cfg, err := bstudio.LoadConfig(path, os.Environ())
+	var errs bstudio.ValidationErrors
+	if verrs, ok := err.(bstudio.ValidationErrors); ok {
+		errs = append(errs, verrs...)
+	} else if err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *pflag.Flag) {
+		keys := f.Annotations[flagConfigKey]
+		if len(keys) == 0 {
+			return
+		}
+
+		val := f.Value.String()
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			val = strings.Join(sv.GetSlice(), ",")
+		}
+		if err := cfg.Set(keys[0], val); err != nil {
+			errs.Add("--"+f.Name, "invalid_value", "%s", err)
+		}
+	})
+
+	if verrs, ok := cfg.Validate().(bstudio.ValidationErrors); ok {
+		errs = append(errs, verrs...)
+	}
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = fmt.Sprintf("  %s: %s", e.Field, e.Message)
+		}
+		return nil, fmt.Errorf("invalid configuration:\n%s", strings.Join(msgs, "\n"))
+	}
+
+	return cfg, nil
+}
+
+func getConfigCmd() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the configuration file of the home directory",
+	}
+
+	configCmd.AddCommand(getConfigInitCmd())
+
+	return configCmd
+}
+
+func getConfigInitCmd() *cobra.Command {
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write the default configuration, with the documentation of every key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := os.MkdirAll(homeDir, 0700); err != nil {
+				return err
+			}
+
+			path := filepath.Join(homeDir, bstudio.ConfigFileName)
+			flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+			if configForce {
+				flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			}
+			f, err := os.OpenFile(path, flags, 0600)
+			if os.IsExist(err) {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+			}
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if err := bstudio.WriteConfig(f, bstudio.DefaultConfig()); err != nil {
+				return err
+			}
+
+			fmt.Println(path)
+			return nil
+		},
+	}
+
+	initCmd.Flags().BoolVar(&configForce, "force", false, "overwrite an existing configuration file")
+
+	return initCmd
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// getExecLimitsCmd is the helper the executor starts ffmpeg and ffprobe through, it is not meant to be run by hand.
+func getExecLimitsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:                bstudio.ExecLimitsCommand + " <cpu:memory:file size> <path> [args...]",
+		Short:              "Apply the rlimits then run the command",
+		Hidden:             true,
+		DisableFlagParsing: true,
+		Args:               cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := bstudio.ExecWithLimits(args[0], args[1:])
+			fmt.Fprintf(os.Stderr, "bstudio: cannot run %v: %s\n", args[1:], err)
+			os.Exit(127)
+		},
+	}
+}
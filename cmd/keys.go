@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	keyName   string
+	keyScopes string
+	keyFormat string
+)
+
+// getKeysCmd manages the API keys. The database is locked by a running server,
+// so these commands only run while it is stopped.
+func getKeysCmd() *cobra.Command {
+	keysCmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the API keys",
+		Long: `Manage the API keys stored in the database of the home directory.
+
+The server holds the lock of the database while it runs: stop it before
+creating, listing or revoking keys, then start it again.`,
+	}
+
+	keysCmd.AddCommand(getKeysCreateCmd(), getKeysListCmd(), getKeysRevokeCmd())
+
+	return keysCmd
+}
+
+// openStudio opens the database of the configured home directory.
+func openStudio(cmd *cobra.Command) (*bstudio.BStudio, error) {
+	cfg, err := loadConfig(cmd.Flags())
+	if err != nil {
+		return nil, err
+	}
+
+	dir := cfg.Path(homeDir, cfg.Storage.DbDir)
+	ds, err := bstudio.OpenDs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the database %s, is the server still running? %w", dir, err)
+	}
+
+	return &bstudio.BStudio{Ds: ds}, nil
+}
+
+func getKeysCreateCmd() *cobra.Command {
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create an API key, its token is only printed once",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scopes, err := bstudio.ParseScopes(keyScopes)
+			if err != nil {
+				return err
+			}
+
+			bs, err := openStudio(cmd)
+			if err != nil {
+				return err
+			}
+			defer bs.Ds.Db.Close()
+
+			token, k, err := bs.CreateAPIKey(keyName, scopes)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("id:     %s\nscopes: %s\ntoken:  %s\n", k.ID, strings.Join(k.Scopes, ","), token)
+			return nil
+		},
+	}
+
+	createCmd.Flags().StringVar(&keyName, "name", "", "name of the key owner")
+	createCmd.Flags().StringVar(&keyScopes, "scopes", "", fmt.Sprintf("comma separated scopes: %s", strings.Join(bstudio.Scopes, ", ")))
+	createCmd.MarkFlagRequired("scopes")
+
+	return createCmd
+}
+
+func getKeysListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the API keys",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bs, err := openStudio(cmd)
+			if err != nil {
+				return err
+			}
+			defer bs.Ds.Db.Close()
+
+			keys, err := bs.ListAPIKeys()
+			if err != nil {
+				return err
+			}
+
+			if keyFormat == "json" {
+				for _, k := range keys {
+					k.Hash = ""
+				}
+				return json.NewEncoder(os.Stdout).Encode(keys)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCREATED\tREVOKED")
+			for _, k := range keys {
+				revoked := "-"
+				if k.Revoked() {
+					revoked = k.RevokedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), k.CreatedAt.Format(time.RFC3339), revoked)
+			}
+
+			return tw.Flush()
+		},
+	}
+
+	listCmd.Flags().StringVar(&keyFormat, flagFormat, "text", "Print the keys in the given format (text | json)")
+
+	return listCmd
+}
+
+func getKeysRevokeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke [id]",
+		Short: "Revoke an API key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bs, err := openStudio(cmd)
+			if err != nil {
+				return err
+			}
+			defer bs.Ds.Db.Close()
+
+			if err := bs.RevokeAPIKey(args[0]); err != nil {
+				return err
+			}
+
+			fmt.Printf("revoked %s\n", args[0])
+			return nil
+		},
+	}
+}
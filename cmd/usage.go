@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var (
+	usageFrom   string
+	usageTo     string
+	usageClient string
+	usageFormat string
+)
+
+// getUsageCmd reads the usage events, like the keys commands it needs the server to be stopped.
+func getUsageCmd() *cobra.Command {
+	usageCmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Report the usage of the jobs for billing",
+	}
+
+	usageCmd.AddCommand(getUsageExportCmd())
+
+	return usageCmd
+}
+
+func getUsageExportCmd() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the usage events of a period, one per job",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := bstudio.ParseUsageTime(usageFrom)
+			if err != nil {
+				return err
+			}
+			to := time.Now().UTC()
+			if usageTo != "" {
+				if to, err = bstudio.ParseUsageTime(usageTo); err != nil {
+					return err
+				}
+			}
+			if !from.Before(to) {
+				return fmt.Errorf("--from must be before --to")
+			}
+			if usageFormat != bstudio.UsageFormatCSV && usageFormat != bstudio.UsageFormatJSON {
+				return fmt.Errorf("unknown format %s, expected csv or json", usageFormat)
+			}
+
+			bs, err := openStudio(cmd)
+			if err != nil {
+				return err
+			}
+			defer bs.Ds.Db.Close()
+
+			events, err := bs.ListUsage(from, to, usageClient)
+			if err != nil {
+				return err
+			}
+
+			return bstudio.WriteUsage(os.Stdout, events, usageFormat)
+		},
+	}
+
+	exportCmd.Flags().StringVar(&usageFrom, "from", "", "start of the period, 2006-01-02 or RFC 3339")
+	exportCmd.Flags().StringVar(&usageTo, "to", "", "end of the period, excluded, 2006-01-02 or RFC 3339; default now")
+	exportCmd.Flags().StringVar(&usageClient, "client", "", "only export the events of this client, e.g. key:<id> or address:<address>")
+	exportCmd.Flags().StringVar(&usageFormat, flagFormat, bstudio.UsageFormatCSV, "Print the events in the given format (csv | json)")
+	exportCmd.MarkFlagRequired("from")
+
+	return exportCmd
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const principalContextKey contextKey = iota
+
+// names of the routes, the access rules refer to them
+const (
+	routeSwagger         = "swagger"
+	routeAuthChallenge   = "auth_challenge"
+	routeLogin           = "login"
+	routeUploadAudio     = "upload_audio"
+	routeUploadVideo     = "upload_video"
+	routeUploadImage     = "upload_image"
+	routeUploadManifest  = "upload_manifest"
+	routeUploadStatus    = "upload_status"
+	routeImage           = "image"
+	routeContentKey      = "content_key"
+	routeVerifyManifest  = "verify_manifest"
+	routeManifest        = "manifest"
+	routeUpdateManifest  = "update_manifest"
+	routeManifestHistory = "manifest_history"
+	routeManifestCar     = "manifest_car"
+	routeUsage           = "usage"
+	routeSchema          = "schema"
+	routeHealthz         = "healthz"
+	routeReadyz          = "readyz"
+	routeMetrics         = "metrics"
+)
+
+// routeScopes is the scope each protected route requires.
+var routeScopes = map[string]string{
+	routeUploadAudio:    bstudio.ScopeUploadAudio,
+	routeUploadVideo:    bstudio.ScopeUploadVideo,
+	routeUploadImage:    bstudio.ScopeUploadImage,
+	routeUploadManifest: bstudio.ScopeManifestWrite,
+	routeUploadStatus:   bstudio.ScopeJobsRead,
+	routeUpdateManifest: bstudio.ScopeManifestWrite,
+	routeUsage:          bstudio.ScopeAdmin,
+	routeMetrics:        bstudio.ScopeAdmin,
+}
+
+// internalRoutes are served without credentials on the unix socket and the internal listeners.
+// Elsewhere they need their scope, or are not found when they have none.
+var internalRoutes = map[string]bool{
+	routeReadyz:  true,
+	routeMetrics: true,
+}
+
+// publicRoutes are served without credentials. The content keys check the entitlement of the player themselves.
+var publicRoutes = map[string]bool{
+	routeSwagger:         true,
+	routeAuthChallenge:   true,
+	routeLogin:           true,
+	routeImage:           true,
+	routeContentKey:      true,
+	routeVerifyManifest:  true,
+	routeManifest:        true,
+	routeManifestHistory: true,
+	routeManifestCar:     true,
+	routeSchema:          true,
+	routeHealthz:         true,
+}
+
+// requestToken returns the credential of the request, from X-API-Key or a bearer Authorization header.
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		return token
+	}
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	return ""
+}
+
+// authenticate resolves the credentials of the request, nil when it has none.
+// It writes the error response and returns false when they are invalid.
+func authenticate(bs *bstudio.BStudio, w http.ResponseWriter, r *http.Request) (*bstudio.Principal, bool) {
+	token := requestToken(r)
+	if token == "" {
+		return nil, true
+	}
+
+	p, err := bs.Authenticate(token)
+	switch err {
+	case nil:
+		return p, true
+	case bstudio.ErrInvalidAPIKey, bstudio.ErrInvalidToken, bstudio.ErrExpiredToken:
+		w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+		writeJSONResponse(w, http.StatusUnauthorized, newErrorJson(err.Error()))
+	default:
+		writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot check credentials: %s", err)))
+	}
+
+	return nil, false
+}
+
+// fromInternal reports whether the request came through the unix socket or an internal listener,
+// it needs ConnContext.
+func fromInternal(r *http.Request) bool {
+	switch c := r.Context().Value(connContextKey{}).(type) {
+	case *internalConn:
+		return true
+	case net.Conn:
+		return c.LocalAddr().Network() == "unix"
+	}
+
+	return false
+}
+
+// authMiddleware checks the credentials of the matched route against routeScopes. The routes in
+// publicRoutes, and in internalRoutes on the internal connections, are served without credentials,
+// any other route is refused.
+func authMiddleware(bs *bstudio.BStudio) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var name string
+			if cr := mux.CurrentRoute(r); cr != nil {
+				name = cr.GetName()
+			}
+
+			scope, protected := routeScopes[name]
+			if internalRoutes[name] {
+				if fromInternal(r) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if !protected {
+					writeJSONResponse(w, http.StatusNotFound, newErrorJson("not found"))
+					return
+				}
+			}
+
+			if !bs.Auth || publicRoutes[name] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !protected {
+				requestLog(r).Error().Str("route", name).Str("path", r.URL.Path).Msg("route has no access rule")
+				writeJSONResponse(w, http.StatusForbidden, newErrorJson("access denied"))
+				return
+			}
+
+			p, ok := authenticate(bs, w, r)
+			if !ok {
+				return
+			}
+			if p == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSONResponse(w, http.StatusUnauthorized, newErrorJson("an api key or a session token is required"))
+				return
+			}
+
+			if !p.HasScope(scope) {
+				requestLog(r).Info().Str("api_key", p.APIKeyID).Str("address", p.Address).Str("scope", scope).Str("path", r.URL.Path).Msg("scope denied")
+				writeJSONResponse(w, http.StatusForbidden, newErrorJson(fmt.Sprintf("the credentials do not grant %s", scope)))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
+		})
+	}
+}
+
+// requestPrincipal returns who the request was authenticated as, nil when authentication is disabled.
+func requestPrincipal(r *http.Request) *bstudio.Principal {
+	p, _ := r.Context().Value(principalContextKey).(*bstudio.Principal)
+	return p
+}
+
+// requestTenant returns the client the content created by the request is private to, empty when authentication is disabled.
+func requestTenant(r *http.Request) string {
+	if p := requestPrincipal(r); p != nil {
+		return p.ClientID()
+	}
+
+	return ""
+}
+
+// requestOwner returns the wallet address owning what the request creates, if any.
+func requestOwner(r *http.Request) string {
+	if p := requestPrincipal(r); p != nil {
+		return p.Address
+	}
+
+	return ""
+}
+
+// requestAPIKeyID returns the API key the request was authenticated with, if any.
+func requestAPIKeyID(r *http.Request) string {
+	if p := requestPrincipal(r); p != nil {
+		return p.APIKeyID
+	}
+
+	return ""
+}
+
+type AuthChallengeResp struct {
+	Address   string    `json:"address"`
+	Nonce     string    `json:"nonce"`
+	Message   string    `json:"message"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type LoginResp struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	Address   string    `json:"address"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// @Summary Request a login challenge
+// @Description Get a single use message to sign with the wallet of address (ADR-036), valid 5 minutes.
+// @Tags auth
+// @Produce json
+// @Param address formData string true "bitsong1... address"
+// @Success 200 {object} server.AuthChallengeResp
+// @Failure 400 {object} server.ErrorJson "Invalid address"
+// @Failure 404 {object} server.ErrorJson "Wallet login is not configured"
+// @Router /auth/challenge [post]
+func authChallengeHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := bs.CreateAuthChallenge(r.FormValue("address"))
+		switch err {
+		case nil:
+		case bstudio.ErrInvalidAddress:
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("address must be a bitsong1... address"))
+			return
+		case bstudio.ErrLoginDisabled:
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson(err.Error()))
+			return
+		default:
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot create challenge: %s", err)))
+			return
+		}
+
+		writeJSONResponse(w, http.StatusOK, AuthChallengeResp{
+			Address:   c.Address,
+			Nonce:     c.Nonce,
+			Message:   c.Message,
+			ExpiresAt: c.ExpiresAt,
+		})
+	}
+}
+
+// @Summary Log in with a wallet signature
+// @Description Exchange the ADR-036 signature of a challenge message for a short lived session token,
+// @Description to send as a bearer Authorization header. Uploads made with it are owned by the address.
+// @Tags auth
+// @Produce json
+// @Param nonce formData string true "Challenge nonce"
+// @Param signature formData string true "ADR-036 signature envelope of the challenge message"
+// @Success 200 {object} server.LoginResp
+// @Failure 401 {object} server.ErrorJson "Invalid challenge or signature"
+// @Failure 404 {object} server.ErrorJson "Wallet login is not configured"
+// @Router /auth/login [post]
+func loginHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sig, err := bstudio.ParseSignatureEnvelope([]byte(r.FormValue("signature")))
+		if err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(err.Error()))
+			return
+		}
+
+		token, claims, err := bs.Login(r.FormValue("nonce"), sig)
+		if err == bstudio.ErrLoginDisabled {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson(err.Error()))
+			return
+		}
+		if err == bstudio.ErrInvalidChallenge || errors.Is(err, bstudio.ErrInvalidSignature) {
+			requestLog(r).Info().Str("address", sig.Signer).Err(err).Msg("wallet login denied")
+			writeJSONResponse(w, http.StatusUnauthorized, newErrorJson(err.Error()))
+			return
+		}
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot log in: %s", err)))
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSONResponse(w, http.StatusOK, LoginResp{
+			Token:     token,
+			TokenType: "Bearer",
+			Address:   claims.Subject,
+			Scopes:    claims.Scopes(),
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// testStudio returns a studio with the default settings on a temporary database.
+func testStudio(t *testing.T) (*bstudio.BStudio, func()) {
+	dir, err := ioutil.TempDir("", "bstudio-server")
+	require.NoError(t, err)
+
+	ds, err := bstudio.OpenDs(dir)
+	require.NoError(t, err)
+
+	bs := bstudio.NewBStudioWithDs(nil, ds)
+	// the test binary is not the bstudio command applying the rlimits
+	bs.Executor.Limits = bstudio.ExecLimits{}
+
+	return bs, func() {
+		ds.Db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testRouter(bs *bstudio.BStudio) *mux.Router {
+	r := mux.NewRouter()
+	RegisterRoutes(r, bs)
+	return r
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuth_EveryRouteHasARule(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Metrics = bstudio.NewMetrics(bs)
+
+	err := testRouter(bs).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		name := route.GetName()
+		_, protected := routeScopes[name]
+		public, internal := publicRoutes[name], internalRoutes[name]
+		require.False(t, public && (protected || internal), "public route %q must not be protected nor internal", name)
+		require.True(t, public || protected || internal, "route %q has no access rule", name)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestAuth_Scopes(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	r := testRouter(bs)
+
+	admin, _, err := bs.CreateAPIKey("admin", []string{bstudio.ScopeAdmin})
+	require.NoError(t, err)
+	uploader, _, err := bs.CreateAPIKey("uploader", []string{bstudio.ScopeUploadImage})
+	require.NoError(t, err)
+
+	// no credentials
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	// invalid credentials
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil)
+	req.Header.Set("X-API-Key", "bsk_unknown")
+	w = serve(r, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Header().Get("WWW-Authenticate"), "invalid_token")
+
+	// missing scope
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil)
+	req.Header.Set("X-API-Key", uploader)
+	require.Equal(t, http.StatusForbidden, serve(r, req).Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	require.Equal(t, http.StatusOK, serve(r, req).Code)
+
+	// public routes do not look at the credentials
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/api/v1/schemas/track/v1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// everything is open with authentication disabled
+	bs.Auth = false
+	require.Equal(t, http.StatusOK, serve(r, httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil)).Code)
+}
+
+func TestAuth_UnlistedRoute(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/unlisted", func(w http.ResponseWriter, r *http.Request) {})
+	r.Use(authMiddleware(bs))
+
+	require.Equal(t, http.StatusForbidden, serve(r, httptest.NewRequest(http.MethodGet, "/unlisted", nil)).Code)
+}
@@ -31,21 +31,21 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/upload/audio": {
+        "/auth/challenge": {
             "post": {
-                "description": "Upload, transcode and publish to ipfs an audio",
+                "description": "Get a single use message to sign with the wallet of address (ADR-036), valid 5 minutes.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "upload"
+                    "auth"
                 ],
-                "summary": "Upload and transcode audio file",
+                "summary": "Request a login challenge",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Audio file",
-                        "name": "file",
+                        "type": "string",
+                        "description": "bitsong1... address",
+                        "name": "address",
                         "in": "formData",
                         "required": true
                     }
@@ -54,11 +54,17 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/server.UploadCidResp"
+                            "$ref": "#/definitions/server.AuthChallengeResp"
                         }
                     },
                     "400": {
-                        "description": "Error",
+                        "description": "Invalid address",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "404": {
+                        "description": "Wallet login is not configured",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorJson"
                         }
@@ -66,21 +72,28 @@ var doc = `{
                 }
             }
         },
-        "/upload/image": {
+        "/auth/login": {
             "post": {
-                "description": "Upload, create and publish to ipfs an image",
+                "description": "Exchange the ADR-036 signature of a challenge message for a short lived session token,\nto send as a bearer Authorization header. Uploads made with it are owned by the address.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "upload"
+                    "auth"
                 ],
-                "summary": "Upload and create image file",
+                "summary": "Log in with a wallet signature",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Image file",
-                        "name": "file",
+                        "type": "string",
+                        "description": "Challenge nonce",
+                        "name": "nonce",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ADR-036 signature envelope of the challenge message",
+                        "name": "signature",
                         "in": "formData",
                         "required": true
                     }
@@ -89,11 +102,97 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/server.UploadCidResp"
+                            "$ref": "#/definitions/server.LoginResp"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid challenge or signature",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "404": {
+                        "description": "Wallet login is not configured",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/images/{cid}": {
+            "get": {
+                "description": "Without query parameters, get the renditions, BlurHash and palette of a processed image by its directory or rendition CID.\nWith any of w, h, fit, format or q, render the image at that size from its original when kept, else its largest rendition, served from a disk cache.",
+                "produces": [
+                    "application/json",
+                    "image/jpeg",
+                    "image/png",
+                    "image/webp"
+                ],
+                "tags": [
+                    "images"
+                ],
+                "summary": "Get image info or an on the fly rendition",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "CID",
+                        "name": "cid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Width, must be an allowed dimension",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Height, must be an allowed dimension",
+                        "name": "h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "contain (default), cover or fill",
+                        "name": "fit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "jpeg (default), png or webp",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Quality, 1-100",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/bstudio.ImageInfo"
+                        }
+                    },
+                    "304": {
+                        "description": "Not modified",
+                        "schema": {
+                            "type": "string"
                         }
                     },
                     "400": {
-                        "description": "Error",
+                        "description": "Invalid rendition parameters",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown image",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorJson"
                         }
@@ -101,30 +200,106 @@ var doc = `{
                 }
             }
         },
-        "/upload/manifest": {
+        "/keys/{id}": {
+            "get": {
+                "description": "Deliver the AES-128 key of an encrypted track to an entitled client.\nThe entitlement service receives the entitlement token and the bstudio client id, never the bstudio credentials.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Get HLS content key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Token issued to the player by the entitlement service",
+                        "name": "X-Entitlement-Token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "16 bytes key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing credentials",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "Not entitled",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "404": {
+                        "description": "Key not found",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/manifests/verify": {
             "post": {
-                "description": "Upload, create and publish to ipfs a manifest data",
+                "description": "Check the artist signature embedded in the manifest DAG at cid,\nor the given manifest and signature envelope before uploading them.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "upload"
+                    "manifests"
                 ],
-                "summary": "Upload and create raw data",
+                "summary": "Verify a manifest signature",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Manifest CID",
+                        "name": "cid",
+                        "in": "formData"
+                    },
                     {
                         "type": "string",
                         "description": "Manifest",
                         "name": "manifest",
-                        "in": "formData",
-                        "required": true
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ADR-036 signature envelope",
+                        "name": "signature",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Manifest ID the signature covers, empty for the first version",
+                        "name": "id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Manifest version the signature covers, 1 by default",
+                        "name": "version",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/server.UploadCidResp"
+                            "$ref": "#/definitions/server.VerifyManifestResp"
                         }
                     },
                     "400": {
@@ -132,24 +307,30 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/server.ErrorJson"
                         }
+                    },
+                    "404": {
+                        "description": "Unknown manifest",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
                     }
                 }
             }
         },
-        "/upload/{cid}/status": {
+        "/manifests/{cid}/car": {
             "get": {
-                "description": "Get upload status by ID.",
+                "description": "Export the release or track DAG with every linked artifact as a CAR file.",
                 "produces": [
-                    "application/json"
+                    "application/octet-stream"
                 ],
                 "tags": [
-                    "upload"
+                    "manifests"
                 ],
-                "summary": "Get upload status",
+                "summary": "Export a manifest as CAR",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "CID",
+                        "description": "Manifest CID",
                         "name": "cid",
                         "in": "path",
                         "required": true
@@ -157,67 +338,1088 @@ var doc = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "CAR file",
                         "schema": {
-                            "$ref": "#/definitions/server.UploadStatusResp"
+                            "type": "string"
                         }
                     },
-                    "400": {
-                        "description": "Failure to parse the id",
+                    "404": {
+                        "description": "Unknown manifest",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorJson"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "500": {
+                        "description": "Error",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorJson"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "server.ErrorJson": {
-            "type": "object",
-            "properties": {
-                "error": {
-                    "type": "object",
-                    "$ref": "#/definitions/server.ErrorJsonBody"
-                }
-            }
         },
-        "server.ErrorJsonBody": {
-            "type": "object",
-            "properties": {
-                "message": {
-                    "type": "string"
+        "/manifests/{id}": {
+            "get": {
+                "description": "Get the pointer to the latest version of a manifest.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "manifests"
+                ],
+                "summary": "Get a manifest",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Manifest ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/bstudio.ManifestPointer"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown manifest",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
                 }
-            }
-        },
-        "server.UploadCidResp": {
-            "type": "object",
-            "properties": {
-                "cid": {
-                    "type": "string"
-                },
-                "filename": {
-                    "type": "string"
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Publish a new version of the manifest, linked to the previous one.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "manifests"
+                ],
+                "summary": "Update a manifest",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Manifest ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Manifest",
+                        "name": "manifest",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ADR-036 signature envelope, by one of the artists, of the line bstudio-manifest:{id}:{version} followed by the manifest",
+                        "name": "signature",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UploadManifestResp"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid api key",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "The api key lacks the scope",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown manifest",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "409": {
+                        "description": "The manifest type changed, or another version was published meanwhile",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid manifest, with the field errors in details",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
                 }
             }
         },
-        "server.UploadStatusResp": {
-            "type": "object",
-            "properties": {
-                "id": {
-                    "type": "string"
-                },
-                "percentage": {
+        "/manifests/{id}/history": {
+            "get": {
+                "description": "Get every version of a manifest, the latest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "manifests"
+                ],
+                "summary": "Get manifest history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Manifest ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/bstudio.ManifestVersion"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown manifest",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/schemas/{type}/{version}": {
+            "get": {
+                "description": "Get the JSON Schema a manifest declares in its schema field, e.g. track/v1 or release/v1.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "manifests"
+                ],
+                "summary": "Get manifest schema",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Manifest type",
+                        "name": "type",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Schema version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "JSON Schema document",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown schema",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/audio": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Upload, transcode and publish to ipfs an audio\nThe minutes quota is counted from the probed duration when the upload is accepted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Upload and transcode audio file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Audio file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Encrypt the HLS segments with a per-track AES-128 key",
+                        "name": "encrypt",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UploadCidResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid api key",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "The api key lacks the scope",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or quota exceeded, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "503": {
+                        "description": "Shutting down, retry on another instance",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/image": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Upload, create and publish to ipfs the renditions of an image as one directory.\nImages are rotated upright from their EXIF orientation, converted to sRGB from their ICC profile, and every EXIF, XMP and ICC block is stripped.\nNear duplicates of an image already processed for the same client are flagged, or answered with the existing CID.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Upload and create image file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated presets (cover, avatar, banner), default cover",
+                        "name": "presets",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Crop to the preset aspect ratio instead of rejecting it: center or saliency",
+                        "name": "crop",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UploadImageResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid api key",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "The api key lacks the scope",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "413": {
+                        "description": "Image larger than the upload limit or with too many pixels",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported image format",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "422": {
+                        "description": "Image does not meet the preset policy",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or quota exceeded, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/manifest": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Validate a track or release manifest against its schema, then publish it to ipfs\nas a DAG linking every artifact, recursively pinned from its root.\nEvery referenced cid must have been processed by this instance.\nThe returned id is a stable pointer to the latest version of the manifest.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Upload and create raw data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Manifest",
+                        "name": "manifest",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ADR-036 signature envelope, by one of the artists, of the line bstudio-manifest::1 followed by the manifest",
+                        "name": "signature",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UploadManifestResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid api key",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "The api key lacks the scope",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid manifest, with the field errors in details",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/video": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Upload, transcode and publish to ipfs a video as H.264/AAC HLS with poster and thumbnails sprite\nThe minutes quota is counted from the probed duration when the upload is accepted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Upload and transcode video file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Video file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Encrypt the HLS segments with a per-track AES-128 key",
+                        "name": "encrypt",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UploadCidResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid api key",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "The api key lacks the scope",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "415": {
+                        "description": "Wrong content type",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or quota exceeded, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "503": {
+                        "description": "Shutting down, retry on another instance",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/{id}/status": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the status of the transcoding job of an upload, by the job id returned by the upload.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Get upload status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/bstudio.TranscodeStatus"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid api key",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "The api key lacks the scope",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown job",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        },
+        "/usage": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Sum the usage events of the jobs per client, and per UTC day or month, for billing.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "usage"
+                ],
+                "summary": "Get a usage report",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Start, 2006-01-02 or RFC 3339, default the first day of the month",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End excluded, 2006-01-02 or RFC 3339, default now",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this client, e.g. key:\u003cid\u003e or address:\u003caddress\u003e",
+                        "name": "client",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "client (default), day or month",
+                        "name": "group_by",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UsageReportResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid parameters",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid api key",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    },
+                    "403": {
+                        "description": "The api key lacks the scope",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorJson"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "bstudio.ImageDuplicate": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "distance": {
+                    "type": "integer"
+                }
+            }
+        },
+        "bstudio.ImageInfo": {
+            "type": "object",
+            "properties": {
+                "api_key_id": {
+                    "type": "string"
+                },
+                "cid": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "duplicate_of": {
+                    "type": "object",
+                    "$ref": "#/definitions/bstudio.ImageDuplicate"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "type": "object",
+                    "$ref": "#/definitions/bstudio.ImageMetadata"
+                },
+                "original_cid": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "phash": {
+                    "type": "string"
+                },
+                "placeholder": {
+                    "type": "object",
+                    "$ref": "#/definitions/bstudio.ImagePlaceholder"
+                },
+                "renditions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/bstudio.ImageRendition"
+                    }
+                }
+            }
+        },
+        "bstudio.ImageMetadata": {
+            "type": "object",
+            "properties": {
+                "color_converted": {
+                    "type": "boolean"
+                },
+                "icc": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "orientation": {
+                    "type": "integer"
+                },
+                "removed": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "bstudio.ImagePlaceholder": {
+            "type": "object",
+            "properties": {
+                "blurhash": {
+                    "type": "string"
+                },
+                "dominant_color": {
+                    "type": "string"
+                },
+                "palette": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "bstudio.ImageRendition": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "cid": {
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "preset": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "string"
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "bstudio.ManifestPointer": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ipns": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "schema": {
+                    "type": "string"
+                },
+                "tracks": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "bstudio.ManifestVersion": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "previous": {
+                    "type": "string"
+                },
+                "signer": {
+                    "type": "string"
+                },
+                "tracks": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "bstudio.TranscodeStatus": {
+            "type": "object",
+            "properties": {
+                "api_key_id": {
+                    "type": "string"
+                },
+                "cid": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "hls_cid": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key_id": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "percentage": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "description": "reason code when a limit was hit",
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "stage": {
+                    "description": "stage that failed",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "bstudio.UsageReportRow": {
+            "type": "object",
+            "properties": {
+                "client": {
+                    "type": "string"
+                },
+                "cpu_seconds": {
+                    "type": "number"
+                },
+                "failed_jobs": {
+                    "type": "integer"
+                },
+                "input_bytes": {
+                    "type": "integer"
+                },
+                "jobs": {
+                    "type": "integer"
+                },
+                "minutes": {
+                    "type": "number"
+                },
+                "output_bytes": {
+                    "type": "integer"
+                },
+                "period": {
+                    "type": "string"
+                },
+                "pinned_bytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "bstudio.ValidationError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "server.AuthChallengeResp": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "nonce": {
+                    "type": "string"
+                }
+            }
+        },
+        "server.ErrorJson": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "object",
+                    "$ref": "#/definitions/server.ErrorJsonBody"
+                }
+            }
+        },
+        "server.ErrorJsonBody": {
+            "type": "object",
+            "properties": {
+                "details": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/bstudio.ValidationError"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "server.LoginResp": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "token": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "server.UploadCidResp": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "filename": {
                     "type": "string"
+                },
+                "job_id": {
+                    "description": "JobID is the id of the transcoding job, to follow with /upload/{id}/status",
+                    "type": "string"
+                }
+            }
+        },
+        "server.UploadImageResp": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "deduplicated": {
+                    "type": "boolean"
+                },
+                "duplicate": {
+                    "type": "object",
+                    "$ref": "#/definitions/bstudio.ImageDuplicate"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "type": "object",
+                    "$ref": "#/definitions/bstudio.ImageMetadata"
+                },
+                "phash": {
+                    "type": "string"
+                },
+                "placeholder": {
+                    "type": "object",
+                    "$ref": "#/definitions/bstudio.ImagePlaceholder"
+                },
+                "renditions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/bstudio.ImageRendition"
+                    }
+                }
+            }
+        },
+        "server.UploadManifestResp": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "codec": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ipns": {
+                    "type": "string"
+                },
+                "tracks": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "server.UsageReportResp": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "group_by": {
+                    "type": "string"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/bstudio.UsageReportRow"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "server.VerifyManifestResp": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "signer": {
+                    "type": "string"
+                },
+                "valid": {
+                    "type": "boolean"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        }
     }
 }`
 
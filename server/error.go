@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"github.com/bitsongofficial/bstudio/bstudio"
 	"net/http"
 )
 
@@ -13,7 +14,8 @@ func writeJSONResponse(rw http.ResponseWriter, code int, result interface{}) {
 }
 
 type ErrorJsonBody struct {
-	Message string `json:"message"`
+	Message string                    `json:"message"`
+	Details []bstudio.ValidationError `json:"details,omitempty"`
 }
 
 type ErrorJson struct {
@@ -27,3 +29,12 @@ func newErrorJson(message string) ErrorJson {
 		},
 	}
 }
+
+func newValidationErrorJson(message string, errs bstudio.ValidationErrors) ErrorJson {
+	return ErrorJson{
+		Error: ErrorJsonBody{
+			Message: message,
+			Details: errs,
+		},
+	}
+}
@@ -2,54 +2,141 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/bitsongofficial/bstudio/bstudio"
 	_ "github.com/bitsongofficial/bstudio/server/docs"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/rs/zerolog/log"
 	httpswagger "github.com/swaggo/http-swagger"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	methodGET  = "GET"
 	methodPOST = "POST"
+	methodPUT  = "PUT"
+
+	entitlementTokenHeader = "X-Entitlement-Token"
+
+	maxAudioUploadSize = 1 << 30
+	maxVideoUploadSize = 2 << 30
+	defaultImagePreset = "cover"
 )
 
 // RegisterRoutes registers all HTTP routes with the provided mux router.
 func RegisterRoutes(r *mux.Router, bs *bstudio.BStudio) {
-	r.PathPrefix("/swagger/").Handler(httpswagger.WrapHandler)
-	r.HandleFunc("/api/v1/upload/audio", uploadAudioHandler(bs)).Methods(methodPOST)
-	r.HandleFunc("/api/v1/upload/image", uploadImageHandler(bs)).Methods(methodPOST)
-	r.HandleFunc("/api/v1/upload/manifest", uploadManifestHandler(bs)).Methods(methodPOST)
-	r.HandleFunc("/api/v1/upload/{cid}/status", uploadStatusHandler(bs)).Methods(methodGET)
+	r.PathPrefix("/swagger/").Handler(httpswagger.WrapHandler).Name(routeSwagger)
+	r.HandleFunc("/api/v1/auth/challenge", rateLimit(bs, bstudio.RouteGroupAuth, authChallengeHandler(bs))).Methods(methodPOST).Name(routeAuthChallenge)
+	r.HandleFunc("/api/v1/auth/login", rateLimit(bs, bstudio.RouteGroupAuth, loginHandler(bs))).Methods(methodPOST).Name(routeLogin)
+	r.HandleFunc("/api/v1/upload/audio", uploadTimeout(bs, rateLimit(bs, bstudio.RouteGroupUpload, uploadAudioHandler(bs)))).Methods(methodPOST).Name(routeUploadAudio)
+	r.HandleFunc("/api/v1/upload/video", uploadTimeout(bs, rateLimit(bs, bstudio.RouteGroupUpload, uploadVideoHandler(bs)))).Methods(methodPOST).Name(routeUploadVideo)
+	r.HandleFunc("/api/v1/upload/image", rateLimit(bs, bstudio.RouteGroupUpload, uploadImageHandler(bs))).Methods(methodPOST).Name(routeUploadImage)
+	r.HandleFunc("/api/v1/upload/manifest", rateLimit(bs, bstudio.RouteGroupManifest, uploadManifestHandler(bs))).Methods(methodPOST).Name(routeUploadManifest)
+	r.HandleFunc("/api/v1/upload/{id}/status", rateLimit(bs, bstudio.RouteGroupRead, uploadStatusHandler(bs))).Methods(methodGET).Name(routeUploadStatus)
+	r.HandleFunc("/api/v1/images/{cid}", rateLimit(bs, bstudio.RouteGroupRead, imageHandler(bs))).Methods(methodGET).Name(routeImage)
+	r.HandleFunc("/api/v1/keys/{id}", rateLimit(bs, bstudio.RouteGroupRead, contentKeyHandler(bs))).Methods(methodGET).Name(routeContentKey)
+	r.HandleFunc("/api/v1/manifests/verify", rateLimit(bs, bstudio.RouteGroupManifest, verifyManifestHandler(bs))).Methods(methodPOST).Name(routeVerifyManifest)
+	r.HandleFunc("/api/v1/manifests/{id}", rateLimit(bs, bstudio.RouteGroupRead, manifestHandler(bs))).Methods(methodGET).Name(routeManifest)
+	r.HandleFunc("/api/v1/manifests/{id}", rateLimit(bs, bstudio.RouteGroupManifest, updateManifestHandler(bs))).Methods(methodPUT).Name(routeUpdateManifest)
+	r.HandleFunc("/api/v1/manifests/{id}/history", rateLimit(bs, bstudio.RouteGroupRead, manifestHistoryHandler(bs))).Methods(methodGET).Name(routeManifestHistory)
+	r.HandleFunc("/api/v1/manifests/{cid}/car", rateLimit(bs, bstudio.RouteGroupRead, manifestCarHandler(bs))).Methods(methodGET).Name(routeManifestCar)
+	r.HandleFunc("/api/v1/usage", rateLimit(bs, bstudio.RouteGroupRead, usageReportHandler(bs))).Methods(methodGET).Name(routeUsage)
+	r.HandleFunc("/api/v1/schemas/{type}/{version}", rateLimit(bs, bstudio.RouteGroupRead, manifestSchemaHandler())).Methods(methodGET).Name(routeSchema)
+
+	registerHealth(r, bs)
+	r.Use(routeMiddleware)
+	registerMetrics(r, bs)
+	r.Use(ipRateLimit(bs), authMiddleware(bs))
 }
 
 type UploadCidResp struct {
 	CID      string `json:"cid"`
 	FileName string `json:"filename"`
+	// JobID is the id of the transcoding job, to follow with /upload/{id}/status
+	JobID string `json:"job_id,omitempty"`
+}
+
+type UploadImageResp struct {
+	CID          string                    `json:"cid"`
+	FileName     string                    `json:"filename"`
+	Renditions   []*bstudio.ImageRendition `json:"renditions"`
+	Metadata     *bstudio.ImageMetadata    `json:"metadata"`
+	Placeholder  *bstudio.ImagePlaceholder `json:"placeholder"`
+	PHash        string                    `json:"phash"`
+	Duplicate    *bstudio.ImageDuplicate   `json:"duplicate,omitempty"`
+	Deduplicated bool                      `json:"deduplicated"`
+}
+
+func newUploadImageResp(info *bstudio.ImageInfo) UploadImageResp {
+	return UploadImageResp{
+		CID:         info.Cid,
+		FileName:    info.FileName,
+		Renditions:  info.Renditions,
+		Metadata:    info.Metadata,
+		Placeholder: info.Placeholder,
+		PHash:       info.PHash,
+	}
+}
+
+type UploadManifestResp struct {
+	ID      string   `json:"id"`
+	CID     string   `json:"cid"`
+	Version int      `json:"version"`
+	Codec   string   `json:"codec"`
+	Tracks  []string `json:"tracks,omitempty"`
+	Ipns    string   `json:"ipns,omitempty"`
 }
 
-type UploadStatusResp struct {
-	ID         string `json:"id"`
-	Percentage string `json:"percentage"`
+func newUploadManifestResp(p *bstudio.ManifestPointer, codec string) UploadManifestResp {
+	return UploadManifestResp{
+		ID:      p.ID,
+		CID:     p.Cid,
+		Version: p.Version,
+		Codec:   codec,
+		Tracks:  p.Tracks,
+		Ipns:    p.Ipns,
+	}
+}
+
+type VerifyManifestResp struct {
+	Valid  bool   `json:"valid"`
+	Signer string `json:"signer,omitempty"`
+	Error  string `json:"error,omitempty"`
 }
 
 // @Summary Upload and transcode audio file
 // @Description Upload, transcode and publish to ipfs an audio
+// @Description The minutes quota is counted from the probed duration when the upload is accepted.
 // @Tags upload
 // @Produce json
 // @Param file formData file true "Audio file"
+// @Param encrypt formData bool false "Encrypt the HLS segments with a per-track AES-128 key"
 // @Success 200 {object} server.UploadCidResp
 // @Failure 400 {object} server.ErrorJson "Error"
+// @Security ApiKeyAuth
+// @Failure 401 {object} server.ErrorJson "Missing or invalid api key"
+// @Failure 403 {object} server.ErrorJson "The api key lacks the scope"
+// @Failure 429 {object} server.ErrorJson "Rate limit or quota exceeded, see Retry-After"
+// @Failure 503 {object} server.ErrorJson "Shutting down, retry on another instance"
 // @Router /upload/audio [post]
 func uploadAudioHandler(bs *bstudio.BStudio) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseMultipartForm(32 << 20); err != nil {
-			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("file size is greater then 32mb"))
+		// the quota is checked from the declared size, before the body is read
+		quota := &uploadQuota{bs: bs}
+		defer quota.release(r)
+		if !quota.reserveBody(w, r) {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxAudioUploadSize)
+		if err := r.ParseMultipartForm(bs.UploadMemory); err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("file size is greater then 1gb"))
 			return
 		}
 
@@ -60,44 +147,67 @@ func uploadAudioHandler(bs *bstudio.BStudio) http.HandlerFunc {
 		}
 		defer file.Close()
 
+		if !quota.reserveFile(w, r, header.Size) {
+			return
+		}
+
+		encrypt := r.FormValue("encrypt") == "true"
+		if encrypt && bs.Keys == nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("hls encryption is not configured"))
+			return
+		}
+
 		upload := bstudio.NewUpload(bs, header, file)
-		log.Info().Str("filename", header.Filename).Msg("handling audio upload...")
+		requestLog(r).Info().Str("filename", header.Filename).Msg("handling audio upload...")
 
 		// check if the file is audio
-		log.Info().Str("filename", header.Filename).Msg("check if the file is audio")
+		requestLog(r).Info().Str("filename", header.Filename).Msg("check if the file is audio")
 		if !upload.IsAudio() {
 			//uploader.RemoveAll()
 
-			log.Error().Str("content-type", upload.GetContentType()).Msg("Wrong content type")
+			requestLog(r).Error().Str("content-type", upload.GetContentType()).Msg("Wrong content type")
 			writeJSONResponse(w, http.StatusUnsupportedMediaType, newErrorJson(fmt.Sprintf("Wrong content type: %s", upload.GetContentType())))
 			return
 		}
+		if !quota.reserveMinutes(w, r, upload) {
+			return
+		}
 
 		// save original file
 		cid, err := upload.StoreOriginal()
 		if err != nil {
 			//uploader.RemoveAll()
-			log.Error().Str("filename", header.Filename).Msg("Cannot move audio file to ipfs")
+			requestLog(r).Error().Str("filename", header.Filename).Msg("Cannot move audio file to ipfs")
 			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(fmt.Sprintf("Cannot move audio file to ipfs %s", header.Filename)))
 			return
 		}
-		log.Info().Str("cid: ", cid).Msg("stored file name " + header.Filename)
+		requestLog(r).Info().Str("cid: ", cid).Msg("stored file name " + header.Filename)
 
 		// check file size
 		// check duration
 		ts := bstudio.NewTranscoder(bs, cid)
-		bs.TQueue <- ts
+		ts.SetEncrypted(encrypt)
+		ts.SetPrincipal(requestPrincipal(r))
+		ts.SetClient(requestClient(r))
+		ts.SetRequestID(requestID(r))
+		if err := bs.Enqueue(ts); err != nil {
+			requestLog(r).Error().Err(err).Str("cid", cid).Msg("cannot enqueue transcoding job")
+			writeJSONResponse(w, http.StatusServiceUnavailable, newErrorJson(err.Error()))
+			return
+		}
+		quota.accept()
 
 		res := UploadCidResp{
 			CID:      cid,
 			FileName: header.Filename,
+			JobID:    ts.ID(),
 		}
 
 		bz, err := json.Marshal(res)
 		if err != nil {
 			//uploader.RemoveAll()
 
-			log.Error().Str("filename", header.Filename).Msg("Failed to encode response")
+			requestLog(r).Error().Str("filename", header.Filename).Msg("Failed to encode response")
 			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(fmt.Sprintf("failed to encode response: %s", err.Error())))
 			return
 		}
@@ -107,18 +217,136 @@ func uploadAudioHandler(bs *bstudio.BStudio) http.HandlerFunc {
 	}
 }
 
+// @Summary Upload and transcode video file
+// @Description Upload, transcode and publish to ipfs a video as H.264/AAC HLS with poster and thumbnails sprite
+// @Description The minutes quota is counted from the probed duration when the upload is accepted.
+// @Tags upload
+// @Produce json
+// @Param file formData file true "Video file"
+// @Param encrypt formData bool false "Encrypt the HLS segments with a per-track AES-128 key"
+// @Success 200 {object} server.UploadCidResp
+// @Failure 400 {object} server.ErrorJson "Error"
+// @Failure 415 {object} server.ErrorJson "Wrong content type"
+// @Security ApiKeyAuth
+// @Failure 401 {object} server.ErrorJson "Missing or invalid api key"
+// @Failure 403 {object} server.ErrorJson "The api key lacks the scope"
+// @Failure 429 {object} server.ErrorJson "Rate limit or quota exceeded, see Retry-After"
+// @Failure 503 {object} server.ErrorJson "Shutting down, retry on another instance"
+// @Router /upload/video [post]
+func uploadVideoHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the quota is checked from the declared size, before the body is read
+		quota := &uploadQuota{bs: bs}
+		defer quota.release(r)
+		if !quota.reserveBody(w, r) {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxVideoUploadSize)
+		if err := r.ParseMultipartForm(bs.UploadMemory); err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("file size is greater then 2gb"))
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("file field is required"))
+			return
+		}
+		defer file.Close()
+
+		if !quota.reserveFile(w, r, header.Size) {
+			return
+		}
+
+		encrypt := r.FormValue("encrypt") == "true"
+		if encrypt && bs.Keys == nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("hls encryption is not configured"))
+			return
+		}
+
+		upload := bstudio.NewUpload(bs, header, file)
+		requestLog(r).Info().Str("filename", header.Filename).Msg("handling video upload...")
+
+		if !upload.IsVideo(r.Context()) {
+			requestLog(r).Error().Str("content-type", upload.GetContentType()).Msg("Wrong content type")
+			writeJSONResponse(w, http.StatusUnsupportedMediaType, newErrorJson(fmt.Sprintf("Wrong content type: %s", upload.GetContentType())))
+			return
+		}
+		if !quota.reserveMinutes(w, r, upload) {
+			return
+		}
+
+		// save original file
+		cid, err := upload.StoreOriginal()
+		if err != nil {
+			requestLog(r).Error().Str("filename", header.Filename).Msg("Cannot move video file to ipfs")
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(fmt.Sprintf("Cannot move video file to ipfs %s", header.Filename)))
+			return
+		}
+		requestLog(r).Info().Str("cid", cid).Msg("stored file name " + header.Filename)
+
+		ts := bstudio.NewVideoTranscoder(bs, cid)
+		ts.SetEncrypted(encrypt)
+		ts.SetPrincipal(requestPrincipal(r))
+		ts.SetClient(requestClient(r))
+		ts.SetRequestID(requestID(r))
+		if err := bs.Enqueue(ts); err != nil {
+			requestLog(r).Error().Err(err).Str("cid", cid).Msg("cannot enqueue transcoding job")
+			writeJSONResponse(w, http.StatusServiceUnavailable, newErrorJson(err.Error()))
+			return
+		}
+		quota.accept()
+
+		res := UploadCidResp{
+			CID:      cid,
+			FileName: header.Filename,
+			JobID:    ts.ID(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
 // @Summary Upload and create image file
-// @Description Upload, create and publish to ipfs an image
+// @Description Upload, create and publish to ipfs the renditions of an image as one directory.
+// @Description Images are rotated upright from their EXIF orientation, converted to sRGB from their ICC profile, and every EXIF, XMP and ICC block is stripped.
+// @Description Near duplicates of an image already processed for the same client are flagged, or answered with the existing CID.
 // @Tags upload
 // @Produce json
 // @Param file formData file true "Image file"
-// @Success 200 {object} server.UploadCidResp
+// @Param presets formData string false "Comma separated presets (cover, avatar, banner), default cover"
+// @Param crop formData string false "Crop to the preset aspect ratio instead of rejecting it: center or saliency"
+// @Success 200 {object} server.UploadImageResp
 // @Failure 400 {object} server.ErrorJson "Error"
+// @Failure 413 {object} server.ErrorJson "Image larger than the upload limit or with too many pixels"
+// @Failure 415 {object} server.ErrorJson "Unsupported image format"
+// @Failure 422 {object} server.ErrorJson "Image does not meet the preset policy"
+// @Security ApiKeyAuth
+// @Failure 401 {object} server.ErrorJson "Missing or invalid api key"
+// @Failure 403 {object} server.ErrorJson "The api key lacks the scope"
+// @Failure 429 {object} server.ErrorJson "Rate limit or quota exceeded, see Retry-After"
 // @Router /upload/image [post]
 func uploadImageHandler(bs *bstudio.BStudio) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseMultipartForm(5 << 20); err != nil {
-			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("file size is greater then 5mb"))
+		// the size is checked before anything is read, a body without a declared size is cut at the limit
+		tooLarge := fmt.Sprintf("file size is greater than %dmb", bs.ImageMaxSize>>20)
+		if r.ContentLength > bs.ImageMaxSize {
+			writeJSONResponse(w, http.StatusRequestEntityTooLarge, newErrorJson(tooLarge))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, bs.ImageMaxSize)
+
+		// the quota is checked from the declared size, before the body is read
+		quota := &uploadQuota{bs: bs}
+		defer quota.release(r)
+		if !quota.reserveBody(w, r) {
+			return
+		}
+
+		if err := r.ParseMultipartForm(bs.ImageUploadMemory); err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(tooLarge))
 			return
 		}
 
@@ -129,113 +357,683 @@ func uploadImageHandler(bs *bstudio.BStudio) http.HandlerFunc {
 		}
 		defer file.Close()
 
-		log.Info().Str("filename", header.Filename).Msg("handling image upload...")
+		if !quota.reserveFile(w, r, header.Size) {
+			return
+		}
+
+		names := []string{defaultImagePreset}
+		if v := r.FormValue("presets"); v != "" {
+			names = strings.Split(v, ",")
+		}
+		presets, err := bstudio.FindImagePresets(bs.ImagePresets, names)
+		if err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(err.Error()))
+			return
+		}
+
+		// the crop mode applies to the presets enforcing an aspect ratio, e.g. cover
+		crop := r.FormValue("crop")
+		if crop != bstudio.CropNone && crop != bstudio.CropCenter && crop != bstudio.CropSaliency {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(fmt.Sprintf("unknown crop mode %s, expected center or saliency", crop)))
+			return
+		}
+		for i := range presets {
+			if crop != bstudio.CropNone && presets[i].Policy != nil && presets[i].Policy.Aspect > 0 {
+				presets[i].Crop = crop
+			}
+		}
+
+		requestLog(r).Info().Str("filename", header.Filename).Msg("handling image upload...")
+
+		upload := bstudio.NewUpload(bs, header, file)
+		if !upload.IsImage() {
+			contentType, _ := upload.DetectContentType()
+			requestLog(r).Error().Str("content-type", contentType).Msg("Wrong content type")
+			writeJSONResponse(w, http.StatusUnsupportedMediaType, newErrorJson(fmt.Sprintf("Unsupported image format %s, expected jpeg, png, gif or webp", contentType)))
+			return
+		}
+
+		// the rendering is billed from the decoding, failed or not
+		job := startImageJob()
+		defer job.record(r, bs, header.Size)
 
-		image, err := bstudio.NewImage(file)
+		image, err := bs.NewImage(file)
+		job.image = image
+		if errors.Is(err, bstudio.ErrUnsupportedImage) {
+			writeJSONResponse(w, http.StatusUnsupportedMediaType, newErrorJson(err.Error()))
+			return
+		}
+		if errors.Is(err, bstudio.ErrImageTooLarge) {
+			writeJSONResponse(w, http.StatusRequestEntityTooLarge, newErrorJson(err.Error()))
+			return
+		}
 		if err != nil {
 			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to create image object"))
 			return
 		}
+		defer image.Delete()
 
-		if err := image.Resize(); err != nil {
+		var verrs bstudio.ValidationErrors
+		for _, preset := range presets {
+			err := image.Validate(preset, header.Size)
+			if errs, ok := err.(bstudio.ValidationErrors); ok {
+				verrs = append(verrs, errs...)
+			} else if err != nil {
+				writeJSONResponse(w, http.StatusBadRequest, newErrorJson(err.Error()))
+				return
+			}
+		}
+		if len(verrs) > 0 {
+			writeJSONResponse(w, http.StatusUnprocessableEntity, newValidationErrorJson("image does not meet the preset policy", verrs))
+			return
+		}
+
+		// near duplicates of the images of the same client are answered with the image already stored
+		hash := image.PerceptualHash()
+		tenant := requestTenant(r)
+		var dup *bstudio.ImageDuplicate
+		if bs.DuplicatePolicy.Mode != bstudio.DuplicateOff {
+			if dup, err = bs.FindDuplicateImage(tenant, hash, bs.DuplicatePolicy.Threshold); err != nil {
+				writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot look for duplicates: %s", err)))
+				return
+			}
+		}
+		if dup != nil && bs.DuplicatePolicy.Mode == bstudio.DuplicateDedupe {
+			existing, err := bs.GetImageInfo(dup.Cid)
+			if err != nil {
+				writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot get image info: %s", err)))
+				return
+			}
+			if existing != nil && existing.HasPresets(presets) {
+				requestLog(r).Info().Str("filename", header.Filename).Str("cid", existing.Cid).Int("distance", dup.Distance).Msg("duplicate image, returning existing cid")
+
+				job.succeed("")
+
+				res := newUploadImageResp(existing)
+				res.Duplicate = dup
+				res.Deduplicated = true
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+		}
+
+		renditions, err := image.Render(presets)
+		if err != nil {
+			requestLog(r).Error().Err(err).Str("filename", header.Filename).Msg("Failed to render image")
 			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to resize image object"))
 			return
 		}
+		placeholder := image.Placeholder()
+		job.stopMeter()
+
+		var originalName string
+		if bs.KeepImageOriginal {
+			if originalName, err = image.WriteOriginal(); err != nil {
+				writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to write original image"))
+				return
+			}
+		}
 
 		// add to ipfs
-		imgObj, err := os.Open(image.GetTmpPath())
-		cid, err := bs.Add(imgObj)
+		cid, err := bs.AddDir(image.GetTmpPath())
 		if err != nil {
 			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to store image object"))
 			return
 		}
 
-		// Remove tmp object
-		if err := image.Delete(); err != nil {
-			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to delete image object"))
+		links, err := bs.List(cid)
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to list image object"))
 			return
 		}
+		var originalCid string
+		for _, link := range links {
+			if originalName != "" && link.Name == originalName {
+				originalCid = link.Hash
+			}
+			for _, rendition := range renditions {
+				if link.Name == rendition.FileName {
+					rendition.Cid = link.Hash
+				}
+			}
+		}
 
-		res := UploadCidResp{
-			CID: cid,
+		info := &bstudio.ImageInfo{
+			Cid:         cid,
+			FileName:    header.Filename,
+			OriginalCid: originalCid,
+			Renditions:  renditions,
+			Metadata:    image.GetMetadata(),
+			Placeholder: placeholder,
+			PHash:       hash,
+			DuplicateOf: dup,
+			Owner:       requestOwner(r),
+			APIKeyID:    requestAPIKeyID(r),
+			CreatedAt:   time.Now().UTC(),
 		}
+		if err := bs.SaveImageInfo(info); err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot save image info: %s", err)))
+			return
+		}
+		if err := bs.IndexImageHash(tenant, hash, cid); err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot index image hash: %s", err)))
+			return
+		}
+		quota.accept()
+		job.succeed(cid)
+
+		res := newUploadImageResp(info)
+		res.Duplicate = dup
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(res)
 	}
 }
 
+// @Summary Get image info or an on the fly rendition
+// @Description Without query parameters, get the renditions, BlurHash and palette of a processed image by its directory or rendition CID.
+// @Description With any of w, h, fit, format or q, render the image at that size from its original when kept, else its largest rendition, served from a disk cache.
+// @Tags images
+// @Produce json
+// @Produce image/jpeg
+// @Produce image/png
+// @Produce image/webp
+// @Param cid path string true "CID"
+// @Param w query int false "Width, must be an allowed dimension"
+// @Param h query int false "Height, must be an allowed dimension"
+// @Param fit query string false "contain (default), cover or fill"
+// @Param format query string false "jpeg (default), png or webp"
+// @Param q query int false "Quality, 1-100"
+// @Success 200 {object} bstudio.ImageInfo
+// @Success 304 {string} string "Not modified"
+// @Failure 400 {object} server.ErrorJson "Invalid rendition parameters"
+// @Failure 404 {object} server.ErrorJson "Unknown image"
+// @Router /images/{cid} [get]
+func imageHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+		info, err := bs.GetImageInfo(params["cid"])
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot get image info: %s", err)))
+			return
+		}
+		if info == nil {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson(fmt.Sprintf("Unknown image %s", params["cid"])))
+			return
+		}
+
+		if !bstudio.IsImageVariantQuery(r.URL.Query()) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(info)
+			return
+		}
+
+		if bs.ImageCache == nil {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson("image resizing is not configured"))
+			return
+		}
+
+		variant, err := bstudio.ParseImageVariant(r.URL.Query(), bs.ImageSizes)
+		if errs, ok := err.(bstudio.ValidationErrors); ok {
+			writeJSONResponse(w, http.StatusBadRequest, newValidationErrorJson("invalid image rendition", errs))
+			return
+		}
+
+		source := info.SourceCid()
+		key := variant.Key(source)
+
+		path, ok := bs.ImageCache.Get(key)
+		if !ok {
+			if path, err = renderImageVariant(bs, source, key, variant); err != nil {
+				requestLog(r).Error().Err(err).Str("cid", source).Msg("Failed to render image variant")
+				writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to render image"))
+				return
+			}
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			// evicted in the meantime
+			if path, err = renderImageVariant(bs, source, key, variant); err == nil {
+				f, err = os.Open(path)
+			}
+			if err != nil {
+				writeJSONResponse(w, http.StatusInternalServerError, newErrorJson("Failed to open image"))
+				return
+			}
+		}
+		defer f.Close()
+
+		// a cid never changes, neither does its rendition
+		w.Header().Set("Content-Type", variant.ContentType())
+		w.Header().Set("ETag", fmt.Sprintf("\"%s\"", strings.TrimSuffix(key, filepath.Ext(key))))
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeContent(w, r, "", time.Time{}, f)
+	}
+}
+
+func renderImageVariant(bs *bstudio.BStudio, source, key string, variant *bstudio.ImageVariant) (string, error) {
+	rc, err := bs.Cat(source)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	image, err := bs.NewImage(rc)
+	if err != nil {
+		return "", err
+	}
+
+	return bs.ImageCache.Put(key, func(path string) error {
+		return image.RenderVariant(variant, path)
+	})
+}
+
 // @Summary Upload and create raw data
-// @Description Upload, create and publish to ipfs a manifest data
+// @Description Validate a track or release manifest against its schema, then publish it to ipfs
+// @Description as a DAG linking every artifact, recursively pinned from its root.
+// @Description Every referenced cid must have been processed by this instance.
+// @Description The returned id is a stable pointer to the latest version of the manifest.
 // @Tags upload
 // @Produce json
 // @Param manifest formData string true "Manifest"
-// @Success 200 {object} server.UploadCidResp
+// @Param signature formData string false "ADR-036 signature envelope, by one of the artists, of the line bstudio-manifest::1 followed by the manifest"
+// @Success 200 {object} server.UploadManifestResp
 // @Failure 400 {object} server.ErrorJson "Error"
+// @Failure 422 {object} server.ErrorJson "Invalid manifest, with the field errors in details"
+// @Security ApiKeyAuth
+// @Failure 401 {object} server.ErrorJson "Missing or invalid api key"
+// @Failure 403 {object} server.ErrorJson "The api key lacks the scope"
 // @Router /upload/manifest [post]
 func uploadManifestHandler(bs *bstudio.BStudio) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		manifest := r.FormValue("manifest")
+		m, ok := parseManifestForm(w, r, bs, "", 1)
+		if !ok {
+			return
+		}
 
-		uid, err := uuid.NewUUID()
+		p, err := bs.CreateManifest(m, requestOwner(r))
 		if err != nil {
-			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Could not create a new uid: %s", err)))
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot store manifest: %s", err)))
 			return
 		}
 
-		// put manifest into tmp file
-		err = ioutil.WriteFile(fmt.Sprintf("/tmp/%s", uid.String()), []byte(manifest), 0644)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newUploadManifestResp(p, bs.ManifestCodec))
+	}
+}
+
+// parseManifestForm validates the manifest and signature form fields, the signature covering the given
+// version of the manifest id, and writes the error response when they are rejected.
+func parseManifestForm(w http.ResponseWriter, r *http.Request, bs *bstudio.BStudio, id string, version int) (*bstudio.Manifest, bool) {
+	m, err := bstudio.ParseManifest([]byte(r.FormValue("manifest")))
+	if errs, ok := err.(bstudio.ValidationErrors); ok {
+		writeJSONResponse(w, http.StatusUnprocessableEntity, newValidationErrorJson("invalid manifest", errs))
+		return nil, false
+	}
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, newErrorJson(fmt.Sprintf("Cannot parse manifest: %s", err)))
+		return nil, false
+	}
+
+	if signature := r.FormValue("signature"); signature != "" {
+		sig, err := bstudio.ParseSignatureEnvelope([]byte(signature))
+		if err == nil {
+			err = m.SetSignature(sig, id, version)
+		}
 		if err != nil {
-			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot save manifest: %s", err)))
+			var errs bstudio.ValidationErrors
+			if !errors.As(err, &errs) {
+				errs.Add("signature", "invalid", "%s", err)
+			}
+			writeJSONResponse(w, http.StatusUnprocessableEntity, newValidationErrorJson("invalid manifest signature", errs))
+			return nil, false
+		}
+	} else if bs.RequireSignatures {
+		var errs bstudio.ValidationErrors
+		errs.Add("signature", "required", "the manifest must be signed by one of its artists")
+		writeJSONResponse(w, http.StatusUnprocessableEntity, newValidationErrorJson("invalid manifest signature", errs))
+		return nil, false
+	}
+
+	err = bs.CheckManifestReferences(m, requestPrincipal(r))
+	if errs, ok := err.(bstudio.ValidationErrors); ok {
+		writeJSONResponse(w, http.StatusUnprocessableEntity, newValidationErrorJson("manifest references unknown content", errs))
+		return nil, false
+	}
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot check manifest references: %s", err)))
+		return nil, false
+	}
+
+	return m, true
+}
+
+// @Summary Update a manifest
+// @Description Publish a new version of the manifest, linked to the previous one.
+// @Tags manifests
+// @Produce json
+// @Param id path string true "Manifest ID"
+// @Param manifest formData string true "Manifest"
+// @Param signature formData string false "ADR-036 signature envelope, by one of the artists, of the line bstudio-manifest:{id}:{version} followed by the manifest"
+// @Success 200 {object} server.UploadManifestResp
+// @Failure 404 {object} server.ErrorJson "Unknown manifest"
+// @Failure 409 {object} server.ErrorJson "The manifest type changed, or another version was published meanwhile"
+// @Failure 422 {object} server.ErrorJson "Invalid manifest, with the field errors in details"
+// @Security ApiKeyAuth
+// @Failure 401 {object} server.ErrorJson "Missing or invalid api key"
+// @Failure 403 {object} server.ErrorJson "The api key lacks the scope"
+// @Router /manifests/{id} [put]
+func updateManifestHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+		current, err := bs.GetManifest(params["id"])
+		if err != nil {
+			writeManifestError(w, params["id"], err)
+			return
+		}
+		if p := requestPrincipal(r); p != nil && !p.Owns(current.Owner) {
+			writeJSONResponse(w, http.StatusForbidden, newErrorJson(fmt.Sprintf("manifest %s is owned by another address", params["id"])))
 			return
 		}
 
-		// get file
-		f, err := os.Open(fmt.Sprintf("/tmp/%s", uid.String()))
+		m, ok := parseManifestForm(w, r, bs, current.ID, current.Version+1)
+		if !ok {
+			return
+		}
+
+		p, err := bs.UpdateManifest(params["id"], m)
 		if err != nil {
-			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot get tmp manifest: %s", err)))
+			writeManifestError(w, params["id"], err)
 			return
 		}
-		defer f.Close()
 
-		cid, err := bs.Add(f)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newUploadManifestResp(p, bs.ManifestCodec))
+	}
+}
+
+// @Summary Verify a manifest signature
+// @Description Check the artist signature embedded in the manifest DAG at cid,
+// @Description or the given manifest and signature envelope before uploading them.
+// @Tags manifests
+// @Produce json
+// @Param cid formData string false "Manifest CID"
+// @Param manifest formData string false "Manifest"
+// @Param signature formData string false "ADR-036 signature envelope"
+// @Param id formData string false "Manifest ID the signature covers, empty for the first version"
+// @Param version formData int false "Manifest version the signature covers, 1 by default"
+// @Success 200 {object} server.VerifyManifestResp
+// @Failure 400 {object} server.ErrorJson "Error"
+// @Failure 404 {object} server.ErrorJson "Unknown manifest"
+// @Router /manifests/verify [post]
+func verifyManifestHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			sig *bstudio.SignatureEnvelope
+			err error
+		)
+
+		switch {
+		case r.FormValue("cid") != "":
+			sig, err = bs.VerifyManifestDag(r.Context(), r.FormValue("cid"))
+			if err == bstudio.ErrManifestNotFound {
+				writeJSONResponse(w, http.StatusNotFound, newErrorJson(fmt.Sprintf("Unknown manifest %s", r.FormValue("cid"))))
+				return
+			}
+			if err == bstudio.ErrUnsignedManifest || err == bstudio.ErrManifestTooLarge {
+				writeJSONResponse(w, http.StatusOK, VerifyManifestResp{Error: err.Error()})
+				return
+			}
+			var errs bstudio.ValidationErrors
+			if err != nil && !errors.As(err, &errs) {
+				writeJSONResponse(w, http.StatusBadGateway, newErrorJson(fmt.Sprintf("Cannot load manifest: %s", err)))
+				return
+			}
+		case r.FormValue("manifest") != "" && r.FormValue("signature") != "":
+			version := 1
+			if v := r.FormValue("version"); v != "" {
+				if version, err = strconv.Atoi(v); err != nil || version < 1 {
+					writeJSONResponse(w, http.StatusBadRequest, newErrorJson("version must be a positive integer"))
+					return
+				}
+			}
+
+			var m *bstudio.Manifest
+			m, err = bstudio.ParseManifest([]byte(r.FormValue("manifest")))
+			if err == nil {
+				sig, err = bstudio.ParseSignatureEnvelope([]byte(r.FormValue("signature")))
+			}
+			if err == nil {
+				err = m.SetSignature(sig, r.FormValue("id"), version)
+			}
+		default:
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("cid, or manifest and signature, are required"))
+			return
+		}
+
+		res := VerifyManifestResp{Valid: err == nil}
+		if sig != nil {
+			res.Signer = sig.Signer
+		}
 		if err != nil {
-			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot store manifest: %s", err)))
+			res.Error = err.Error()
+		}
+
+		writeJSONResponse(w, http.StatusOK, res)
+	}
+}
+
+// @Summary Get a manifest
+// @Description Get the pointer to the latest version of a manifest.
+// @Tags manifests
+// @Produce json
+// @Param id path string true "Manifest ID"
+// @Success 200 {object} bstudio.ManifestPointer
+// @Failure 404 {object} server.ErrorJson "Unknown manifest"
+// @Router /manifests/{id} [get]
+func manifestHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+		p, err := bs.GetManifest(params["id"])
+		if err != nil {
+			writeManifestError(w, params["id"], err)
 			return
 		}
 
-		res := UploadCidResp{
-			CID: cid,
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// @Summary Get manifest history
+// @Description Get every version of a manifest, the latest first.
+// @Tags manifests
+// @Produce json
+// @Param id path string true "Manifest ID"
+// @Success 200 {array} bstudio.ManifestVersion
+// @Failure 404 {object} server.ErrorJson "Unknown manifest"
+// @Router /manifests/{id}/history [get]
+func manifestHistoryHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+		history, err := bs.GetManifestHistory(params["id"])
+		if err != nil {
+			writeManifestError(w, params["id"], err)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(res)
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+func writeManifestError(w http.ResponseWriter, id string, err error) {
+	switch err {
+	case bstudio.ErrManifestNotFound:
+		writeJSONResponse(w, http.StatusNotFound, newErrorJson(fmt.Sprintf("Unknown manifest %s", id)))
+	case bstudio.ErrManifestType, bstudio.ErrStaleSignature:
+		writeJSONResponse(w, http.StatusConflict, newErrorJson(err.Error()))
+	default:
+		writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot process manifest %s: %s", id, err)))
+	}
+}
+
+// @Summary Export a manifest as CAR
+// @Description Export the release or track DAG with every linked artifact as a CAR file.
+// @Tags manifests
+// @Produce octet-stream
+// @Param cid path string true "Manifest CID"
+// @Success 200 {string} string "CAR file"
+// @Failure 404 {object} server.ErrorJson "Unknown manifest"
+// @Failure 500 {object} server.ErrorJson "Error"
+// @Router /manifests/{cid}/car [get]
+func manifestCarHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+		known, err := bs.IsManifestCid(params["cid"])
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot export %s: %s", params["cid"], err)))
+			return
+		}
+		if !known {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson(fmt.Sprintf("Unknown manifest %s", params["cid"])))
+			return
+		}
+
+		rc, err := bs.ExportCar(r.Context(), params["cid"])
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot export %s: %s", params["cid"], err)))
+			return
+		}
+		defer rc.Close()
+
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.car\"", params["cid"]))
+		if _, err := io.Copy(w, rc); err != nil {
+			requestLog(r).Error().Err(err).Str("cid", params["cid"]).Msg("Failed to export car")
+		}
+	}
+}
+
+// @Summary Get manifest schema
+// @Description Get the JSON Schema a manifest declares in its schema field, e.g. track/v1 or release/v1.
+// @Tags manifests
+// @Produce json
+// @Param type path string true "Manifest type"
+// @Param version path string true "Schema version"
+// @Success 200 {string} string "JSON Schema document"
+// @Failure 404 {object} server.ErrorJson "Unknown schema"
+// @Router /schemas/{type}/{version} [get]
+func manifestSchemaHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+		id := params["type"] + "/" + params["version"]
+
+		doc, ok := bstudio.ManifestSchema(id)
+		if !ok {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson(fmt.Sprintf("Unknown schema %s", id)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/schema+json")
+		_, _ = w.Write([]byte(doc))
 	}
 }
 
 // @Summary Get upload status
-// @Description Get upload status by ID.
+// @Description Get the status of the transcoding job of an upload, by the job id returned by the upload.
 // @Tags upload
 // @Produce json
-// @Param cid path string true "CID"
-// @Success 200 {object} server.UploadStatusResp
-// @Failure 400 {object} server.ErrorJson "Failure to parse the id"
-// @Failure 404 {object} server.ErrorJson to find the id"
-// @Router /upload/{cid}/status [get]
+// @Param id path string true "Job ID"
+// @Success 200 {object} bstudio.TranscodeStatus
+// @Failure 404 {object} server.ErrorJson "Unknown job"
+// @Security ApiKeyAuth
+// @Failure 401 {object} server.ErrorJson "Missing or invalid api key"
+// @Failure 403 {object} server.ErrorJson "The api key lacks the scope"
+// @Router /upload/{id}/status [get]
 func uploadStatusHandler(bs *bstudio.BStudio) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var params = mux.Vars(r)
-		res, err := bs.GetTranscodingStatus(params["cid"])
+		status, err := bs.GetJobStatus(params["id"])
 		if err != nil {
 			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot get transcode status: %s", err)))
 			return
 		}
 
-		var status bstudio.TranscodeStatus
-		err = json.Unmarshal(res, &status)
+		// wallet sessions only see their own jobs
+		if p := requestPrincipal(r); status == nil || p != nil && !p.Owns(status.Owner) {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson(fmt.Sprintf("Unknown upload %s", params["id"])))
+			return
+		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(status)
+		writeJSONResponse(w, http.StatusOK, status)
+	}
+}
+
+// @Summary Get HLS content key
+// @Description Deliver the AES-128 key of an encrypted track to an entitled client.
+// @Description The entitlement service receives the entitlement token and the bstudio client id, never the bstudio credentials.
+// @Tags keys
+// @Produce octet-stream
+// @Param id path string true "Key ID"
+// @Param X-Entitlement-Token header string false "Token issued to the player by the entitlement service"
+// @Success 200 {string} string "16 bytes key"
+// @Failure 401 {object} server.ErrorJson "Missing credentials"
+// @Failure 403 {object} server.ErrorJson "Not entitled"
+// @Failure 404 {object} server.ErrorJson "Key not found"
+// @Router /keys/{id} [get]
+func contentKeyHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bs.Keys == nil {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson("hls encryption is not configured"))
+			return
+		}
+
+		subject := bstudio.EntitlementSubject{Token: r.Header.Get(entitlementTokenHeader)}
+		p, ok := authenticate(bs, w, r)
+		if !ok {
+			return
+		}
+		if p != nil {
+			subject.Client = p.ClientID()
+		}
+		if subject.Token == "" && subject.Client == "" {
+			writeJSONResponse(w, http.StatusUnauthorized, newErrorJson("an entitlement token or bstudio credentials are required"))
+			return
+		}
+
+		var params = mux.Vars(r)
+		ck, err := bs.Keys.Get(params["id"])
+		if err == bstudio.ErrKeyNotFound {
+			writeJSONResponse(w, http.StatusNotFound, newErrorJson(err.Error()))
+			return
+		}
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot get content key: %s", err)))
+			return
+		}
+
+		if err := bs.Entitlement(subject, ck); err != nil {
+			requestLog(r).Info().Str("key_id", ck.ID).Str("cid", ck.Cid).Err(err).Msg("content key denied")
+			if err == bstudio.ErrNotEntitled || err == bstudio.ErrNoEntitlement {
+				writeJSONResponse(w, http.StatusForbidden, newErrorJson(err.Error()))
+				return
+			}
+			writeJSONResponse(w, http.StatusBadGateway, newErrorJson(fmt.Sprintf("Cannot check entitlement: %s", err)))
+			return
+		}
+
+		key, err := bs.Keys.Unwrap(ck)
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot unwrap content key: %s", err)))
+			return
+		}
 
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(key)
 	}
 }
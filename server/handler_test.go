@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionToken returns a wallet session of address granting scope.
+func sessionToken(t *testing.T, bs *bstudio.BStudio, address, scope string) string {
+	if bs.SessionSecret == nil {
+		bs.SessionSecret = []byte("secret")
+	}
+
+	token, err := bstudio.SignJWT(bs.SessionSecret, &bstudio.JWTClaims{
+		Issuer:    "bstudio",
+		Subject:   address,
+		Scope:     scope,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestUploadStatus_ByJob(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	r := testRouter(bs)
+
+	// two wallets upload the same content
+	alice := bstudio.NewTranscoder(bs, "QmSame")
+	alice.SetPrincipal(&bstudio.Principal{Address: "bitsong1alice"})
+	bob := bstudio.NewTranscoder(bs, "QmSame")
+	bob.SetPrincipal(&bstudio.Principal{Address: "bitsong1bob"})
+	require.NoError(t, bs.Enqueue(alice))
+	require.NoError(t, bs.Enqueue(bob))
+
+	get := func(id, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/upload/"+id+"/status", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(r, req)
+	}
+
+	aliceToken := sessionToken(t, bs, "bitsong1alice", bstudio.ScopeJobsRead)
+	w := get(alice.ID(), aliceToken)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var status bstudio.TranscodeStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Equal(t, alice.ID(), status.ID)
+	require.Equal(t, "QmSame", status.Cid)
+	require.Equal(t, "bitsong1alice", status.Owner)
+
+	// the job of bob stays his own
+	require.Equal(t, http.StatusNotFound, get(bob.ID(), aliceToken).Code)
+	require.Equal(t, http.StatusOK, get(bob.ID(), sessionToken(t, bs, "bitsong1bob", bstudio.ScopeJobsRead)).Code)
+
+	require.Equal(t, http.StatusNotFound, get("unknown", aliceToken).Code)
+}
+
+func TestManifestCar_UnknownCid(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	r := testRouter(bs)
+
+	// any cid of the network is not exported, only the stored manifests
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/api/v1/manifests/QmSomeoneElse/car", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestVerifyManifest_UnknownCid(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	r := testRouter(bs)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifests/verify", strings.NewReader("cid=QmSomeoneElse"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := serve(r, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// imageForm returns a multipart body with a file field of size bytes.
+func imageForm(t *testing.T, size int) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "cover.png")
+	require.NoError(t, err)
+	_, err = fw.Write(make([]byte, size))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	return &body, mw.FormDataContentType()
+}
+
+func TestUploadImage_MaxSize(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Auth = false
+	bs.ImageMaxSize = 1 << 20
+	r := testRouter(bs)
+
+	// a declared size over the limit is rejected without reading the body
+	body, contentType := imageForm(t, 2<<20)
+	size := body.Len()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/image", body)
+	req.Header.Set("Content-Type", contentType)
+	w := serve(r, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	require.Equal(t, size, body.Len())
+
+	// a body without a declared size is cut at the limit
+	body, contentType = imageForm(t, 2<<20)
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/upload/image", ioutil.NopCloser(body))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", contentType)
+	w = serve(r, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.True(t, body.Len() > 0)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// registerHealth mounts the probes of the orchestrator, outside of the api base path.
+// The readiness runs checks, it is only served on the internal connections.
+func registerHealth(r *mux.Router, bs *bstudio.BStudio) {
+	r.HandleFunc("/healthz", healthzHandler()).Methods(methodGET).Name(routeHealthz)
+	r.HandleFunc("/readyz", readyzHandler(bs)).Methods(methodGET).Name(routeReadyz)
+}
+
+// healthzHandler answers as long as the process serves requests.
+func healthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, http.StatusOK, bstudio.HealthReport{Status: bstudio.HealthOK, Checks: []*bstudio.HealthCheck{}})
+	}
+}
+
+// readyzHandler answers the report of the dependency checks, run at most every few seconds,
+// with 503 when one failed.
+func readyzHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := bs.CachedReadiness(r.Context())
+
+		code := http.StatusOK
+		if report.Status != bstudio.HealthOK {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSONResponse(w, code, report)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth_Probes(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Metrics = bstudio.NewMetrics(bs)
+
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	internal, err := ListenInternal([]string{"127.0.0.1:0"})
+	require.NoError(t, err)
+	require.Len(t, internal, 1)
+
+	srv := &http.Server{Handler: testRouter(bs), ConnContext: ConnContext}
+	go Serve(srv, append(internal, public))
+	defer srv.Close()
+
+	get := func(l net.Listener, path string) *http.Response {
+		res, err := http.Get("http://" + l.Addr().String() + path)
+		require.NoError(t, err)
+		return res
+	}
+
+	// the liveness is public
+	res := get(public, "/healthz")
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the readiness runs checks, it is only served internally
+	res = get(public, "/readyz")
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = get(internal[0], "/readyz")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	var report bstudio.HealthReport
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+	require.Equal(t, bstudio.HealthFail, report.Status)
+	require.NotEmpty(t, report.Checks)
+
+	// so are the metrics without credentials
+	res = get(internal[0], "/metrics")
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = get(public, "/metrics")
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestHealth_ReadyzFailsOnceStopping(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	r := testRouter(bs)
+
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	ctx := ConnContext(context.Background(), &internalConn{Conn: conn})
+
+	bs.StopAccepting()
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var report bstudio.HealthReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Len(t, report.Checks, 1)
+	require.Equal(t, bstudio.CheckStopping, report.Checks[0].Name)
+
+	// the liveness still answers
+	require.Equal(t, http.StatusOK, serve(r, httptest.NewRequest(http.MethodGet, "/healthz", nil)).Code)
+}
+
+func TestHealth_ReadyzNotPublicWithoutAuth(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Auth = false
+
+	require.Equal(t, http.StatusNotFound, serve(testRouter(bs), httptest.NewRequest(http.MethodGet, "/readyz", nil)).Code)
+}
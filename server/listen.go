@@ -0,0 +1,194 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// TLSFiles are the PEM files of the server certificate and of the optional client CA,
+// ClientAuth applies when the client CA is set.
+type TLSFiles struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+}
+
+// TLSReloader serves the certificate and client CA last loaded from their files,
+// Reload swaps them without restarting the listeners.
+type TLSReloader struct {
+	files TLSFiles
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func NewTLSReloader(files TLSFiles) (*TLSReloader, error) {
+	r := &TLSReloader{files: files}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the files again, the previous certificate is kept when they are invalid.
+func (r *TLSReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load tls certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.files.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.files.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("cannot read tls client ca: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in tls client ca %s", r.files.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Config returns the server tls config, client certificates are checked when a client CA is set.
+func (r *TLSReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"http/1.1"},
+			}
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = r.files.ClientAuth
+			}
+
+			return cfg, nil
+		},
+	}
+}
+
+// Listen opens a TCP listener per address, wrapped in TLS when tlsConfig is set,
+// and a plain one on the unix socket when not empty.
+func Listen(addrs []string, unixSocket string, tlsConfig *tls.Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if unixSocket != "" {
+		// a socket left by a previous run would make the listen fail, anything else at the path is kept
+		if fi, err := os.Lstat(unixSocket); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				closeAll()
+				return nil, fmt.Errorf("%s exists and is not a unix socket", unixSocket)
+			}
+			if err := os.Remove(unixSocket); err != nil {
+				closeAll()
+				return nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			closeAll()
+			return nil, err
+		}
+		l, err := net.Listen("unix", unixSocket)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if err := os.Chmod(unixSocket, 0660); err != nil {
+			l.Close()
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listen address")
+	}
+
+	return listeners, nil
+}
+
+// internalConn is a connection accepted by an internal listener, its requests reach the internal routes without credentials.
+type internalConn struct {
+	net.Conn
+}
+
+type internalListener struct {
+	net.Listener
+}
+
+func (l internalListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &internalConn{Conn: c}, nil
+}
+
+// ListenInternal opens a plain TCP listener per address, serving the internal routes without credentials.
+func ListenInternal(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, internalListener{Listener: l})
+	}
+
+	return listeners, nil
+}
+
+// Serve serves srv on every listener and returns the first error.
+func Serve(srv *http.Server, listeners []net.Listener) error {
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errc <- srv.Serve(l)
+		}(l)
+	}
+
+	return <-errc
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self signed certificate of name and its key, and returns their paths.
+func writeCert(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
+
+// clientConfig returns the config the server picks for a client hello.
+func clientConfig(t *testing.T, r *TLSReloader) *tls.Config {
+	cfg, err := r.Config().GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	return cfg
+}
+
+func commonName(t *testing.T, cfg *tls.Config) string {
+	require.Len(t, cfg.Certificates, 1)
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	return cert.Subject.CommonName
+}
+
+func TestTLSReloader_KeepsCertOnBadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bstudio-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "first")
+	r, err := NewTLSReloader(TLSFiles{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	require.Equal(t, "first", commonName(t, clientConfig(t, r)))
+
+	// a half written renewal is refused
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("garbage"), 0600))
+	require.Error(t, r.Reload())
+	require.Equal(t, "first", commonName(t, clientConfig(t, r)))
+
+	// so is a client ca without certificates, with the new certificate
+	secondCert, secondKey := writeCert(t, dir, "second")
+	require.NoError(t, os.Rename(secondCert, certFile))
+	require.NoError(t, os.Rename(secondKey, keyFile))
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, []byte("no certificate"), 0600))
+	r.files.ClientCAFile = caFile
+	require.Error(t, r.Reload())
+	require.Equal(t, "first", commonName(t, clientConfig(t, r)))
+
+	r.files.ClientCAFile = ""
+	require.NoError(t, r.Reload())
+	require.Equal(t, "second", commonName(t, clientConfig(t, r)))
+}
+
+func TestTLSReloader_ClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bstudio-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "server")
+	caFile, _ := writeCert(t, dir, "ca")
+
+	// without a client ca, no client certificate is asked
+	r, err := NewTLSReloader(TLSFiles{CertFile: certFile, KeyFile: keyFile, ClientAuth: tls.RequireAndVerifyClientCert})
+	require.NoError(t, err)
+	cfg := clientConfig(t, r)
+	require.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+	require.Nil(t, cfg.ClientCAs)
+	require.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+
+	r, err = NewTLSReloader(TLSFiles{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: tls.VerifyClientCertIfGiven})
+	require.NoError(t, err)
+	cfg = clientConfig(t, r)
+	require.Equal(t, tls.VerifyClientCertIfGiven, cfg.ClientAuth)
+	require.NotNil(t, cfg.ClientCAs)
+	require.Len(t, cfg.ClientCAs.Subjects(), 1)
+}
+
+// freeAddr returns a tcp address nobody listens on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+// requireFree fails when addr is still listened on.
+func requireFree(t *testing.T, addr string) {
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err, "%s was not closed", addr)
+	l.Close()
+}
+
+func TestListen_ClosesOnError(t *testing.T) {
+	addr := freeAddr(t)
+
+	_, err := Listen([]string{addr, "127.0.0.1:nope"}, "", nil)
+	require.Error(t, err)
+	requireFree(t, addr)
+
+	dir, err := ioutil.TempDir("", "bstudio-listen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = Listen([]string{addr}, filepath.Join(dir, "missing", "bstudio.sock"), nil)
+	require.Error(t, err)
+	requireFree(t, addr)
+
+	_, err = Listen(nil, "", nil)
+	require.Error(t, err)
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bstudio-listen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	addr := freeAddr(t)
+
+	// anything else than a socket at the path is not removed
+	path := filepath.Join(dir, "bstudio.sock")
+	require.NoError(t, ioutil.WriteFile(path, []byte("data"), 0600))
+	_, err = Listen([]string{addr}, path, nil)
+	require.Error(t, err)
+	bz, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(bz))
+	requireFree(t, addr)
+
+	// the socket left by a previous run is replaced
+	require.NoError(t, os.Remove(path))
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	listeners, err := Listen(nil, path, nil)
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	defer listeners[0].Close()
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	conn.Close()
+}
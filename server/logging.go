@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+
+	// longer or unprintable request ids sent by the client are replaced
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+type requestRouteKey struct{}
+
+// requestRoute is the route matched by the router, filled in by routeMiddleware for LoggingHandler which wraps it.
+type requestRoute struct {
+	template string
+}
+
+// probeRoutes are polled by the orchestrator and prometheus, they are only logged in debug when they succeed.
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// responseRecorder remembers the status code and counts the bytes written by a handler.
+// It keeps the flushing and hijacking of the writer it wraps, for the streamed responses.
+type responseRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+// recordResponse wraps w, unless a middleware around already records the response.
+func recordResponse(w http.ResponseWriter) *responseRecorder {
+	if rec, ok := w.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder{ResponseWriter: w, code: http.StatusOK}
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T cannot be hijacked", w.ResponseWriter)
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// routeTemplate returns the path template of the matched route, so that the cids do not make a value each.
+func routeTemplate(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if tpl, err := cr.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the id of the request, empty outside of the logging middleware.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLog returns the logger of the request, its lines carry the request id.
+func requestLog(r *http.Request) *zerolog.Logger {
+	if requestID(r) == "" {
+		return &log.Logger
+	}
+	return zerolog.Ctx(r.Context())
+}
+
+// LoggingHandler propagates the X-Request-ID of the client, or assigns one, and logs every request
+// with its route, status, latency and response size. It wraps the whole router, so that the requests
+// matching no route are logged too, and needs routeMiddleware on the router.
+func LoggingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid2.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		logger := log.With().Str("request_id", id).Logger()
+		matched := &requestRoute{}
+		ctx := context.WithValue(logger.WithContext(r.Context()), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, requestRouteKey{}, matched)
+		r = r.WithContext(ctx)
+
+		rec := recordResponse(w)
+		next.ServeHTTP(rec, r)
+
+		route := matched.template
+		if route == "" {
+			route = "unknown"
+		}
+		event := logger.Info()
+		switch {
+		case rec.code >= http.StatusInternalServerError:
+			event = logger.Error()
+		case rec.code >= http.StatusBadRequest:
+			event = logger.Warn()
+		case probeRoutes[route]:
+			event = logger.Debug()
+		}
+		event.
+			Str("method", r.Method).
+			Str("route", route).
+			Str("path", r.URL.Path).
+			Str("remote", r.RemoteAddr).
+			Int("status", rec.code).
+			Dur("latency", time.Since(start)).
+			Int64("bytes_in", r.ContentLength).
+			Int64("bytes_out", rec.bytes).
+			Msg("request")
+	})
+}
+
+// routeMiddleware tells LoggingHandler the route the router matched.
+func routeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matched, ok := r.Context().Value(requestRouteKey{}).(*requestRoute); ok {
+			matched.template = routeTemplate(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	uuid2 "github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLog sends the global logger to a buffer until the returned function is called.
+func captureLog() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+
+	return &buf, func() {
+		log.Logger = logger
+	}
+}
+
+// requestLines returns the request lines logged in buf.
+func requestLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields))
+		if fields["message"] == "request" {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
+
+// echoRouter answers the request id seen by the handler.
+func echoRouter() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/echo/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestID(r)))
+	}).Methods(methodPOST)
+	r.Use(routeMiddleware)
+	return LoggingHandler(r)
+}
+
+func TestLogging_RequestID(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+	h := echoRouter()
+
+	// the id of the client is propagated to the handler and the response
+	req := httptest.NewRequest(http.MethodPost, "/echo/1", strings.NewReader("hello"))
+	req.Header.Set(requestIDHeader, "client-id-1")
+	w := serve(h, req)
+	require.Equal(t, "client-id-1", w.Header().Get(requestIDHeader))
+	require.Equal(t, "client-id-1", w.Body.String())
+
+	// invalid ids are replaced
+	for _, id := range []string{"", "has space", "café", strings.Repeat("a", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodPost, "/echo/1", nil)
+		req.Header.Set(requestIDHeader, id)
+		w := serve(h, req)
+
+		replaced := w.Header().Get(requestIDHeader)
+		require.NotEqual(t, id, replaced)
+		_, err := uuid2.Parse(replaced)
+		require.NoError(t, err, replaced)
+		require.Equal(t, replaced, w.Body.String())
+	}
+
+	lines := requestLines(t, buf)
+	require.Len(t, lines, 5)
+	require.Equal(t, "client-id-1", lines[0]["request_id"])
+}
+
+func TestLogging_Fields(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+	h := echoRouter()
+
+	req := httptest.NewRequest(http.MethodPost, "/echo/QmSomething", strings.NewReader("hello"))
+	req.Header.Set(requestIDHeader, "abc")
+	serve(h, req)
+
+	// the requests matching no route are logged with an id too
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.NotEmpty(t, w.Header().Get(requestIDHeader))
+	w = serve(h, httptest.NewRequest(http.MethodGet, "/echo/1", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	require.NotEmpty(t, w.Header().Get(requestIDHeader))
+
+	lines := requestLines(t, buf)
+	require.Len(t, lines, 3)
+
+	ok := lines[0]
+	require.Equal(t, "info", ok["level"])
+	require.Equal(t, http.MethodPost, ok["method"])
+	require.Equal(t, "/echo/{id}", ok["route"])
+	require.Equal(t, "/echo/QmSomething", ok["path"])
+	require.Equal(t, float64(http.StatusOK), ok["status"])
+	require.Equal(t, float64(5), ok["bytes_in"])
+	require.Equal(t, float64(3), ok["bytes_out"])
+	require.Contains(t, ok, "latency")
+
+	notFound := lines[1]
+	require.Equal(t, "warn", notFound["level"])
+	require.Equal(t, "unknown", notFound["route"])
+	require.Equal(t, float64(http.StatusNotFound), notFound["status"])
+	require.Equal(t, float64(len("404 page not found\n")), notFound["bytes_out"])
+
+	require.Equal(t, float64(http.StatusMethodNotAllowed), lines[2]["status"])
+}
+
+func TestLogging_Router(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	h := LoggingHandler(testRouter(bs))
+
+	// the handlers of the studio log with the id of the request
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	lines := requestLines(t, buf)
+	require.Len(t, lines, 1)
+	require.Equal(t, "/api/v1/usage", lines[0]["route"])
+	require.Equal(t, w.Header().Get(requestIDHeader), lines[0]["request_id"])
+
+	// the probes are logged in debug when they succeed
+	buf.Reset()
+	serve(h, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, "debug", requestLines(t, buf)[0]["level"])
+}
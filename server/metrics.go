@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metricsMiddleware counts the requests and their latency by route template, so that
+// the cids in the paths do not make a series each.
+func metricsMiddleware(bs *bstudio.BStudio) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := recordResponse(w)
+
+			next.ServeHTTP(rec, r)
+
+			bs.Metrics.ObserveRequest(routeTemplate(r), r.Method, strconv.Itoa(rec.code), time.Since(start))
+		})
+	}
+}
+
+// registerMetrics serves the metrics in the prometheus text format on /metrics, when enabled.
+// They need the admin scope, except on the internal connections.
+func registerMetrics(r *mux.Router, bs *bstudio.BStudio) {
+	if bs.Metrics == nil {
+		return
+	}
+
+	r.Handle("/metrics", promhttp.HandlerFor(bs.Metrics.Registry, promhttp.HandlerOpts{})).Methods(methodGET).Name(routeMetrics)
+	r.Use(metricsMiddleware(bs))
+}
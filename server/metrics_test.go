@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetrics_Scope(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Metrics = bstudio.NewMetrics(bs)
+	r := testRouter(bs)
+
+	admin, _, err := bs.CreateAPIKey("admin", []string{bstudio.ScopeAdmin})
+	require.NoError(t, err)
+	uploader, _, err := bs.CreateAPIKey("uploader", []string{bstudio.ScopeUploadImage})
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, serve(r, httptest.NewRequest(http.MethodGet, "/api/v1/schemas/track/v1", nil)).Code)
+
+	require.Equal(t, http.StatusUnauthorized, serve(r, httptest.NewRequest(http.MethodGet, "/metrics", nil)).Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+uploader)
+	require.Equal(t, http.StatusForbidden, serve(r, req).Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	w := serve(r, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	// by route template, not by path
+	require.Contains(t, w.Body.String(), `bstudio_http_requests_total{code="200",method="GET",route="/api/v1/schemas/{type}/{version}"} 1`)
+	require.Contains(t, w.Body.String(), `bstudio_http_requests_total{code="401",method="GET",route="/metrics"} 1`)
+}
+
+func TestMetrics_UnixSocket(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Metrics = bstudio.NewMetrics(bs)
+
+	dir, err := ioutil.TempDir("", "bstudio-metrics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bstudio.sock")
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listeners, err := Listen(nil, path, nil)
+	require.NoError(t, err)
+	listeners = append(listeners, tcp)
+
+	srv := &http.Server{Handler: testRouter(bs), ConnContext: ConnContext}
+	go Serve(srv, listeners)
+	defer srv.Close()
+
+	// no credentials are needed on the unix socket, they still are on tcp
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	res, err := unixClient.Get("http://bstudio/metrics")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get("http://" + tcp.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// only the local routes
+	res, err = unixClient.Get("http://bstudio/api/v1/usage")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestMetrics_StreamedResponse(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Metrics = bstudio.NewMetrics(bs)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// the logging and the metrics share one recorder, which flushes like the writer it wraps
+		rec, ok := w.(*responseRecorder)
+		require.True(t, ok)
+		_, ok = rec.ResponseWriter.(*httptest.ResponseRecorder)
+		require.True(t, ok)
+
+		w.Write([]byte("part"))
+		w.(http.Flusher).Flush()
+	})
+	r.Use(routeMiddleware, metricsMiddleware(bs))
+
+	w := serve(LoggingHandler(r), httptest.NewRequest(http.MethodGet, "/stream", nil))
+	require.True(t, w.Flushed)
+	require.Equal(t, "part", w.Body.String())
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/gorilla/mux"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// requestClient identifies the caller for the rate limits and quotas: its credentials, else its IP.
+func requestClient(r *http.Request) string {
+	if p := requestPrincipal(r); p != nil {
+		if id := p.ClientID(); id != "" {
+			return id
+		}
+	}
+
+	return requestIP(r)
+}
+
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds()))))
+}
+
+// ipRateLimit takes a token from the ip bucket of the caller on every request. It runs before
+// authMiddleware, so that credentials cannot be tried at will; the headers are left to rateLimit.
+func ipRateLimit(bs *bstudio.BStudio) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bs.RateLimiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ip := requestIP(r)
+			res := bs.RateLimiter.Allow(bstudio.RouteGroupIP, ip, time.Now())
+			if !res.Allowed {
+				requestLog(r).Info().Str("client", ip).Str("group", bstudio.RouteGroupIP).Str("path", r.URL.Path).Msg("rate limited")
+				w.Header().Set("Retry-After", retryAfterSeconds(res.RetryAfter))
+				writeJSONResponse(w, http.StatusTooManyRequests, newErrorJson("too many requests, retry later"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimit takes a token from the bucket of the client in group, it runs after authMiddleware
+// so authenticated clients are limited by their credentials rather than their IP.
+func rateLimit(bs *bstudio.BStudio, group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bs.RateLimiter == nil {
+			next(w, r)
+			return
+		}
+
+		client := requestClient(r)
+		res := bs.RateLimiter.Allow(group, client, time.Now())
+		if res.Limit > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(res.Reset.Seconds())))
+		}
+
+		if !res.Allowed {
+			requestLog(r).Info().Str("client", client).Str("group", group).Str("path", r.URL.Path).Msg("rate limited")
+			w.Header().Set("Retry-After", retryAfterSeconds(res.RetryAfter))
+			writeJSONResponse(w, http.StatusTooManyRequests, newErrorJson(fmt.Sprintf("too many %s requests, retry later", group)))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// uploadQuota is the usage an upload request reserved in the quotas of its client,
+// given back by release unless the upload is accepted.
+type uploadQuota struct {
+	bs       *bstudio.BStudio
+	reserved []*bstudio.QuotaReservation
+	accepted bool
+}
+
+// reserve counts d against the quotas of the client. It writes the quota headers, and answers 429
+// and returns false when a quota would be exceeded.
+func (q *uploadQuota) reserve(w http.ResponseWriter, r *http.Request, d bstudio.Usage) bool {
+	if q.bs.Quotas == nil {
+		return true
+	}
+
+	now := time.Now()
+	client := requestClient(r)
+	state, res, err := q.bs.Quotas.Reserve(client, d, now)
+	if state != nil {
+		writeQuotaHeaders(w, state.Remaining(q.bs.Quotas.Limits), now)
+	}
+
+	if qerr, ok := err.(*bstudio.QuotaExceededError); ok {
+		requestLog(r).Info().Str("client", client).Str("period", qerr.Period).Str("field", qerr.Field).Msg("quota exceeded")
+		w.Header().Set("Retry-After", retryAfterSeconds(qerr.Reset.Sub(now)))
+		writeJSONResponse(w, http.StatusTooManyRequests, newErrorJson(qerr.Error()))
+		return false
+	}
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot check quota: %s", err)))
+		return false
+	}
+	q.reserved = append(q.reserved, res)
+
+	return true
+}
+
+// reserveBody reserves one upload and the declared size of the body, before reading it.
+func (q *uploadQuota) reserveBody(w http.ResponseWriter, r *http.Request) bool {
+	size := r.ContentLength
+	if size < 0 {
+		size = 0
+	}
+
+	return q.reserve(w, r, bstudio.Usage{Uploads: 1, Bytes: size})
+}
+
+// reserveFile reserves the size of the file when the body had no declared size.
+func (q *uploadQuota) reserveFile(w http.ResponseWriter, r *http.Request, size int64) bool {
+	if r.ContentLength >= 0 {
+		return true
+	}
+
+	return q.reserve(w, r, bstudio.Usage{Bytes: size})
+}
+
+// reserveMinutes reserves the transcoding minutes of the upload from its probed duration.
+func (q *uploadQuota) reserveMinutes(w http.ResponseWriter, r *http.Request, upload *bstudio.Upload) bool {
+	if q.bs.Quotas == nil {
+		return true
+	}
+
+	minutes, err := upload.Minutes(r.Context())
+	if err != nil {
+		writeJSONResponse(w, http.StatusUnsupportedMediaType, newErrorJson(fmt.Sprintf("Cannot read the duration of the file: %s", err)))
+		return false
+	}
+
+	return q.reserve(w, r, bstudio.Usage{Minutes: minutes})
+}
+
+// accept keeps the reserved usage, the upload went through.
+func (q *uploadQuota) accept() {
+	q.accepted = true
+}
+
+// release gives the reserved usage back unless the upload was accepted, the handlers defer it.
+func (q *uploadQuota) release(r *http.Request) {
+	if q.accepted {
+		return
+	}
+
+	for _, res := range q.reserved {
+		if err := res.Cancel(); err != nil {
+			requestLog(r).Error().Err(err).Str("client", requestClient(r)).Msg("cannot release upload quota")
+		}
+	}
+}
+
+func writeQuotaHeaders(w http.ResponseWriter, rem bstudio.QuotaRemaining, now time.Time) {
+	if rem.Reset.IsZero() {
+		return
+	}
+
+	if rem.Uploads != nil {
+		w.Header().Set("X-RateLimit-Quota-Uploads-Remaining", strconv.FormatInt(*rem.Uploads, 10))
+	}
+	if rem.Bytes != nil {
+		w.Header().Set("X-RateLimit-Quota-Bytes-Remaining", strconv.FormatInt(*rem.Bytes, 10))
+	}
+	if rem.Minutes != nil {
+		w.Header().Set("X-RateLimit-Quota-Minutes-Remaining", strconv.FormatFloat(*rem.Minutes, 'f', 1, 64))
+	}
+	w.Header().Set("X-RateLimit-Quota-Reset", strconv.Itoa(int(rem.Reset.Sub(now).Seconds())))
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_Headers(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.RateLimiter = bstudio.NewRateLimiter(map[string]bstudio.RateLimit{
+		bstudio.RouteGroupRead: {Rate: 60, Burst: 2},
+	})
+	r := testRouter(bs)
+
+	for _, remaining := range []string{"1", "0"} {
+		w := serve(r, httptest.NewRequest(http.MethodGet, "/api/v1/schemas/track/v1", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+		require.Equal(t, remaining, w.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/api/v1/schemas/track/v1", nil))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// another ip has its own bucket
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/schemas/track/v1", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	require.Equal(t, http.StatusOK, serve(r, req).Code)
+}
+
+func TestRateLimit_IPBeforeAuth(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.RateLimiter = bstudio.NewRateLimiter(map[string]bstudio.RateLimit{
+		bstudio.RouteGroupIP: {Rate: 1, Burst: 2},
+	})
+	r := testRouter(bs)
+
+	// guessing api keys is limited by ip, before they are checked
+	codes := make([]int, 3)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil)
+		req.Header.Set("X-API-Key", "bsk_guess.secret")
+		codes[i] = serve(r, req).Code
+	}
+	require.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+}
+
+// readCounter counts the bytes read from the body.
+type readCounter struct {
+	io.Reader
+	n int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestUploadQuota(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Auth = false
+	bs.Quotas = bstudio.NewQuotas(bs.Ds, bstudio.QuotaLimits{Daily: bstudio.Usage{Uploads: 5, Bytes: 1000}})
+	r := testRouter(bs)
+
+	// the declared size is over the quota, the body is not read
+	body := &readCounter{Reader: bytes.NewReader(make([]byte, 2000))}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/image", body)
+	req.ContentLength = 2000
+	w := serve(r, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "5", w.Header().Get("X-RateLimit-Quota-Uploads-Remaining"))
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+	require.Zero(t, body.n)
+
+	// a rejected upload gives its reservation back
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	require.NoError(t, mw.WriteField("presets", "cover"))
+	require.NoError(t, mw.Close())
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/upload/image", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	require.Equal(t, http.StatusBadRequest, serve(r, req).Code)
+
+	s, err := bs.Quotas.State(requestIP(req), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, bstudio.Usage{}, s.Daily)
+}
@@ -10,4 +10,8 @@
 
 // @host localhost:1347
 // @BasePath /api/v1
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 package server
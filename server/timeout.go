@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"net"
+	"net/http"
+	"time"
+)
+
+type connContextKey struct{}
+
+// ConnContext keeps the connection in the context of its requests, for uploadTimeout.
+// It is meant for http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// uploadTimeout replaces the read and write deadlines of the server with bs.UploadTimeout,
+// so that large uploads are not cut off by the timeouts meant for the other routes.
+// Over HTTP/2 the deadlines are those of the connection shared by the streams, they are only extended.
+func uploadTimeout(bs *bstudio.BStudio, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(connContextKey{}).(net.Conn); ok && bs.UploadTimeout > 0 {
+			deadline := time.Now().Add(bs.UploadTimeout)
+			if err := c.SetReadDeadline(deadline); err != nil {
+				requestLog(r).Warn().Err(err).Msg("cannot extend the upload read deadline")
+			}
+			if err := c.SetWriteDeadline(deadline); err != nil {
+				requestLog(r).Warn().Err(err).Msg("cannot extend the upload write deadline")
+			}
+		}
+
+		next(w, r)
+	}
+}
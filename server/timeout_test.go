@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// deadlineConn records the deadlines set on it.
+type deadlineConn struct {
+	net.Conn
+	read, write time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.read = t
+	return nil
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.write = t
+	return nil
+}
+
+func TestUploadTimeout(t *testing.T) {
+	bs := &bstudio.BStudio{UploadTimeout: time.Hour}
+	h := uploadTimeout(bs, func(w http.ResponseWriter, r *http.Request) {})
+
+	conn := &deadlineConn{}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/upload/video", nil)
+	r = r.WithContext(ConnContext(context.Background(), conn))
+	h(httptest.NewRecorder(), r)
+
+	require.WithinDuration(t, time.Now().Add(time.Hour), conn.read, time.Minute)
+	require.Equal(t, conn.read, conn.write)
+
+	// without the connection in the context the request goes through untouched
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/upload/video", nil))
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"net/http"
+	"time"
+)
+
+type UsageReportResp struct {
+	From    time.Time                 `json:"from"`
+	To      time.Time                 `json:"to"`
+	GroupBy string                    `json:"group_by"`
+	Rows    []*bstudio.UsageReportRow `json:"rows"`
+}
+
+// imageJob meters an image upload from its decoding, its usage is recorded once it ends whatever the outcome.
+type imageJob struct {
+	meter  *bstudio.CPUMeter
+	cpu    time.Duration
+	image  *bstudio.Img
+	status string
+	cid    string
+}
+
+func startImageJob() *imageJob {
+	return &imageJob{meter: bstudio.StartCPUMeter(), status: bstudio.UsageFailed}
+}
+
+// stopMeter stops measuring the cpu, before the slow calls to ipfs which do not render anything.
+func (j *imageJob) stopMeter() {
+	if j.meter != nil {
+		j.cpu += j.meter.Stop()
+		j.meter = nil
+	}
+}
+
+// succeed marks the job succeeded, cid is the directory added or empty when nothing was stored.
+func (j *imageJob) succeed(cid string) {
+	j.status = bstudio.UsageSucceeded
+	j.cid = cid
+}
+
+func (j *imageJob) record(r *http.Request, bs *bstudio.BStudio, inputBytes int64) {
+	j.stopMeter()
+
+	cpu := j.cpu
+	if j.image != nil {
+		cpu += j.image.ExecCPU()
+	}
+	recordImageUsage(r, bs, j.status, j.cid, inputBytes, cpu)
+}
+
+// recordImageUsage records the usage event of an image job, its directory holds the original and the renditions.
+// Nothing is pinned without a cid, only the input and the cpu are billed then.
+func recordImageUsage(r *http.Request, bs *bstudio.BStudio, status, cid string, inputBytes int64, cpu time.Duration) {
+	e := &bstudio.UsageEvent{
+		Client:     requestClient(r),
+		Job:        bstudio.MediaImage,
+		Status:     status,
+		Cid:        cid,
+		InputBytes: inputBytes,
+		CPUSeconds: cpu.Seconds(),
+	}
+	if p := requestPrincipal(r); p != nil {
+		e.APIKeyID = p.APIKeyID
+		e.Address = p.Address
+	}
+
+	if cid != "" {
+		size, err := bs.PinnedSize(cid)
+		if err != nil {
+			requestLog(r).Error().Err(err).Str("cid", cid).Msg("cannot measure pinned size")
+		}
+		e.AddArtifact("renditions", cid, size)
+		e.PinnedBytes = size
+	}
+
+	if err := bs.RecordUsage(e); err != nil {
+		requestLog(r).Error().Err(err).Str("cid", cid).Msg("cannot record usage")
+	}
+}
+
+// @Summary Get a usage report
+// @Description Sum the usage events of the jobs per client, and per UTC day or month, for billing.
+// @Tags usage
+// @Produce json
+// @Param from query string false "Start, 2006-01-02 or RFC 3339, default the first day of the month"
+// @Param to query string false "End excluded, 2006-01-02 or RFC 3339, default now"
+// @Param client query string false "Only this client, e.g. key:<id> or address:<address>"
+// @Param group_by query string false "client (default), day or month"
+// @Success 200 {object} server.UsageReportResp
+// @Failure 400 {object} server.ErrorJson "Invalid parameters"
+// @Security ApiKeyAuth
+// @Failure 401 {object} server.ErrorJson "Missing or invalid api key"
+// @Failure 403 {object} server.ErrorJson "The api key lacks the scope"
+// @Router /usage [get]
+func usageReportHandler(bs *bstudio.BStudio) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UTC()
+		res := UsageReportResp{
+			From:    time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+			To:      now,
+			GroupBy: bstudio.UsageGroupClient,
+		}
+
+		var err error
+		if v := r.FormValue("from"); v != "" {
+			if res.From, err = bstudio.ParseUsageTime(v); err != nil {
+				writeJSONResponse(w, http.StatusBadRequest, newErrorJson(err.Error()))
+				return
+			}
+		}
+		if v := r.FormValue("to"); v != "" {
+			if res.To, err = bstudio.ParseUsageTime(v); err != nil {
+				writeJSONResponse(w, http.StatusBadRequest, newErrorJson(err.Error()))
+				return
+			}
+		}
+		if !res.From.Before(res.To) {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson("from must be before to"))
+			return
+		}
+		if v := r.FormValue("group_by"); v != "" {
+			res.GroupBy = v
+		}
+
+		events, err := bs.ListUsage(res.From, res.To, r.FormValue("client"))
+		if err != nil {
+			writeJSONResponse(w, http.StatusInternalServerError, newErrorJson(fmt.Sprintf("Cannot list usage: %s", err)))
+			return
+		}
+
+		if res.Rows, err = bstudio.AggregateUsage(events, res.GroupBy); err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, newErrorJson(err.Error()))
+			return
+		}
+
+		writeJSONResponse(w, http.StatusOK, res)
+	}
+}
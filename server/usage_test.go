@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bytes"
+	"github.com/bitsongofficial/bstudio/bstudio"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestImageUsage_Failed(t *testing.T) {
+	bs, cleanup := testStudio(t)
+	defer cleanup()
+	bs.Auth = false
+	r := testRouter(bs)
+
+	// a png signature without an image fails at the decoding
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("file", "broken.png")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("\x89PNG\r\n\x1a\n broken"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/image", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	require.NotEqual(t, http.StatusOK, serve(r, req).Code)
+
+	events, err := bs.ListUsage(time.Now().Add(-time.Minute), time.Now().Add(time.Minute), "")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, bstudio.MediaImage, events[0].Job)
+	require.Equal(t, bstudio.UsageFailed, events[0].Status)
+	require.Empty(t, events[0].Cid)
+	require.Equal(t, int64(15), events[0].InputBytes)
+}